@@ -3,37 +3,96 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	shrink "github.com/derek-schaefer/shrink-my-url"
 	"github.com/redis/go-redis/v9"
 )
 
+// How long requests in flight are given to finish when shutting down.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	httpAddr := flag.String("httpAddr", ":8080", "address to listen on")
+	storeType := flag.String("store", "redis", "type of store to use: redis, sqlite, postgres or memory")
 	redisAddr := flag.String("redisAddr", "redis://localhost:6379/0", "address of the redis server")
+	asyncVisits := flag.Bool("asyncVisits", false, "count visits to redis in background batches instead of on every redirect")
+	visitBuffer := flag.Int("visitBuffer", 10000, "number of visits buffered for counting in the background, beyond which they are dropped")
+	visitFlushInterval := flag.Duration("visitFlushInterval", time.Second, "how often visits counted in the background are flushed")
+	cacheSize := flag.Int("cacheSize", 0, "number of links cached in memory for redirects, disabled if zero")
+	cacheTTL := flag.Duration("cacheTTL", time.Minute, "how long links are cached before they are read from the store again")
+	memoryDir := flag.String("memoryDir", "", "directory to persist the memory store to, disabled if empty")
+	memorySync := flag.String("memorySync", "everysec", "how often the memory store log is synced: always, everysec or never")
+	sqlitePath := flag.String("sqlitePath", "shrink.db", "path of the sqlite database file")
+	postgresURL := flag.String("postgresURL", "postgres://localhost:5432/shrink", "URL of the postgres database")
 	expiration := flag.Duration("expiration", 24*time.Hour, "expiration time for shortened URLs")
+	visitorSalt := flag.String("visitorSalt", os.Getenv("VISITOR_SALT"), "secret for hashing visitors to count unique visitors, random if empty")
+	statsRetention := flag.Duration("statsRetention", 90*24*time.Hour, "how long hourly and daily visit stats are kept")
+	idStrategy := flag.String("ids", "random", "strategy for generating IDs: random or counter")
+	dedup := flag.Bool("dedup", false, "return the existing short URL when the same URL is shortened again")
 	maxRetries := flag.Uint("maxRetries", 5, "maximum number of retries when generating a short URL")
+	alphabet := flag.String("alphabet", "", "characters used in generated IDs, or \"unambiguous\" to avoid confusable characters")
+	minLength := flag.Uint("minLength", 0, "minimum length of generated IDs, up to 255")
+	blocklistPath := flag.String("blocklist", "", "path of a file of words generated IDs may not contain, one per line")
+	normalize := flag.Bool("normalize", true, "normalize URLs before storing them so that equivalent URLs match")
+	stripTracking := flag.Bool("stripTracking", false, "remove tracking query parameters such as utm_* and fbclid from URLs")
+	allowSchemes := flag.String("allowSchemes", "http,https", "comma-separated schemes that URLs may use")
+	allowPrivate := flag.Bool("allowPrivate", false, "allow URLs to private, loopback and link-local addresses")
+	maxURLLength := flag.Int("maxURLLength", 2048, "maximum length of a URL in bytes, unlimited if zero")
+	domainsPath := flag.String("domains", "", "path of a file of allowed and denied domains, reloaded when it changes")
+	adminToken := flag.String("adminToken", os.Getenv("ADMIN_TOKEN"), "bearer token for the admin API, disabled if empty")
+	hosts := flag.String("hosts", "", "comma-separated hosts this service is served under, in addition to the request host")
+	followShorteners := flag.Bool("followShorteners", true, "follow links to other known URL shorteners to detect chains and loops")
+	resolveShortLinks := flag.Bool("resolveShortLinks", false, "store the final destination of short links instead of rejecting links to this service")
+	maxRedirects := flag.Int("maxRedirects", 3, "maximum number of short links to follow")
+	inactiveURL := flag.String("inactiveURL", "", "URL to redirect visits of links outside their activation window to")
+	inactivePage := flag.String("inactivePage", "", "path of an HTML page shown for links outside their activation window")
+	trashRetention := flag.Duration("trashRetention", 30*24*time.Hour, "how long deleted links can be restored before they are purged")
+	purgeInterval := flag.Duration("purgeInterval", time.Hour, "how often deleted links past their retention are purged")
 	devMode := flag.Bool("dev", false, "enable development mode")
 
 	flag.Parse()
 
 	random := rand.New(rand.NewSource(time.Now().UnixNano()))
 
-	redisOptions, err := redis.ParseURL(*redisAddr)
+	var store shrink.Store
+	var err error
 
-	if err != nil {
-		log.Fatal(err)
+	switch *storeType {
+	case "redis":
+		store, err = newRedisStore(*redisAddr, shrink.RedisStoreOptions{
+			Expiration:         *expiration,
+			StatsRetention:     *statsRetention,
+			AsyncVisits:        *asyncVisits,
+			VisitBufferSize:    *visitBuffer,
+			VisitFlushInterval: *visitFlushInterval,
+		})
+	case "sqlite":
+		store, err = shrink.NewSQLiteStore(shrink.SQLiteStoreOptions{
+			Path:           *sqlitePath,
+			Expiration:     *expiration,
+			StatsRetention: *statsRetention,
+		})
+	case "postgres":
+		store, err = shrink.NewPostgresStore(shrink.PostgresStoreOptions{
+			URL:            *postgresURL,
+			Expiration:     *expiration,
+			StatsRetention: *statsRetention,
+		})
+	case "memory":
+		store, err = newMemoryStore(*memoryDir, *memorySync, *expiration, *statsRetention)
+	default:
+		err = fmt.Errorf("unknown store: %s", *storeType)
 	}
 
-	store, err := shrink.NewRedisStore(shrink.RedisStoreOptions{
-		Options:    *redisOptions,
-		Expiration: *expiration,
-	})
-
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -46,16 +105,186 @@ func main() {
 		log.Fatal(err)
 	}
 
-	shortener := shrink.NewShortener(shrink.ShortenerOptions{
-		Store:      store,
-		Random:     random,
-		MaxRetries: *maxRetries,
-	})
+	if *alphabet == "unambiguous" {
+		*alphabet = shrink.UnambiguousAlphabet
+	}
+
+	if *minLength > 255 {
+		log.Fatalf("minLength must be at most 255: %d", *minLength)
+	}
+
+	var blocklist []string
+
+	if *blocklistPath != "" {
+		blocklist, err = shrink.LoadBlocklist(*blocklistPath)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var ids shrink.IDGenerator
+
+	switch *idStrategy {
+	case "random":
+	case "counter":
+		counter, ok := store.(shrink.Counter)
+
+		if !ok {
+			log.Fatalf("store does not support counter IDs: %s", *storeType)
+		}
+
+		ids = shrink.CounterIDGenerator{Counter: counter}
+	default:
+		log.Fatalf("unknown ID strategy: %s", *idStrategy)
+	}
+
+	if _, ok := store.(shrink.Deduplicator); *dedup && !ok {
+		log.Fatalf("store does not support deduplication: %s", *storeType)
+	}
+
+	if *cacheSize > 0 {
+		if _, ok := store.(shrink.VisitCounter); !ok {
+			log.Printf("store does not support caching, links will not be cached: %s", *storeType)
+		}
+
+		if *storeType == "redis" && !*asyncVisits {
+			log.Printf("visits to cached links are still written to redis on every redirect, use -asyncVisits to count them in the background")
+		}
+
+		store = shrink.NewCachedStore(shrink.CachedStoreOptions{Store: store, Size: *cacheSize, TTL: *cacheTTL})
+	}
+
+	var normalizers []shrink.Normalizer
+
+	if *normalize {
+		normalizers = append(normalizers, shrink.DefaultNormalizers...)
+	}
+
+	if *stripTracking {
+		normalizers = append(normalizers, shrink.StripQueryParams(shrink.DefaultTrackingParams...))
+	}
+
+	var knownShorteners []string
+
+	if *followShorteners {
+		knownShorteners = shrink.DefaultKnownShorteners
+	}
+
+	var domains *shrink.DomainFilter
+
+	if *domainsPath != "" {
+		domains, err = shrink.NewDomainFilter(shrink.DomainFilterOptions{Path: *domainsPath})
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer domains.Close()
+	}
+
+	shortenerOptions := shrink.ShortenerOptions{
+		Store:       store,
+		Random:      random,
+		MaxRetries:  *maxRetries,
+		IDGenerator: ids,
+		Deduplicate: *dedup,
+		Alphabet:    *alphabet,
+		MinLength:   uint8(*minLength),
+		Blocklist:   blocklist,
+		Normalizers: normalizers,
+		Policy: &shrink.URLPolicy{
+			AllowedSchemes: splitList(*allowSchemes),
+			MaxLength:      *maxURLLength,
+			AllowPrivate:   *allowPrivate,
+		},
+		Domains:           domains,
+		Hosts:             splitList(*hosts),
+		KnownShorteners:   knownShorteners,
+		ResolveShortLinks: *resolveShortLinks,
+		MaxRedirects:      *maxRedirects,
+		TrashRetention:    *trashRetention,
+		VisitorSalt:       *visitorSalt,
+	}
+
+	if err := shrink.ValidateIDOptions(shortenerOptions); err != nil {
+		log.Fatalf("invalid ID options: %v", err)
+	}
+
+	shortener := shrink.NewShortener(shortenerOptions)
+
+	purger := shrink.NewTrashPurger(shrink.TrashPurgerOptions{Shortener: shortener, Interval: *purgeInterval})
+
+	defer purger.Close()
 
 	router := shrink.NewRouter(shrink.RouterOptions{
-		DevMode:   *devMode,
-		Shortener: shortener,
+		DevMode:      *devMode,
+		Shortener:    shortener,
+		AdminToken:   *adminToken,
+		InactiveURL:  *inactiveURL,
+		InactivePage: *inactivePage,
+	})
+
+	server := &http.Server{Addr: *httpAddr, Handler: router.Routes()}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+
+	// Finish the requests in flight, then let the deferred closes flush the store.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+}
+
+// Create a memory store, persisted to the given directory if it is not empty.
+func newMemoryStore(dir, sync string, expiration, statsRetention time.Duration) (*shrink.MemoryStore, error) {
+	policy, err := shrink.ParseSyncPolicy(sync)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return shrink.NewMemoryStore(shrink.MemoryStoreOptions{
+		Expiration:     expiration,
+		Dir:            dir,
+		Sync:           policy,
+		StatsRetention: statsRetention,
 	})
+}
+
+// Create a Redis store from the given URL with the given options.
+func newRedisStore(addr string, ops shrink.RedisStoreOptions) (*shrink.RedisStore, error) {
+	redisOptions, err := redis.ParseURL(addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ops.Options = *redisOptions
+
+	return shrink.NewRedisStore(ops)
+}
+
+// Split a comma-separated list, ignoring blank items.
+func splitList(s string) []string {
+	var items []string
+
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
 
-	log.Fatal(http.ListenAndServe(*httpAddr, router.Routes()))
+	return items
 }
@@ -0,0 +1,412 @@
+package shrinkmyurl
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/idna"
+)
+
+const defaultDomainReloadInterval = 5 * time.Second
+
+// Characters and structure allowed in a domain pattern once the wildcard or leading dot is removed.
+var domainPattern = regexp.MustCompile(`^[a-z0-9_]([a-z0-9_-]*[a-z0-9_])?(\.[a-z0-9_]([a-z0-9_-]*[a-z0-9_])?)*$`)
+
+// A list of domain patterns in a DomainFilter.
+type DomainList string
+
+const (
+	// Domains that links are limited to, if the list is not empty.
+	AllowList DomainList = "allow"
+	// Domains that links may not point to, even if allowed.
+	DenyList DomainList = "deny"
+)
+
+// Options for the domain filter.
+type DomainFilterOptions struct {
+	// File to load the lists from, watched and reloaded when it changes. Empty keeps the lists in memory only.
+	// Each line holds a list name and a pattern, such as "deny bad.example", and # starts a comment.
+	Path string
+	// How often the file is checked for changes. Defaults to five seconds.
+	ReloadInterval time.Duration
+}
+
+// Filters links by the domain of their host using allow and deny lists.
+// A pattern is either an exact domain such as "example.com", a wildcard such as "*.example.com" that matches
+// any subdomain but not the domain itself, or a suffix such as ".example.com" that matches the domain and
+// all of its subdomains. Deny patterns take precedence over allow patterns.
+type DomainFilter struct {
+	DomainFilterOptions
+
+	mu      sync.RWMutex
+	lists   map[DomainList][]string
+	modTime time.Time
+	size    int64
+
+	done chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// Create a new domain filter with the given options, loading the file and watching it for changes.
+func NewDomainFilter(ops DomainFilterOptions) (*DomainFilter, error) {
+	if ops.ReloadInterval <= 0 {
+		ops.ReloadInterval = defaultDomainReloadInterval
+	}
+
+	f := &DomainFilter{
+		DomainFilterOptions: ops,
+		lists:               make(map[DomainList][]string),
+		done:                make(chan struct{}),
+	}
+
+	if ops.Path == "" {
+		return f, nil
+	}
+
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+
+	f.wg.Add(1)
+	go f.watch()
+
+	return f, nil
+}
+
+// Close the domain filter, stopping its watcher.
+func (f *DomainFilter) Close() error {
+	f.once.Do(func() {
+		close(f.done)
+		f.wg.Wait()
+	})
+
+	return nil
+}
+
+// Check that the host is allowed, returning a *PolicyError if it is not.
+func (f *DomainFilter) Check(host string) error {
+	host = canonicalDomain(host)
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if matchDomain(host, f.lists[DenyList]) {
+		return &PolicyError{Reason: "domain is not allowed: " + host}
+	}
+
+	if allow := f.lists[AllowList]; len(allow) > 0 && !matchDomain(host, allow) {
+		return &PolicyError{Reason: "domain is not allowed: " + host}
+	}
+
+	return nil
+}
+
+// Get a copy of the patterns in each list.
+func (f *DomainFilter) Lists() map[DomainList][]string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return map[DomainList][]string{
+		AllowList: slices.Clone(f.lists[AllowList]),
+		DenyList:  slices.Clone(f.lists[DenyList]),
+	}
+}
+
+// Add a pattern to a list, saving it to the file if there is one.
+func (f *DomainFilter) Add(list DomainList, pattern string) error {
+	pattern, err := parseDomainPattern(list, pattern)
+
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if slices.Contains(f.lists[list], pattern) {
+		return nil
+	}
+
+	if err := f.rewrite(func(lines []string) []string {
+		return append(lines, fmt.Sprintf("%s %s", list, pattern))
+	}); err != nil {
+		return err
+	}
+
+	f.lists[list] = append(f.lists[list], pattern)
+
+	return nil
+}
+
+// Remove a pattern from a list, saving it to the file if there is one.
+// Returns ErrNil if the pattern is not in the list.
+func (f *DomainFilter) Remove(list DomainList, pattern string) error {
+	pattern, err := parseDomainPattern(list, pattern)
+
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	i := slices.Index(f.lists[list], pattern)
+
+	if i < 0 {
+		return ErrNil
+	}
+
+	if err := f.rewrite(func(lines []string) []string {
+		return slices.DeleteFunc(lines, func(line string) bool {
+			l, p, err := parseDomainLine(line)
+
+			return err == nil && l == list && p == pattern
+		})
+	}); err != nil {
+		return err
+	}
+
+	f.lists[list] = slices.Delete(f.lists[list], i, i+1)
+
+	return nil
+}
+
+// Load the lists from the file, replacing the current lists. A missing file is treated as empty.
+func (f *DomainFilter) Reload() error {
+	info, err := os.Stat(f.Path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		f.lists = make(map[DomainList][]string)
+		f.modTime = time.Time{}
+		f.size = 0
+
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	lists, err := loadDomainLists(f.Path)
+
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.lists = lists
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+
+	return nil
+}
+
+// Periodically reload the file if it has changed until the filter is closed.
+func (f *DomainFilter) watch() {
+	defer f.wg.Done()
+
+	ticker := time.NewTicker(f.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+			if !f.changed() {
+				continue
+			}
+
+			if err := f.Reload(); err != nil {
+				log.Printf("domain filter: reload failed: %v", err)
+			}
+		}
+	}
+}
+
+// Report whether the file has changed since it was last loaded.
+func (f *DomainFilter) changed() bool {
+	info, err := os.Stat(f.Path)
+
+	if err != nil {
+		return false
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return !info.ModTime().Equal(f.modTime) || info.Size() != f.size
+}
+
+// Atomically rewrite the lines of the file, if there is one, keeping comments and unrelated lines.
+// Must be called while holding the write lock.
+func (f *DomainFilter) rewrite(edit func(lines []string) []string) error {
+	if f.Path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(f.Path)
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	var lines []string
+
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+
+	var buf bytes.Buffer
+
+	for _, line := range edit(lines) {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".*.tmp")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), f.Path); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(f.Path)
+
+	if err != nil {
+		return err
+	}
+
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+
+	return nil
+}
+
+// Load the lists from a file with one list name and pattern per line, ignoring blank lines and # comments.
+func loadDomainLists(path string) (map[DomainList][]string, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	lists := make(map[DomainList][]string)
+	scanner := bufio.NewScanner(file)
+
+	for n := 1; scanner.Scan(); n++ {
+		list, pattern, err := parseDomainLine(scanner.Text())
+
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+
+		if pattern != "" && !slices.Contains(lists[list], pattern) {
+			lists[list] = append(lists[list], pattern)
+		}
+	}
+
+	return lists, scanner.Err()
+}
+
+// Parse a line of a domain list file, returning an empty pattern for blank lines and comments.
+func parseDomainLine(line string) (DomainList, string, error) {
+	line, _, _ = strings.Cut(line, "#")
+	fields := strings.Fields(line)
+
+	if len(fields) == 0 {
+		return "", "", nil
+	}
+
+	if len(fields) != 2 {
+		return "", "", ErrInvalidDomain
+	}
+
+	list := DomainList(strings.ToLower(fields[0]))
+	pattern, err := parseDomainPattern(list, fields[1])
+
+	return list, pattern, err
+}
+
+// Validate a list name and pattern, returning the pattern in canonical form.
+func parseDomainPattern(list DomainList, pattern string) (string, error) {
+	if list != AllowList && list != DenyList {
+		return "", ErrInvalidDomainList
+	}
+
+	prefix := ""
+
+	if rest, ok := strings.CutPrefix(pattern, "*."); ok {
+		prefix, pattern = "*.", rest
+	} else if rest, ok := strings.CutPrefix(pattern, "."); ok {
+		prefix, pattern = ".", rest
+	}
+
+	pattern = canonicalDomain(pattern)
+
+	if !domainPattern.MatchString(pattern) {
+		return "", ErrInvalidDomain
+	}
+
+	return prefix + pattern, nil
+}
+
+// Lowercase a domain, removing any trailing dot and encoding international names as punycode.
+func canonicalDomain(domain string) string {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+
+	if ascii, err := idna.Lookup.ToASCII(domain); err == nil {
+		return ascii
+	}
+
+	return domain
+}
+
+// Report whether the domain matches any of the patterns.
+func matchDomain(domain string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if rest, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(domain, "."+rest) {
+				return true
+			}
+		} else if rest, ok := strings.CutPrefix(pattern, "."); ok {
+			if domain == rest || strings.HasSuffix(domain, pattern) {
+				return true
+			}
+		} else if domain == pattern {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,111 @@
+package shrinkmyurl_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	shrink "github.com/derek-schaefer/shrink-my-url"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomainFilterCheck(t *testing.T) {
+	filter := shrink.Must(shrink.NewDomainFilter(shrink.DomainFilterOptions{}))
+	defer filter.Close()
+
+	assert.Nil(t, filter.Check("anything.com"))
+
+	assert.Nil(t, filter.Add(shrink.DenyList, "bad.com"))
+	assert.Nil(t, filter.Add(shrink.DenyList, "*.worse.com"))
+	assert.Nil(t, filter.Add(shrink.DenyList, ".RU"))
+
+	assert.Nil(t, filter.Check("good.com"))
+	assert.Nil(t, filter.Check("sub.bad.com"))
+	assert.Nil(t, filter.Check("worse.com"))
+	assert.Nil(t, filter.Check("notbad.com"))
+
+	assert.ErrorIs(t, filter.Check("Bad.com."), shrink.ErrPolicy)
+	assert.ErrorIs(t, filter.Check("a.b.worse.com"), shrink.ErrPolicy)
+	assert.ErrorIs(t, filter.Check("ru"), shrink.ErrPolicy)
+	assert.ErrorIs(t, filter.Check("example.ru"), shrink.ErrPolicy)
+
+	assert.Nil(t, filter.Add(shrink.AllowList, ".example.com"))
+	assert.Nil(t, filter.Add(shrink.AllowList, "bücher.de"))
+
+	assert.Nil(t, filter.Check("example.com"))
+	assert.Nil(t, filter.Check("www.example.com"))
+	assert.Nil(t, filter.Check("xn--bcher-kva.de"))
+
+	assert.ErrorIs(t, filter.Check("good.com"), shrink.ErrPolicy)
+	assert.ErrorIs(t, filter.Check("badexample.com"), shrink.ErrPolicy)
+}
+
+func TestDomainFilterAddRemove(t *testing.T) {
+	filter := shrink.Must(shrink.NewDomainFilter(shrink.DomainFilterOptions{}))
+	defer filter.Close()
+
+	assert.Equal(t, shrink.ErrInvalidDomainList, filter.Add("block", "bad.com"))
+	assert.Equal(t, shrink.ErrInvalidDomain, filter.Add(shrink.DenyList, "bad..com"))
+	assert.Equal(t, shrink.ErrInvalidDomain, filter.Add(shrink.DenyList, "*bad.com"))
+
+	assert.Nil(t, filter.Add(shrink.DenyList, "bad.com"))
+	assert.Nil(t, filter.Add(shrink.DenyList, "BAD.com"))
+
+	assert.Equal(t, []string{"bad.com"}, filter.Lists()[shrink.DenyList])
+
+	assert.Nil(t, filter.Remove(shrink.DenyList, "bad.com"))
+	assert.Equal(t, shrink.ErrNil, filter.Remove(shrink.DenyList, "bad.com"))
+
+	assert.Empty(t, filter.Lists()[shrink.DenyList])
+}
+
+func TestDomainFilterFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domains.txt")
+
+	assert.Nil(t, os.WriteFile(path, []byte("# abuse\ndeny bad.com\n\nallow .example.com # ours\n"), 0o644))
+
+	filter := shrink.Must(shrink.NewDomainFilter(shrink.DomainFilterOptions{Path: path}))
+	defer filter.Close()
+
+	assert.Equal(t, map[shrink.DomainList][]string{
+		shrink.AllowList: {".example.com"},
+		shrink.DenyList:  {"bad.com"},
+	}, filter.Lists())
+
+	assert.Nil(t, filter.Add(shrink.DenyList, "worse.com"))
+	assert.Nil(t, filter.Remove(shrink.DenyList, "bad.com"))
+
+	data := shrink.Must(os.ReadFile(path))
+
+	assert.Equal(t, "# abuse\n\nallow .example.com # ours\ndeny worse.com\n", string(data))
+}
+
+func TestDomainFilterFileInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domains.txt")
+
+	assert.Nil(t, os.WriteFile(path, []byte("deny bad.com\nblock worse.com\n"), 0o644))
+
+	_, err := shrink.NewDomainFilter(shrink.DomainFilterOptions{Path: path})
+
+	assert.ErrorIs(t, err, shrink.ErrInvalidDomainList)
+}
+
+func TestDomainFilterReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domains.txt")
+
+	filter := shrink.Must(shrink.NewDomainFilter(shrink.DomainFilterOptions{
+		Path:           path,
+		ReloadInterval: 10 * time.Millisecond,
+	}))
+
+	defer filter.Close()
+
+	assert.Nil(t, filter.Check("bad.com"))
+
+	assert.Nil(t, os.WriteFile(path, []byte("deny bad.com\n"), 0o644))
+
+	assert.Eventually(t, func() bool {
+		return filter.Check("bad.com") != nil
+	}, time.Second, 10*time.Millisecond)
+}
@@ -1,21 +1,72 @@
 package shrinkmyurl
 
 import (
+	"database/sql"
 	"errors"
+	"fmt"
 
 	"github.com/redis/go-redis/v9"
 )
 
 var (
-	ErrDoesNotExist      = errors.New("shortener: id does not exist")
-	ErrExists            = errors.New("store: key already exists")
-	ErrInvalidURL        = errors.New("shortener: invalid URL")
-	ErrMaxRetries        = errors.New("shortener: max retries exceeded")
-	ErrNil               = errors.New("store: key not found")
-	ErrShortenerRequired = errors.New("router: shortener is required")
-	ErrURLIsRequired     = errors.New("router: URL is required")
+	ErrClosed                 = errors.New("store: closed")
+	ErrDeduplicateUnsupported = errors.New("shortener: store does not support deduplication")
+	ErrDeleted                = errors.New("store: link was deleted")
+	ErrDoesNotExist           = errors.New("shortener: id does not exist")
+	ErrExists                 = errors.New("store: key already exists")
+	ErrExpired                = errors.New("store: link is no longer active")
+	ErrGone                   = errors.New("store: link reached its max visits")
+	ErrIncorrectPassword      = errors.New("shortener: incorrect password")
+	ErrInvalidAlias           = errors.New("shortener: invalid alias")
+	ErrInvalidAlphabet        = errors.New("shortener: invalid ID alphabet")
+	ErrInvalidCursor          = errors.New("store: invalid cursor")
+	ErrInvalidDomain          = errors.New("shortener: invalid domain pattern")
+	ErrInvalidDomainList      = errors.New("shortener: invalid domain list")
+	ErrInvalidExpiration      = errors.New("shortener: invalid expiration")
+	ErrInvalidGranularity     = errors.New("shortener: invalid stats granularity")
+	ErrInvalidLimit           = errors.New("shortener: invalid page limit")
+	ErrInvalidMaxVisits       = errors.New("shortener: invalid max visits")
+	ErrInvalidPassword        = errors.New("shortener: password is too long")
+	ErrInvalidPrecondition    = errors.New("router: invalid If-Match header")
+	ErrInvalidSchedule        = errors.New("shortener: invalid activation window")
+	ErrInvalidStatsRange      = errors.New("shortener: invalid stats range")
+	ErrInvalidToken           = errors.New("shortener: invalid management token")
+	ErrInvalidURL             = errors.New("shortener: invalid URL")
+	ErrMaxRetries             = errors.New("shortener: max retries exceeded")
+	ErrNil                    = errors.New("store: key not found")
+	ErrNotActive              = errors.New("store: link is not active yet")
+	ErrPolicy                 = errors.New("shortener: URL rejected by policy")
+	ErrProtected              = errors.New("store: link is password protected")
+	ErrRedirectChain          = errors.New("shortener: URL redirects through too many shorteners")
+	ErrReservedAlias          = errors.New("shortener: alias is reserved")
+	ErrSelfLink               = errors.New("shortener: URL points to this service")
+	ErrShortenerRequired      = errors.New("router: shortener is required")
+	ErrStatsUnsupported       = errors.New("shortener: store does not support stats")
+	ErrStoreRequired          = errors.New("store: store to cache is required")
+	ErrTooManyAttempts        = errors.New("shortener: too many password attempts")
+	ErrTrashUnsupported       = errors.New("shortener: store does not support the trash")
+	ErrUnauthorized           = errors.New("router: unauthorized")
+	ErrUnresolvedLink         = errors.New("shortener: URL could not be followed")
+	ErrURLIsRequired          = errors.New("router: URL is required")
+	ErrVersionMismatch        = errors.New("store: link was changed since the given version")
 )
 
+// An error for a link rejected by the URL policy, with the reason it was rejected.
+// Matches ErrPolicy with errors.Is.
+type PolicyError struct {
+	Reason string
+}
+
+// Return the error message, including the reason.
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrPolicy, e.Reason)
+}
+
+// Unwrap the error to ErrPolicy.
+func (e *PolicyError) Unwrap() error {
+	return ErrPolicy
+}
+
 // Panics if the given error is not nil.
 func Must[T any](t T, err error) T {
 	if err != nil {
@@ -27,9 +78,13 @@ func Must[T any](t T, err error) T {
 
 // Converts errors to normalized values.
 func NormalizeError(err error) error {
-	if err == redis.Nil {
+	if err == redis.Nil || errors.Is(err, sql.ErrNoRows) {
 		return ErrNil
 	}
 
+	if isSQLiteConstraint(err) || isPostgresUniqueViolation(err) {
+		return ErrExists
+	}
+
 	return err
 }
@@ -1,9 +1,11 @@
 package shrinkmyurl_test
 
 import (
+	"database/sql"
 	"testing"
 
 	shrink "github.com/derek-schaefer/shrink-my-url"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 )
@@ -16,7 +18,16 @@ func TestMust(t *testing.T) {
 	})
 }
 
+func TestPolicyError(t *testing.T) {
+	var err error = &shrink.PolicyError{Reason: "scheme is not allowed: ftp"}
+
+	assert.Equal(t, "shortener: URL rejected by policy: scheme is not allowed: ftp", err.Error())
+	assert.ErrorIs(t, err, shrink.ErrPolicy)
+}
+
 func TestStoreError(t *testing.T) {
 	assert.Equal(t, shrink.ErrNil, shrink.NormalizeError(redis.Nil))
+	assert.Equal(t, shrink.ErrNil, shrink.NormalizeError(sql.ErrNoRows))
 	assert.Equal(t, shrink.ErrExists, shrink.NormalizeError(shrink.ErrExists))
+	assert.Equal(t, shrink.ErrExists, shrink.NormalizeError(&pgconn.PgError{Code: "23505"}))
 }
@@ -0,0 +1,67 @@
+package shrinkmyurl
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+// Precision of the HyperLogLog sketches, which have 2^precision registers, for a standard error of about 1.6%.
+const hllPrecision = 12
+
+// Number of registers of a HyperLogLog sketch.
+const hllRegisters = 1 << hllPrecision
+
+// A HyperLogLog sketch estimating the number of distinct values added to it, holding a register per byte.
+// An empty sketch is nil, and its registers are only allocated once a value is added, so that links without
+// visitors cost nothing.
+type hyperLogLog []byte
+
+// Add a value to the sketch, reporting whether the sketch changed.
+func (h *hyperLogLog) add(value string) bool {
+	if len(*h) != hllRegisters {
+		*h = make(hyperLogLog, hllRegisters)
+	}
+
+	sum := sha256.Sum256([]byte(value))
+	x := binary.BigEndian.Uint64(sum[:8])
+	i := x >> (64 - hllPrecision)
+	rank := byte(min(bits.LeadingZeros64(x<<hllPrecision), 64-hllPrecision) + 1)
+
+	if (*h)[i] >= rank {
+		return false
+	}
+
+	(*h)[i] = rank
+
+	return true
+}
+
+// Estimate the number of distinct values added to the sketch. Small counts are estimated by the number of
+// empty registers, which is close to exact.
+func (h hyperLogLog) count() int64 {
+	if len(h) != hllRegisters {
+		return 0
+	}
+
+	m := float64(hllRegisters)
+	sum := 0.0
+	zeros := 0
+
+	for _, rank := range h {
+		sum += math.Ldexp(1, -int(rank))
+
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return int64(math.Round(estimate))
+}
@@ -0,0 +1,68 @@
+package shrinkmyurl
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// A versioned schema migration for SQL stores.
+type migration struct {
+	version int
+	stmts   []string
+}
+
+// Apply any pending migrations to the database in version order.
+// Each migration runs in its own transaction along with recording its version.
+func migrate(ctx context.Context, db *sql.DB, migrations []migration) error {
+	_, err := db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)")
+
+	if err != nil {
+		return err
+	}
+
+	var current int
+
+	err = db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current)
+
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		if err := applyMigration(ctx, db, m); err != nil {
+			return fmt.Errorf("migration %d: %w", m.version, err)
+		}
+	}
+
+	return nil
+}
+
+// Apply a single migration within a transaction.
+func applyMigration(ctx context.Context, db *sql.DB, m migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for _, stmt := range m.stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO schema_migrations (version) VALUES (%d)", m.version))
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
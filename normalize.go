@@ -0,0 +1,248 @@
+package shrinkmyurl
+
+import (
+	"net"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// A step in the URL normalization pipeline, which modifies the URL in place.
+type Normalizer func(u *url.URL) error
+
+// The standard normalization pipeline, which only makes changes that preserve the meaning of the URL.
+var DefaultNormalizers = []Normalizer{
+	NormalizeHost,
+	StripDefaultPort,
+	CleanPath,
+	SortQuery,
+}
+
+// Query parameters commonly added to URLs to track where visitors came from.
+// A trailing * matches any suffix.
+var DefaultTrackingParams = []string{
+	"utm_*",
+	"fbclid",
+	"gclid",
+	"dclid",
+	"msclkid",
+	"yclid",
+	"igshid",
+	"mc_cid",
+	"mc_eid",
+	"_ga",
+}
+
+// Ports implied by each scheme.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Parse the link and pass it through the given normalizers, returning the normalized link.
+func Normalize(link string, normalizers []Normalizer) (string, error) {
+	u, err := url.Parse(link)
+
+	if err != nil {
+		return "", ErrInvalidURL
+	}
+
+	for _, n := range normalizers {
+		if err := n(u); err != nil {
+			return "", err
+		}
+	}
+
+	return u.String(), nil
+}
+
+// Lowercase the scheme and host, encoding international domain names as punycode.
+func NormalizeHost(u *url.URL) error {
+	u.Scheme = strings.ToLower(u.Scheme)
+
+	hostname := u.Hostname()
+
+	if hostname == "" {
+		return nil
+	}
+
+	if net.ParseIP(hostname) == nil {
+		ascii, err := idna.Lookup.ToASCII(hostname)
+
+		if err != nil {
+			return ErrInvalidURL
+		}
+
+		hostname = ascii
+	}
+
+	u.Host = joinHostPort(strings.ToLower(hostname), u.Port())
+
+	return nil
+}
+
+// Remove the port if it is the default for the scheme.
+func StripDefaultPort(u *url.URL) error {
+	if port := u.Port(); port != "" && port == defaultPorts[strings.ToLower(u.Scheme)] {
+		u.Host = joinHostPort(u.Hostname(), "")
+	}
+
+	return nil
+}
+
+// Resolve dot segments and duplicate slashes in the path, keeping any trailing slash.
+// An empty path is replaced with the root for schemes with a host.
+func CleanPath(u *url.URL) error {
+	if u.Opaque != "" {
+		return nil
+	}
+
+	p := u.EscapedPath()
+
+	if p == "" {
+		if u.Host != "" {
+			u.Path = "/"
+			u.RawPath = ""
+		}
+
+		return nil
+	}
+
+	cleaned := path.Clean(p)
+
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+
+	unescaped, err := url.PathUnescape(cleaned)
+
+	if err != nil {
+		return ErrInvalidURL
+	}
+
+	u.Path = unescaped
+	u.RawPath = cleaned
+
+	return nil
+}
+
+// Sort the query parameters by name, keeping the order of repeated parameters and their original encoding,
+// and dropping an empty query. Queries that cannot be parsed are left as they are.
+func SortQuery(u *url.URL) error {
+	u.ForceQuery = false
+
+	params, ok := splitQuery(u.RawQuery)
+
+	if !ok {
+		return nil
+	}
+
+	slices.SortStableFunc(params, func(a, b queryParam) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	u.RawQuery = joinQuery(params)
+
+	return nil
+}
+
+// Create a normalizer that removes the given query parameters, where a trailing * matches any suffix.
+// The other parameters keep their order and original encoding, and queries that cannot be parsed are left as
+// they are.
+func StripQueryParams(patterns ...string) Normalizer {
+	return func(u *url.URL) error {
+		params, ok := splitQuery(u.RawQuery)
+
+		if !ok {
+			return nil
+		}
+
+		params = slices.DeleteFunc(params, func(p queryParam) bool {
+			return matchParam(p.name, patterns)
+		})
+
+		u.RawQuery = joinQuery(params)
+
+		return nil
+	}
+}
+
+// A parameter of a query, with its decoded name and the pair as it appears in the query.
+type queryParam struct {
+	name string
+	raw  string
+}
+
+// Split a raw query into its non-empty parameters. Reports false if the query is empty or cannot be parsed.
+func splitQuery(rawQuery string) ([]queryParam, bool) {
+	if rawQuery == "" {
+		return nil, false
+	}
+
+	if _, err := url.ParseQuery(rawQuery); err != nil {
+		return nil, false
+	}
+
+	var params []queryParam
+
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+
+		rawName, _, _ := strings.Cut(pair, "=")
+
+		// The query parsed, so every name can be decoded.
+		name, _ := url.QueryUnescape(rawName)
+
+		params = append(params, queryParam{name: name, raw: pair})
+	}
+
+	return params, true
+}
+
+// Join query parameters back into a raw query.
+func joinQuery(params []queryParam) string {
+	pairs := make([]string, len(params))
+
+	for i, p := range params {
+		pairs[i] = p.raw
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// Report whether the parameter name matches any of the patterns, case-insensitively.
+func matchParam(name string, patterns []string) bool {
+	name = strings.ToLower(name)
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Join a host and optional port, bracketing IPv6 addresses.
+func joinHostPort(host, port string) string {
+	if port != "" {
+		return net.JoinHostPort(host, port)
+	}
+
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+
+	return host
+}
@@ -0,0 +1,59 @@
+package shrinkmyurl_test
+
+import (
+	"testing"
+
+	shrink "github.com/derek-schaefer/shrink-my-url"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := map[string]string{
+		"HTTP://Example.com:80/a/../b?":    "http://example.com/b",
+		"https://example.com:443":          "https://example.com/",
+		"https://example.com:8443/a//b/":   "https://example.com:8443/a/b/",
+		"http://bücher.example/":           "http://xn--bcher-kva.example/",
+		"http://[::1]:80/":                 "http://[::1]/",
+		"http://example.com/?b=2&a=1&a=0":  "http://example.com/?a=1&a=0&b=2",
+		"http://example.com/?b=x+y&a=%2F":  "http://example.com/?a=%2F&b=x+y",
+		"http://example.com/?b=1&&a&c=":    "http://example.com/?a&b=1&c=",
+		"http://example.com/?b=%zz&a=1":    "http://example.com/?b=%zz&a=1",
+		"http://example.com/a%2Fb/./c":     "http://example.com/a%2Fb/c",
+		"http://example.com/path#Fragment": "http://example.com/path#Fragment",
+		"mailto:someone@example.com":       "mailto:someone@example.com",
+	}
+
+	for link, expected := range tests {
+		assert.Equal(t, expected, shrink.Must(shrink.Normalize(link, shrink.DefaultNormalizers)), link)
+	}
+}
+
+func TestNormalizeInvalidHost(t *testing.T) {
+	_, err := shrink.Normalize("http://exa mple.com/", shrink.DefaultNormalizers)
+
+	assert.Equal(t, shrink.ErrInvalidURL, err)
+}
+
+func TestStripQueryParams(t *testing.T) {
+	strip := shrink.StripQueryParams(shrink.DefaultTrackingParams...)
+
+	link := shrink.Must(shrink.Normalize("http://example.com/?id=1&utm_source=x&UTM_Medium=y&fbclid=z", []shrink.Normalizer{strip}))
+
+	assert.Equal(t, "http://example.com/?id=1", link)
+
+	link = shrink.Must(shrink.Normalize("http://example.com/?utm_source=x", []shrink.Normalizer{strip}))
+
+	assert.Equal(t, "http://example.com/", link)
+}
+
+func TestStripQueryParamsKeepsEncoding(t *testing.T) {
+	strip := shrink.StripQueryParams(shrink.DefaultTrackingParams...)
+
+	link := shrink.Must(shrink.Normalize("http://example.com/?q=a+b%2Fc&utm_source=x&flag&z=1", []shrink.Normalizer{strip}))
+
+	assert.Equal(t, "http://example.com/?q=a+b%2Fc&flag&z=1", link)
+
+	link = shrink.Must(shrink.Normalize("http://example.com/?utm_source=x&q=%zz", []shrink.Normalizer{strip}))
+
+	assert.Equal(t, "http://example.com/?utm_source=x&q=%zz", link)
+}
@@ -0,0 +1,85 @@
+package shrinkmyurl
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	defaultMaxPasswordAttempts   = 5
+	defaultPasswordAttemptWindow = time.Minute
+)
+
+// Longest password bcrypt can hash, in bytes.
+const maxPasswordLength = 72
+
+// Password attempts for a link within the current window.
+type passwordAttempts struct {
+	count int
+	start time.Time
+}
+
+// Limits the password attempts per link ID within a window of time. Attempts are taken before the password
+// is checked, so that concurrent attempts cannot all pass the limit while the slow hash is compared, and are
+// given back by reset once the password turns out to be right.
+type attemptLimiter struct {
+	mu       sync.Mutex
+	attempts map[string]*passwordAttempts
+}
+
+// Create a new attempt limiter.
+func newAttemptLimiter() *attemptLimiter {
+	return &attemptLimiter{attempts: make(map[string]*passwordAttempts)}
+}
+
+// Take an attempt for the ID at the given time, discarding attempts from past windows. Reports false, without
+// taking one, if the ID has no attempts left in the current window.
+func (l *attemptLimiter) take(id string, max int, window time.Duration, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if a, ok := l.attempts[id]; ok && now.Sub(a.start) < window {
+		if a.count >= max {
+			return false
+		}
+
+		a.count++
+		return true
+	}
+
+	for k, a := range l.attempts {
+		if now.Sub(a.start) >= window {
+			delete(l.attempts, k)
+		}
+	}
+
+	l.attempts[id] = &passwordAttempts{count: 1, start: now}
+
+	return true
+}
+
+// Forget the attempts for the ID.
+func (l *attemptLimiter) reset(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.attempts, id)
+}
+
+// Hash a password to be stored with a link.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// Report whether the password matches the stored hash.
+func checkPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
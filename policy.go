@@ -0,0 +1,134 @@
+package shrinkmyurl
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Schemes allowed by default, which excludes schemes such as javascript: and file: that are unsafe to redirect to.
+var DefaultAllowedSchemes = []string{"http", "https"}
+
+// Address ranges that are not publicly routable but are not covered by the netip predicates.
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// Resolves hostnames to IP addresses. Satisfied by *net.Resolver.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// Rules that links must satisfy to be shortened, so that the service cannot be used to redirect to unsafe destinations.
+type URLPolicy struct {
+	// Schemes links may use, compared case-insensitively. Defaults to DefaultAllowedSchemes.
+	AllowedSchemes []string
+	// Maximum length of a link in bytes. Unlimited if zero.
+	MaxLength int
+	// Allow hosts that are, or resolve to, private, loopback, link-local or other non-public addresses.
+	AllowPrivate bool
+	// Resolves hostnames to check their addresses. Defaults to net.DefaultResolver.
+	Resolver Resolver
+}
+
+// Check that the link satisfies the policy, returning a *PolicyError with the reason if it does not.
+func (p *URLPolicy) Check(ctx context.Context, link string) error {
+	if p.MaxLength > 0 && len(link) > p.MaxLength {
+		return &PolicyError{Reason: "URL is too long"}
+	}
+
+	u, err := url.Parse(link)
+
+	if err != nil {
+		return ErrInvalidURL
+	}
+
+	schemes := p.AllowedSchemes
+
+	if schemes == nil {
+		schemes = DefaultAllowedSchemes
+	}
+
+	if !slices.ContainsFunc(schemes, func(s string) bool { return strings.EqualFold(s, u.Scheme) }) {
+		return &PolicyError{Reason: "scheme is not allowed: " + u.Scheme}
+	}
+
+	host := u.Hostname()
+
+	if host == "" {
+		return &PolicyError{Reason: "URL has no host"}
+	}
+
+	if u.User != nil {
+		return &PolicyError{Reason: "URL contains credentials"}
+	}
+
+	if p.AllowPrivate {
+		return nil
+	}
+
+	return p.checkHost(ctx, host)
+}
+
+// Check that the host is, or only resolves to, public addresses.
+func (p *URLPolicy) checkHost(ctx context.Context, host string) error {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if !isPublicAddr(addr) {
+			return &PolicyError{Reason: "address is not public: " + host}
+		}
+
+		return nil
+	}
+
+	resolver := p.Resolver
+
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+
+	var dnsErr *net.DNSError
+
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return &PolicyError{Reason: "host does not resolve: " + host}
+	} else if err != nil {
+		return err
+	}
+
+	for _, ip := range addrs {
+		addr, ok := netip.AddrFromSlice(ip.IP)
+
+		if !ok || !isPublicAddr(addr) {
+			return &PolicyError{Reason: "host resolves to an address that is not public: " + host}
+		}
+	}
+
+	return nil
+}
+
+// Report whether the address is publicly routable.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	return true
+}
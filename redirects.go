@@ -0,0 +1,183 @@
+package shrinkmyurl
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxRedirects    = 3
+	defaultRedirectTimeout = 5 * time.Second
+)
+
+// Domains of popular URL shorteners, which can be used as ShortenerOptions.KnownShorteners.
+var DefaultKnownShorteners = []string{
+	"bit.ly",
+	"buff.ly",
+	"cutt.ly",
+	"goo.gl",
+	"is.gd",
+	"ow.ly",
+	"rebrand.ly",
+	"t.co",
+	"t.ly",
+	"tiny.cc",
+	"tinyurl.com",
+}
+
+// Follow a link through this service and known shorteners, so that links cannot form chains or loops.
+// Links to this service are rejected with ErrSelfLink, unless short links are resolved, in which case the
+// destination stored for the ID is followed instead. Links to known shorteners are followed with HEAD requests
+// to check where they lead. Returns the final destination, or ErrRedirectChain if the chain is longer than
+// MaxRedirects or loops.
+func (s *Shortener) follow(ctx context.Context, host url.URL, link string) (string, error) {
+	seen := make(map[string]bool)
+
+	for hops := 0; ; hops++ {
+		u, err := url.Parse(link)
+
+		if err != nil {
+			return "", ErrInvalidURL
+		}
+
+		self := s.isSelf(host, u)
+
+		if !self && !s.isKnownShortener(u) {
+			return link, nil
+		}
+
+		if self && !s.ResolveShortLinks {
+			return "", ErrSelfLink
+		}
+
+		if seen[link] || hops >= s.maxRedirects() {
+			return "", ErrRedirectChain
+		}
+
+		seen[link] = true
+
+		var next string
+
+		if self {
+			next, err = s.resolveSelf(ctx, u)
+		} else {
+			next, err = s.resolveRemote(ctx, u)
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		if next == "" {
+			return link, nil
+		}
+
+		link = next
+	}
+}
+
+// Get the destination stored for a link to this service. Links that could not be expanded by anyone, such as
+// protected links, links outside their activation window and links out of visits, are rejected with ErrSelfLink,
+// so that their destinations are not revealed.
+func (s *Shortener) resolveSelf(ctx context.Context, u *url.URL) (string, error) {
+	id := strings.TrimPrefix(u.Path, "/")
+
+	if id == "" || strings.Contains(id, "/") {
+		return "", ErrSelfLink
+	}
+
+	link, err := s.Store.GetLink(ctx, id)
+
+	if err == ErrNil {
+		return "", ErrSelfLink
+	} else if err != nil {
+		return "", err
+	}
+
+	if !link.DeletedAt.IsZero() || link.PasswordHash != "" ||
+		activationError(link.NotBefore, link.NotAfter, time.Now()) != nil ||
+		(link.MaxVisits > 0 && link.Visits >= link.MaxVisits) {
+		return "", ErrSelfLink
+	}
+
+	return link.URL, nil
+}
+
+// Get the destination a link to another shortener redirects to, or an empty string if it does not redirect.
+func (s *Shortener) resolveRemote(ctx context.Context, u *url.URL) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+
+	if err != nil {
+		return "", ErrInvalidURL
+	}
+
+	res, err := s.httpClient().Do(req)
+
+	if err != nil {
+		return "", ErrUnresolvedLink
+	}
+
+	res.Body.Close()
+
+	if res.StatusCode < 300 || res.StatusCode >= 400 {
+		return "", nil
+	}
+
+	location, err := res.Location()
+
+	if err == http.ErrNoLocation {
+		return "", nil
+	} else if err != nil {
+		return "", ErrUnresolvedLink
+	}
+
+	return location.String(), nil
+}
+
+// Report whether the URL points to this service, either at the requested host or one of the configured hosts.
+func (s *Shortener) isSelf(host url.URL, u *url.URL) bool {
+	if strings.EqualFold(u.Host, host.Host) {
+		return true
+	}
+
+	hostname := canonicalDomain(u.Hostname())
+
+	for _, h := range s.Hosts {
+		if canonicalDomain(h) == hostname {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Report whether the URL points to one of the known shorteners.
+func (s *Shortener) isKnownShortener(u *url.URL) bool {
+	return matchDomain(canonicalDomain(u.Hostname()), s.KnownShorteners)
+}
+
+// Get the maximum number of redirects to follow.
+func (s *Shortener) maxRedirects() int {
+	if s.MaxRedirects <= 0 {
+		return defaultMaxRedirects
+	}
+
+	return s.MaxRedirects
+}
+
+// Get the HTTP client used to follow links to other shorteners, which must not follow redirects itself.
+func (s *Shortener) httpClient() *http.Client {
+	if s.HTTPClient != nil {
+		return s.HTTPClient
+	}
+
+	return &http.Client{
+		Timeout: defaultRedirectTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
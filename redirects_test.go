@@ -0,0 +1,143 @@
+package shrinkmyurl_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	shrink "github.com/derek-schaefer/shrink-my-url"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestShortenerServer() *httptest.Server {
+	mux := http.NewServeMux()
+
+	mux.Handle("/a", http.RedirectHandler("/b", http.StatusMovedPermanently))
+	mux.Handle("/b", http.RedirectHandler("http://example.org/final", http.StatusFound))
+	mux.Handle("/loop", http.RedirectHandler("/loop", http.StatusFound))
+	mux.Handle("/self", http.RedirectHandler("http://example.com/abc", http.StatusFound))
+
+	return httptest.NewServer(mux)
+}
+
+func newTestFollowingShortener() *testShortener {
+	shortener := newTestShortener()
+	shortener.KnownShorteners = []string{"127.0.0.1"}
+	shortener.HTTPClient = &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return shortener
+}
+
+func TestShortenerShortenSelfLink(t *testing.T) {
+	shortener := newTestShortener()
+	shortener.Hosts = []string{"sho.rt"}
+
+	_, err := shortener.Shorten(context.Background(), localURL, "http://example.com/abc", shrink.LinkOptions{})
+
+	assert.Equal(t, shrink.ErrSelfLink, err)
+
+	_, err = shortener.Shorten(context.Background(), localURL, "https://SHO.RT/abc", shrink.LinkOptions{Alias: "chain"})
+
+	assert.Equal(t, shrink.ErrSelfLink, err)
+}
+
+func TestShortenerShortenResolveSelfLink(t *testing.T) {
+	shortener := newTestShortener()
+	shortener.ResolveShortLinks = true
+
+	original := shrink.Must(shortener.Shorten(context.Background(), localURL, "http://example.org/", shrink.LinkOptions{}))
+
+	record := shrink.Must(shortener.Shorten(context.Background(), localURL, original.ShortenedUrl, shrink.LinkOptions{Alias: "again"}))
+
+	assert.Equal(t, "http://example.org/", record.ExpandedUrl)
+
+	_, err := shortener.Shorten(context.Background(), localURL, "http://example.com/missing", shrink.LinkOptions{})
+
+	assert.Equal(t, shrink.ErrSelfLink, err)
+
+	_, err = shortener.Shorten(context.Background(), localURL, "http://example.com/", shrink.LinkOptions{})
+
+	assert.Equal(t, shrink.ErrSelfLink, err)
+}
+
+func TestShortenerShortenResolveUnexpandableSelfLink(t *testing.T) {
+	shortener := newTestShortener()
+	shortener.ResolveShortLinks = true
+
+	protected := shrink.Must(shortener.Shorten(context.Background(), localURL, "http://example.org/secret", shrink.LinkOptions{Password: "hunter2"}))
+
+	_, err := shortener.Shorten(context.Background(), localURL, protected.ShortenedUrl, shrink.LinkOptions{})
+
+	assert.Equal(t, shrink.ErrSelfLink, err)
+
+	inactive := shrink.Must(shortener.Shorten(context.Background(), localURL, "http://example.org/later", shrink.LinkOptions{
+		NotBefore: time.Now().Add(time.Hour),
+	}))
+
+	_, err = shortener.Shorten(context.Background(), localURL, inactive.ShortenedUrl, shrink.LinkOptions{})
+
+	assert.Equal(t, shrink.ErrSelfLink, err)
+
+	limited := shrink.Must(shortener.Shorten(context.Background(), localURL, "http://example.org/once", shrink.LinkOptions{MaxVisits: 1}))
+
+	record := shrink.Must(shortener.Shorten(context.Background(), localURL, limited.ShortenedUrl, shrink.LinkOptions{}))
+
+	assert.Equal(t, "http://example.org/once", record.ExpandedUrl)
+
+	shrink.Must(shortener.Expand(context.Background(), localURL, limited.Id))
+
+	_, err = shortener.Shorten(context.Background(), localURL, limited.ShortenedUrl, shrink.LinkOptions{})
+
+	assert.Equal(t, shrink.ErrSelfLink, err)
+}
+
+func TestShortenerShortenKnownShortener(t *testing.T) {
+	server := newTestShortenerServer()
+	defer server.Close()
+
+	shortener := newTestFollowingShortener()
+
+	record := shrink.Must(shortener.Shorten(context.Background(), localURL, server.URL+"/a", shrink.LinkOptions{}))
+
+	assert.Equal(t, server.URL+"/a", record.ExpandedUrl)
+
+	_, err := shortener.Shorten(context.Background(), localURL, server.URL+"/self", shrink.LinkOptions{})
+
+	assert.Equal(t, shrink.ErrSelfLink, err)
+
+	_, err = shortener.Shorten(context.Background(), localURL, server.URL+"/loop", shrink.LinkOptions{})
+
+	assert.Equal(t, shrink.ErrRedirectChain, err)
+
+	shortener.MaxRedirects = 1
+
+	_, err = shortener.Shorten(context.Background(), localURL, server.URL+"/a", shrink.LinkOptions{})
+
+	assert.Equal(t, shrink.ErrRedirectChain, err)
+}
+
+func TestShortenerShortenResolveKnownShortener(t *testing.T) {
+	server := newTestShortenerServer()
+	defer server.Close()
+
+	shortener := newTestFollowingShortener()
+	shortener.ResolveShortLinks = true
+
+	record := shrink.Must(shortener.Shorten(context.Background(), localURL, server.URL+"/a", shrink.LinkOptions{}))
+
+	assert.Equal(t, "http://example.org/final", record.ExpandedUrl)
+
+	record = shrink.Must(shortener.Shorten(context.Background(), localURL, server.URL+"/missing", shrink.LinkOptions{}))
+
+	assert.Equal(t, server.URL+"/missing", record.ExpandedUrl)
+
+	_, err := shortener.Shorten(context.Background(), localURL, server.URL+"/self", shrink.LinkOptions{})
+
+	assert.Equal(t, shrink.ErrSelfLink, err)
+}
@@ -2,14 +2,22 @@ package shrinkmyurl
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"text/template"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -25,6 +33,59 @@ func init() {
 type RouterOptions struct {
 	DevMode   bool
 	Shortener *Shortener
+	// Bearer token required by the admin API. The admin API is disabled if empty.
+	AdminToken string
+	// URL that visits of links outside their activation window are redirected to, instead of a holding page.
+	InactiveURL string
+	// Path of an HTML file shown as the holding page for links outside their activation window.
+	// Defaults to the built-in page.
+	InactivePage string
+}
+
+// Payload for shortening a link via the API.
+type shortenPayload struct {
+	ExpandedUrl    string    `json:"expanded_url"`
+	Alias          string    `json:"alias"`
+	TTL            int64     `json:"ttl"`
+	AbsoluteExpiry bool      `json:"absolute_expiry"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	MaxVisits      int64     `json:"max_visits"`
+	Password       string    `json:"password"`
+	NotBefore      time.Time `json:"not_before"`
+	NotAfter       time.Time `json:"not_after"`
+}
+
+// Payload for changing the destination of a link via the API.
+type updatePayload struct {
+	ExpandedUrl string `json:"expanded_url"`
+	ChangedBy   string `json:"changed_by"`
+}
+
+// Response for the history of a link, with the current record and its past destinations.
+type historyResponse struct {
+	Record
+	Revisions []Revision `json:"revisions"`
+}
+
+// Response for the stats of a link, with the current record, its visits in every bucket and the most common
+// details of its visits.
+type statsResponse struct {
+	Record
+	Granularity Granularity                    `json:"granularity"`
+	Series      []StatsBucket                  `json:"series"`
+	Breakdowns  map[Dimension][]BreakdownEntry `json:"breakdowns"`
+}
+
+// Response for a page of links, with the cursor of the next page.
+type linksPage struct {
+	Links      []Record `json:"links"`
+	NextCursor string   `json:"next_cursor"`
+}
+
+// Response for the operational stats of the service, with each part omitted if the store does not report it.
+type statsOverview struct {
+	Cache      *CacheStats      `json:"cache,omitempty"`
+	VisitQueue *VisitQueueStats `json:"visit_queue,omitempty"`
 }
 
 // HTTP router for the service.
@@ -57,6 +118,8 @@ func (rs *Router) Routes() *chi.Mux {
 	r.Get("/", rs.index)
 	r.Post("/shorten", rs.shortenLink)
 	r.Get("/{id}", rs.redirectLink)
+	r.Post("/{id}", rs.unlockLink)
+	r.Delete("/{id}", rs.deleteLink)
 
 	r.Get("/favicon.ico", rs.asset("favicon.ico"))
 
@@ -64,6 +127,30 @@ func (rs *Router) Routes() *chi.Mux {
 		r.Get("/health", rs.apiHealthCheck)
 		r.Post("/links", rs.apiShortenLink)
 		r.Get("/links/{id}", rs.apiExpandLink)
+		r.Delete("/links/{id}", rs.apiDeleteLink)
+		r.Post("/links/{id}/restore", rs.apiRestoreLink)
+
+		if rs.AdminToken != "" {
+			r.With(rs.requireAdmin).Get("/links", rs.apiListLinks)
+			r.With(rs.requireAdmin).Patch("/links/{id}", rs.apiUpdateLink)
+			r.With(rs.requireAdmin).Get("/links/{id}/history", rs.apiLinkHistory)
+			r.With(rs.requireAdmin).Get("/links/{id}/stats", rs.apiLinkStats)
+		}
+
+		if rs.AdminToken != "" {
+			r.Route("/admin", func(r chi.Router) {
+				r.Use(rs.requireAdmin)
+
+				r.Get("/trash", rs.apiListTrash)
+				r.Get("/stats", rs.apiStats)
+
+				if rs.Shortener.Domains != nil {
+					r.Get("/domains", rs.apiListDomains)
+					r.Put("/domains/{list}/{pattern}", rs.apiAddDomain)
+					r.Delete("/domains/{list}/{pattern}", rs.apiRemoveDomain)
+				}
+			})
+		}
 	})
 
 	return r
@@ -75,25 +162,31 @@ func (rs *Router) index(w http.ResponseWriter, r *http.Request) {
 }
 
 // Shorten the URL submitted via the form, render and return the shorten page.
+// Client errors are rendered in the page so that htmx swaps them in.
 func (rs *Router) shortenLink(w http.ResponseWriter, r *http.Request) {
 	link := r.FormValue("url")
+	ops := LinkOptions{Alias: r.FormValue("alias"), Password: r.FormValue("password")}
 
-	record, err := rs.Shortener.Shorten(context.Background(), rs.requestURL(r), link)
+	record, err := rs.Shortener.Shorten(context.Background(), rs.requestURL(r), link, ops)
 
-	if err != nil {
+	if _, ok := errorStatus(err); err != nil && !ok {
 		panic(err)
 	}
 
 	data := struct {
 		Record Record
+		Error  error
 	}{
 		Record: record,
+		Error:  err,
 	}
 
 	rs.renderTemplate(w, "shorten.html", data)
 }
 
 // Visit the shortened URL and redirect to the expanded URL.
+// Password protected links render a page that prompts for the password instead, and links outside their
+// activation window render the holding page or redirect to the fallback URL.
 func (rs *Router) redirectLink(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
@@ -101,13 +194,59 @@ func (rs *Router) redirectLink(w http.ResponseWriter, r *http.Request) {
 
 	if err == ErrNil {
 		http.NotFound(w, r)
+	} else if err == ErrGone || err == ErrDeleted {
+		handleError(w, err, http.StatusGone)
+	} else if err == ErrNotActive || err == ErrExpired {
+		rs.renderInactive(w, r, err)
+	} else if err == ErrProtected {
+		rs.renderPassword(w, id, nil, http.StatusOK)
 	} else if err != nil {
 		panic(err)
 	} else {
+		rs.recordVisit(r, id)
 		http.Redirect(w, r, record.ExpandedUrl, http.StatusFound)
 	}
 }
 
+// Visit the password protected URL with the password submitted via the form and redirect to the expanded URL.
+// Wrong passwords render the password page again with the error.
+func (rs *Router) unlockLink(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	record, err := rs.Shortener.Unlock(context.Background(), rs.requestURL(r), id, r.FormValue("password"))
+
+	if err == ErrNil {
+		http.NotFound(w, r)
+	} else if err == ErrGone || err == ErrDeleted {
+		handleError(w, err, http.StatusGone)
+	} else if err == ErrNotActive || err == ErrExpired {
+		rs.renderInactive(w, r, err)
+	} else if err == ErrIncorrectPassword {
+		rs.renderPassword(w, id, err, http.StatusUnauthorized)
+	} else if err == ErrTooManyAttempts {
+		rs.renderPassword(w, id, err, http.StatusTooManyRequests)
+	} else if err != nil {
+		panic(err)
+	} else {
+		rs.recordVisit(r, id)
+		http.Redirect(w, r, record.ExpandedUrl, http.StatusSeeOther)
+	}
+}
+
+// Delete the shortened URL with the bearer token, render and return the deleted page fragment.
+// Client errors are rendered in the fragment so that htmx swaps them in.
+func (rs *Router) deleteLink(w http.ResponseWriter, r *http.Request) {
+	err := rs.delete(r)
+
+	if err == ErrNil {
+		err = ErrDoesNotExist
+	} else if _, ok := errorStatus(err); err != nil && !ok {
+		panic(err)
+	}
+
+	rs.renderTemplate(w, "delete.html", struct{ Error error }{Error: err})
+}
+
 // Serve the asset file with caching enabled.
 func (rs *Router) asset(name string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -136,7 +275,7 @@ func (rs *Router) apiShortenLink(w http.ResponseWriter, r *http.Request) {
 		panic(err)
 	}
 
-	var payload Record
+	var payload shortenPayload
 
 	err = json.Unmarshal(body, &payload)
 
@@ -149,30 +288,390 @@ func (rs *Router) apiShortenLink(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	record, err := rs.Shortener.Shorten(context.Background(), rs.requestURL(r), payload.ExpandedUrl)
+	ops := LinkOptions{
+		Alias:          payload.Alias,
+		TTL:            time.Duration(payload.TTL) * time.Second,
+		AbsoluteExpiry: payload.AbsoluteExpiry,
+		ExpiresAt:      payload.ExpiresAt,
+		MaxVisits:      payload.MaxVisits,
+		Password:       payload.Password,
+		NotBefore:      payload.NotBefore,
+		NotAfter:       payload.NotAfter,
+	}
+
+	record, err := rs.Shortener.Shorten(context.Background(), rs.requestURL(r), payload.ExpandedUrl, ops)
 
 	if err != nil {
-		panic(err)
+		handleClientError(w, err)
+		return
 	}
 
 	writeJson(w, record, http.StatusCreated)
 }
 
 // Expand the shortened URL by ID, if it exists.
+// Password protected links require the password in the X-Link-Password header.
 func (rs *Router) apiExpandLink(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
-	record, err := rs.Shortener.Expand(context.Background(), rs.requestURL(r), id)
+	var record Record
+	var err error
+
+	if password := r.Header.Get("X-Link-Password"); password != "" {
+		record, err = rs.Shortener.Unlock(context.Background(), rs.requestURL(r), id, password)
+	} else if record, err = rs.Shortener.Expand(context.Background(), rs.requestURL(r), id); err == nil {
+		record, err = rs.Shortener.withUniqueVisitors(context.Background(), record)
+	}
 
 	if err == ErrNil {
 		http.NotFound(w, r)
+	} else if err == ErrGone || err == ErrExpired || err == ErrDeleted {
+		handleError(w, err, http.StatusGone)
+	} else if err == ErrNotActive {
+		handleError(w, err, http.StatusForbidden)
 	} else if err != nil {
-		panic(err)
+		handleClientError(w, err)
 	} else {
 		writeJson(w, record, http.StatusOK)
 	}
 }
 
+// Delete the shortened URL by ID with the bearer token, moving it to the trash.
+func (rs *Router) apiDeleteLink(w http.ResponseWriter, r *http.Request) {
+	err := rs.delete(r)
+
+	if err == ErrNil {
+		http.NotFound(w, r)
+	} else if err != nil {
+		handleClientError(w, err)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Restore the shortened URL by ID from the trash with the bearer token, which is either the link's management
+// token or the admin token.
+func (rs *Router) apiRestoreLink(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	token, admin, err := rs.bearerToken(r)
+
+	if err != nil {
+		handleClientError(w, err)
+		return
+	}
+
+	var record Record
+
+	if admin {
+		record, err = rs.Shortener.ForceRestore(context.Background(), rs.requestURL(r), id)
+	} else {
+		record, err = rs.Shortener.Restore(context.Background(), rs.requestURL(r), id, token)
+	}
+
+	if err == ErrNil {
+		http.NotFound(w, r)
+	} else if err != nil {
+		handleClientError(w, err)
+	} else {
+		writeJson(w, record, http.StatusOK)
+	}
+}
+
+// List the shortened URLs in the trash that can still be restored.
+func (rs *Router) apiListTrash(w http.ResponseWriter, r *http.Request) {
+	records, err := rs.Shortener.Trash(context.Background(), rs.requestURL(r))
+
+	if err != nil {
+		handleClientError(w, err)
+		return
+	}
+
+	writeJson(w, records, http.StatusOK)
+}
+
+// Get the stats of the link cache and the background visit queue, as far as the store has them. A cached
+// store reports the visit queue of the store it wraps.
+func (rs *Router) apiStats(w http.ResponseWriter, r *http.Request) {
+	var overview statsOverview
+
+	store := rs.Shortener.Store
+
+	if cache, ok := store.(CacheReporter); ok {
+		stats := cache.CacheStats()
+		overview.Cache = &stats
+	}
+
+	if cached, ok := store.(*CachedStore); ok {
+		store = cached.Store
+	}
+
+	if queue, ok := store.(VisitQueueReporter); ok {
+		stats := queue.VisitQueueStats()
+		overview.VisitQueue = &stats
+	}
+
+	writeJson(w, overview, http.StatusOK)
+}
+
+// Get a page of the shortened URLs with their visit counts, and the cursor of the next page, optionally
+// filtered by a substring of the destination and including the links in the trash.
+func (rs *Router) apiListLinks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var limit int
+
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+
+		if err != nil {
+			handleError(w, ErrInvalidLimit, http.StatusBadRequest)
+			return
+		}
+
+		limit = n
+	}
+
+	filter := LinkFilter{URL: query.Get("url"), IncludeDeleted: query.Get("deleted") == "true"}
+
+	records, next, err := rs.Shortener.List(context.Background(), rs.requestURL(r), query.Get("cursor"), limit, filter)
+
+	if err != nil {
+		handleClientError(w, err)
+		return
+	}
+
+	writeJson(w, linksPage{Links: records, NextCursor: next}, http.StatusOK)
+}
+
+// Change the destination of the shortened URL by ID, provided it is still at the version in the If-Match
+// header, if there is one. The new version is returned in the ETag header.
+func (rs *Router) apiUpdateLink(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var payload updatePayload
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		handleError(w, err, http.StatusBadRequest)
+		return
+	}
+
+	versions, err := ifMatchVersions(r)
+
+	if err != nil {
+		handleClientError(w, err)
+		return
+	}
+
+	version, err := rs.matchVersion(context.Background(), id, versions)
+
+	if err == ErrNil {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		handleClientError(w, err)
+		return
+	}
+
+	author := payload.ChangedBy
+
+	if author == "" {
+		author = "admin"
+	}
+
+	ops := UpdateOptions{Version: version, Author: author}
+
+	record, err := rs.Shortener.Update(context.Background(), rs.requestURL(r), id, payload.ExpandedUrl, ops)
+
+	if err == ErrNil {
+		http.NotFound(w, r)
+	} else if err != nil {
+		handleClientError(w, err)
+	} else {
+		w.Header().Set("ETag", versionETag(record.Version))
+		writeJson(w, record, http.StatusOK)
+	}
+}
+
+// Get the shortened URL by ID with its past destinations. The current version is returned in the ETag header.
+func (rs *Router) apiLinkHistory(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	record, revisions, err := rs.Shortener.History(context.Background(), rs.requestURL(r), id)
+
+	if err == ErrNil {
+		http.NotFound(w, r)
+	} else if err != nil {
+		panic(err)
+	} else {
+		w.Header().Set("ETag", versionETag(record.Version))
+		writeJson(w, historyResponse{Record: record, Revisions: revisions}, http.StatusOK)
+	}
+}
+
+// Get the visits to the shortened URL by ID as a time series of hourly or daily buckets, given by the granularity,
+// between the from and to times in RFC 3339 format, with the top referrers, browsers, operating systems, devices
+// and languages of its visits.
+func (rs *Router) apiLinkStats(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	query := r.URL.Query()
+
+	granularity := Hourly
+
+	if name := query.Get("granularity"); name != "" {
+		g, err := ParseGranularity(name)
+
+		if err != nil {
+			handleClientError(w, err)
+			return
+		}
+
+		granularity = g
+	}
+
+	from, err := parseStatsTime(query.Get("from"))
+
+	if err != nil {
+		handleClientError(w, err)
+		return
+	}
+
+	to, err := parseStatsTime(query.Get("to"))
+
+	if err != nil {
+		handleClientError(w, err)
+		return
+	}
+
+	var top int
+
+	if s := query.Get("top"); s != "" {
+		if top, err = strconv.Atoi(s); err != nil {
+			handleError(w, ErrInvalidLimit, http.StatusBadRequest)
+			return
+		}
+	}
+
+	record, series, err := rs.Shortener.Stats(context.Background(), rs.requestURL(r), id, granularity, from, to)
+
+	if err == ErrNil {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		handleClientError(w, err)
+		return
+	}
+
+	breakdowns, err := rs.Shortener.Breakdowns(context.Background(), id, top)
+
+	if err != nil {
+		handleClientError(w, err)
+		return
+	}
+
+	response := statsResponse{Record: record, Granularity: granularity, Series: series, Breakdowns: breakdowns}
+
+	writeJson(w, response, http.StatusOK)
+}
+
+// List the patterns in the domain allow and deny lists.
+func (rs *Router) apiListDomains(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, rs.Shortener.Domains.Lists(), http.StatusOK)
+}
+
+// Add a pattern to a domain list.
+func (rs *Router) apiAddDomain(w http.ResponseWriter, r *http.Request) {
+	list := DomainList(chi.URLParam(r, "list"))
+
+	if err := rs.Shortener.Domains.Add(list, chi.URLParam(r, "pattern")); err != nil {
+		handleClientError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Remove a pattern from a domain list.
+func (rs *Router) apiRemoveDomain(w http.ResponseWriter, r *http.Request) {
+	list := DomainList(chi.URLParam(r, "list"))
+
+	err := rs.Shortener.Domains.Remove(list, chi.URLParam(r, "pattern"))
+
+	if err == ErrNil {
+		http.NotFound(w, r)
+	} else if err != nil {
+		handleClientError(w, err)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Record the details of the request's visit to the shortened URL by ID, identifying the visitor by the hash of
+// their IP address and user agent. Failures are logged rather than failing the redirect, since the visit itself
+// was already counted.
+func (rs *Router) recordVisit(r *http.Request, id string) {
+	visit := NewVisit(r)
+	visit.Visitor = rs.Shortener.VisitorId(clientIP(r), r.UserAgent(), time.Now())
+
+	if err := rs.Shortener.RecordVisit(context.Background(), id, visit); err != nil {
+		log.Printf("router: recording visit failed: %v", err)
+	}
+}
+
+// Get the IP address of the client making the request, without its port. Set from the forwarding headers by
+// the RealIP middleware when behind a proxy.
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+
+	return r.RemoteAddr
+}
+
+// Require the admin token as a bearer token.
+func (rs *Router) requireAdmin(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(rs.AdminToken)) != 1 {
+			handleError(w, ErrUnauthorized, http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Delete the link by ID in the request with the bearer token, which is either the link's management token or
+// the admin token. Returns ErrUnauthorized without a token.
+func (rs *Router) delete(r *http.Request) error {
+	id := chi.URLParam(r, "id")
+
+	token, admin, err := rs.bearerToken(r)
+
+	if err != nil {
+		return err
+	} else if admin {
+		return rs.Shortener.ForceDelete(context.Background(), id)
+	}
+
+	return rs.Shortener.Delete(context.Background(), id, token)
+}
+
+// Get the bearer token of the request, reporting whether it is the admin token.
+// Returns ErrUnauthorized without a token.
+func (rs *Router) bearerToken(r *http.Request) (string, bool, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	if !ok || token == "" {
+		return "", false, ErrUnauthorized
+	}
+
+	admin := rs.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(rs.AdminToken)) == 1
+
+	return token, admin, nil
+}
+
 // Get the request URL from the request, factoring in the scheme and host.
 func (rs *Router) requestURL(r *http.Request) url.URL {
 	if r.URL == nil {
@@ -202,6 +701,140 @@ func (rs *Router) renderTemplate(w http.ResponseWriter, name string, data interf
 	}
 }
 
+// Render the page prompting for the password of a protected link, with the error of the last attempt.
+func (rs *Router) renderPassword(w http.ResponseWriter, id string, err error, status int) {
+	data := struct {
+		Id    string
+		Error error
+	}{
+		Id:    id,
+		Error: err,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	rs.renderTemplate(w, "password.html", data)
+}
+
+// Respond to a visit of a link outside its activation window by redirecting to the fallback URL, or by
+// rendering the holding page. Links that are no longer active are answered with 410 Gone.
+func (rs *Router) renderInactive(w http.ResponseWriter, r *http.Request, err error) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if rs.InactiveURL != "" {
+		http.Redirect(w, r, rs.InactiveURL, http.StatusFound)
+		return
+	}
+
+	status := http.StatusOK
+
+	if err == ErrExpired {
+		status = http.StatusGone
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if rs.InactivePage != "" {
+		page, err := os.ReadFile(rs.InactivePage)
+
+		if err != nil {
+			panic(err)
+		}
+
+		w.WriteHeader(status)
+		w.Write(page)
+
+		return
+	}
+
+	w.WriteHeader(status)
+
+	rs.renderTemplate(w, "inactive.html", struct{ Expired bool }{Expired: err == ErrExpired})
+}
+
+// Get the entity tag of a link version.
+func versionETag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// Get the link versions allowed by the If-Match header, any of which may match, or none if any version is
+// allowed. Weak entity tags are compared as if they were strong. Returns ErrInvalidPrecondition if the header
+// is not a list of link versions.
+func ifMatchVersions(r *http.Request) ([]int64, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+
+	var versions []int64
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+
+		if len(tag) < 2 || !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) {
+			return nil, ErrInvalidPrecondition
+		}
+
+		version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+
+		// Tags that are not versions, such as those of other resources, can never match.
+		if err != nil || version <= 0 {
+			version = -1
+		}
+
+		versions = append(versions, version)
+	}
+
+	return versions, nil
+}
+
+// Get the version from the If-Match header that the link is at, so that the update applies if any of the
+// listed versions matches, or zero if any version is allowed. The store still checks the version, in case the
+// link changes in the meantime.
+func (rs *Router) matchVersion(ctx context.Context, id string, versions []int64) (int64, error) {
+	if len(versions) == 0 {
+		return 0, nil
+	}
+
+	if len(versions) == 1 {
+		if versions[0] < 0 {
+			return 0, ErrVersionMismatch
+		}
+
+		return versions[0], nil
+	}
+
+	link, err := rs.Shortener.Store.GetLink(ctx, id)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if !slices.Contains(versions, link.Version) {
+		return 0, ErrVersionMismatch
+	}
+
+	return link.Version, nil
+}
+
+// Parse a bound of a stats range in RFC 3339 format, where an empty value is the zero time.
+// Returns ErrInvalidStatsRange if the value is not a time.
+func parseStatsTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+
+	if err != nil {
+		return time.Time{}, ErrInvalidStatsRange
+	}
+
+	return t, nil
+}
+
 // Write JSON to the response writer, with the given status code.
 func writeJson(w http.ResponseWriter, data interface{}, status int) error {
 	err := json.NewEncoder(w).Encode(data)
@@ -221,6 +854,55 @@ func handleError(w http.ResponseWriter, err error, status int) {
 	http.Error(w, err.Error(), status)
 }
 
+// Handle an error caused by the client with its status code, panicking on any other error.
+func handleClientError(w http.ResponseWriter, err error) {
+	status, ok := errorStatus(err)
+
+	if !ok {
+		panic(err)
+	}
+
+	handleError(w, err, status)
+}
+
+// Get the status code for an error caused by the client, if it is one.
+func errorStatus(err error) (int, bool) {
+	switch {
+	case errors.Is(err, ErrInvalidURL), errors.Is(err, ErrInvalidAlias), errors.Is(err, ErrReservedAlias):
+		return http.StatusBadRequest, true
+	case errors.Is(err, ErrInvalidDomain), errors.Is(err, ErrInvalidDomainList):
+		return http.StatusBadRequest, true
+	case errors.Is(err, ErrInvalidExpiration), errors.Is(err, ErrInvalidMaxVisits), errors.Is(err, ErrInvalidPassword):
+		return http.StatusBadRequest, true
+	case errors.Is(err, ErrInvalidSchedule):
+		return http.StatusBadRequest, true
+	case errors.Is(err, ErrInvalidCursor), errors.Is(err, ErrInvalidLimit):
+		return http.StatusBadRequest, true
+	case errors.Is(err, ErrInvalidGranularity), errors.Is(err, ErrInvalidStatsRange):
+		return http.StatusBadRequest, true
+	case errors.Is(err, ErrInvalidPrecondition):
+		return http.StatusBadRequest, true
+	case errors.Is(err, ErrProtected), errors.Is(err, ErrIncorrectPassword), errors.Is(err, ErrUnauthorized):
+		return http.StatusUnauthorized, true
+	case errors.Is(err, ErrInvalidToken):
+		return http.StatusForbidden, true
+	case errors.Is(err, ErrTooManyAttempts):
+		return http.StatusTooManyRequests, true
+	case errors.Is(err, ErrVersionMismatch):
+		return http.StatusPreconditionFailed, true
+	case errors.Is(err, ErrDeleted):
+		return http.StatusGone, true
+	case errors.Is(err, ErrExists):
+		return http.StatusConflict, true
+	case errors.Is(err, ErrPolicy), errors.Is(err, ErrSelfLink), errors.Is(err, ErrRedirectChain), errors.Is(err, ErrUnresolvedLink):
+		return http.StatusUnprocessableEntity, true
+	case errors.Is(err, ErrStatsUnsupported), errors.Is(err, ErrTrashUnsupported):
+		return http.StatusNotImplemented, true
+	}
+
+	return 0, false
+}
+
 // Parse templates from the HTML files.
 func mustParseTemplates() {
 	files, err := os.ReadDir("html")
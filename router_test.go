@@ -8,8 +8,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	shrink "github.com/derek-schaefer/shrink-my-url"
 	"github.com/stretchr/testify/assert"
@@ -30,7 +33,7 @@ type testRouter struct {
 }
 
 func newTestRouter() *testRouter {
-	store := shrink.NewMemoryStore()
+	store := shrink.Must(shrink.NewMemoryStore(shrink.MemoryStoreOptions{}))
 	random := rand.New(rand.NewSource(0))
 
 	shortener := shrink.NewShortener(shrink.ShortenerOptions{
@@ -53,7 +56,7 @@ func newTestRouter() *testRouter {
 func TestNewRouter(t *testing.T) {
 	assert.NotNil(t, shrink.NewRouter(shrink.RouterOptions{
 		Shortener: shrink.NewShortener(shrink.ShortenerOptions{
-			Store: shrink.NewMemoryStore(),
+			Store: shrink.Must(shrink.NewMemoryStore(shrink.MemoryStoreOptions{})),
 		}),
 	}))
 
@@ -82,7 +85,7 @@ func TestRouterShorten(t *testing.T) {
 	router := newTestRouter()
 
 	form := url.Values{
-		"url": []string{"http://example.com"},
+		"url": []string{"http://example.org"},
 	}
 
 	request := postForm("/shorten", form)
@@ -90,13 +93,32 @@ func TestRouterShorten(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, recorder.Code)
 	assert.Contains(t, recorder.Body.String(), "Here's your link")
-	assert.Contains(t, recorder.Body.String(), form.Get("url"))
+	assert.Contains(t, recorder.Body.String(), localURL.String()+"/")
+}
+
+func TestRouterShortenAlias(t *testing.T) {
+	router := newTestRouter()
+
+	form := url.Values{
+		"url":   []string{"http://example.org"},
+		"alias": []string{"q3-launch"},
+	}
+
+	recorder := recordRequest(router, postForm("/shorten", form))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "/q3-launch")
+
+	recorder = recordRequest(router, postForm("/shorten", form))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), shrink.ErrExists.Error())
 }
 
 func TestRouterRedirect(t *testing.T) {
 	router := newTestRouter()
 
-	record := shrink.Must(router.shortener.Shorten(context.Background(), localURL, "http://example.com"))
+	record := shrink.Must(router.shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{}))
 
 	request := httptest.NewRequest(http.MethodGet, "/"+record.Id, nil)
 	recorder := recordRequest(router, request)
@@ -118,7 +140,7 @@ func TestRouterApiShorten(t *testing.T) {
 	router := newTestRouter()
 
 	payload := shrink.Record{
-		ExpandedUrl: "http://example.com",
+		ExpandedUrl: "http://example.org",
 	}
 
 	request := postJSON("/api/links", payload)
@@ -131,10 +153,115 @@ func TestRouterApiShorten(t *testing.T) {
 	assert.Equal(t, payload.ExpandedUrl, received.ExpandedUrl)
 }
 
+func TestRouterApiShortenAlias(t *testing.T) {
+	router := newTestRouter()
+
+	payload := map[string]string{
+		"expanded_url": "http://example.org",
+		"alias":        "q3-launch",
+	}
+
+	recorder := recordRequest(router, postJSON("/api/links", payload))
+
+	var received shrink.Record
+	unmarshalJSON(recorder.Body.Bytes(), &received)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "q3-launch", received.Id)
+	assert.Equal(t, "http://example.com/q3-launch", received.ShortenedUrl)
+
+	recorder = recordRequest(router, postJSON("/api/links", payload))
+
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+
+	payload["alias"] = "api"
+	recorder = recordRequest(router, postJSON("/api/links", payload))
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestRouterApiShortenPolicy(t *testing.T) {
+	router := newTestRouter()
+	router.shortener.Policy = newTestPolicy()
+
+	request := postJSON("/api/links", map[string]string{"expanded_url": "http://169.254.169.254/"})
+
+	recorder := recordRequest(router, request)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "address is not public")
+}
+
+func TestRouterApiAdminDomains(t *testing.T) {
+	router := newTestRouter()
+	router.AdminToken = "secret"
+	router.shortener.Domains = shrink.Must(shrink.NewDomainFilter(shrink.DomainFilterOptions{}))
+
+	request := httptest.NewRequest(http.MethodPut, "/api/admin/domains/deny/*.bad.com", nil)
+
+	assert.Equal(t, http.StatusUnauthorized, recordRequest(router, request).Code)
+
+	request.Header.Set("Authorization", "Bearer secret")
+
+	assert.Equal(t, http.StatusNoContent, recordRequest(router, request).Code)
+
+	request = httptest.NewRequest(http.MethodGet, "/api/admin/domains", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+
+	recorder := recordRequest(router, request)
+
+	var lists map[string][]string
+
+	unmarshalJSON(recorder.Body.Bytes(), &lists)
+
+	assert.Equal(t, []string{"*.bad.com"}, lists["deny"])
+
+	recorder = recordRequest(router, postJSON("/api/links", map[string]string{"expanded_url": "http://www.bad.com"}))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+
+	request = httptest.NewRequest(http.MethodPut, "/api/admin/domains/block/bad.com", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+
+	assert.Equal(t, http.StatusBadRequest, recordRequest(router, request).Code)
+
+	request = httptest.NewRequest(http.MethodDelete, "/api/admin/domains/deny/*.bad.com", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+
+	assert.Equal(t, http.StatusNoContent, recordRequest(router, request).Code)
+	assert.Equal(t, http.StatusNotFound, recordRequest(router, request).Code)
+}
+
+func TestRouterApiShortenMaxVisits(t *testing.T) {
+	router := newTestRouter()
+
+	payload := map[string]any{
+		"expanded_url": "http://example.org",
+		"ttl":          3600,
+		"max_visits":   1,
+	}
+
+	var received shrink.Record
+
+	unmarshalJSON(recordRequest(router, postJSON("/api/links", payload)).Body.Bytes(), &received)
+
+	assert.Equal(t, int64(1), received.MaxVisits)
+	assert.NotNil(t, received.ExpiresAt)
+
+	request := httptest.NewRequest(http.MethodGet, "/"+received.Id, nil)
+
+	assert.Equal(t, http.StatusFound, recordRequest(router, request).Code)
+	assert.Equal(t, http.StatusGone, recordRequest(router, request).Code)
+
+	payload["ttl"] = -1
+
+	assert.Equal(t, http.StatusBadRequest, recordRequest(router, postJSON("/api/links", payload)).Code)
+}
+
 func TestRouterApiExpand(t *testing.T) {
 	router := newTestRouter()
 
-	record := shrink.Must(router.shortener.Shorten(context.Background(), localURL, "http://example.com"))
+	record := shrink.Must(router.shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{}))
 
 	request := httptest.NewRequest(http.MethodGet, "/api/links/"+record.Id, nil)
 	recorder := recordRequest(router, request)
@@ -142,9 +269,574 @@ func TestRouterApiExpand(t *testing.T) {
 	var received shrink.Record
 	unmarshalJSON(recorder.Body.Bytes(), &received)
 	record.Visits = 1
+	record.Token = ""
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, record, received, "the management token is only returned when shortening")
+}
+
+func TestRouterApiExpandUniqueVisitors(t *testing.T) {
+	router := newTestRouter()
+
+	record := shrink.Must(router.shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{}))
+
+	for _, addr := range []string{"203.0.113.7:1234", "203.0.113.8:1234", "203.0.113.7:5678"} {
+		visit := httptest.NewRequest(http.MethodGet, "/"+record.Id, nil)
+		visit.RemoteAddr = addr
+
+		recordRequest(router, visit)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/api/links/"+record.Id, nil)
+	recorder := recordRequest(router, request)
+
+	var received shrink.Record
+	unmarshalJSON(recorder.Body.Bytes(), &received)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, int64(4), received.Visits)
+	assert.Equal(t, int64(2), received.UniqueVisitors)
+
+	request.Header.Set("X-Link-Password", "unused")
+	unmarshalJSON(recordRequest(router, request).Body.Bytes(), &received)
+
+	assert.Equal(t, int64(5), received.Visits)
+	assert.Equal(t, int64(2), received.UniqueVisitors, "links without a password can be unlocked too")
+}
+
+func TestRouterRedirectProtected(t *testing.T) {
+	router := newTestRouter()
+
+	record := shrink.Must(router.shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{Password: "hunter2"}))
+
+	request := httptest.NewRequest(http.MethodGet, "/"+record.Id, nil)
+	recorder := recordRequest(router, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "password protected")
+	assert.NotContains(t, recorder.Body.String(), record.ExpandedUrl)
+
+	recorder = recordRequest(router, postForm("/"+record.Id, url.Values{"password": []string{"wrong"}}))
+
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), shrink.ErrIncorrectPassword.Error())
+
+	recorder = recordRequest(router, postForm("/"+record.Id, url.Values{"password": []string{"hunter2"}}))
+
+	assert.Equal(t, http.StatusSeeOther, recorder.Code)
+	assert.Equal(t, record.ExpandedUrl, recorder.Header().Get("Location"))
+}
+
+func TestRouterApiExpandProtected(t *testing.T) {
+	router := newTestRouter()
+
+	record := shrink.Must(router.shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{Password: "hunter2"}))
+
+	request := httptest.NewRequest(http.MethodGet, "/api/links/"+record.Id, nil)
+	recorder := recordRequest(router, request)
+
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), record.ExpandedUrl)
+
+	request.Header.Set("X-Link-Password", "wrong")
+
+	assert.Equal(t, http.StatusUnauthorized, recordRequest(router, request).Code)
+
+	request.Header.Set("X-Link-Password", "hunter2")
+	recorder = recordRequest(router, request)
+
+	var received shrink.Record
+	unmarshalJSON(recorder.Body.Bytes(), &received)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, record.ExpandedUrl, received.ExpandedUrl)
+	assert.True(t, received.Protected)
+}
+
+func TestRouterRedirectInactive(t *testing.T) {
+	router := newTestRouter()
+
+	record := shrink.Must(router.shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{
+		NotBefore: time.Now().Add(time.Hour),
+	}))
+
+	assert.Nil(t, router.store.CreateLink(context.Background(), "ended", shrink.Link{URL: "http://example.org", NotAfter: time.Now()}))
+
+	recorder := recordRequest(router, httptest.NewRequest(http.MethodGet, "/"+record.Id, nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "not active yet")
+
+	recorder = recordRequest(router, httptest.NewRequest(http.MethodGet, "/ended", nil))
+
+	assert.Equal(t, http.StatusGone, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "no longer active")
+
+	recorder = recordRequest(router, httptest.NewRequest(http.MethodGet, "/api/links/"+record.Id, nil))
+
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), record.ExpandedUrl)
+
+	recorder = recordRequest(router, httptest.NewRequest(http.MethodGet, "/api/links/ended", nil))
+
+	assert.Equal(t, http.StatusGone, recorder.Code)
+
+	router.InactiveURL = "http://example.org/campaigns"
+	recorder = recordRequest(router, httptest.NewRequest(http.MethodGet, "/"+record.Id, nil))
+
+	assert.Equal(t, http.StatusFound, recorder.Code)
+	assert.Equal(t, router.InactiveURL, recorder.Header().Get("Location"))
+
+	router.InactiveURL = ""
+	router.InactivePage = filepath.Join(t.TempDir(), "holding.html")
+	assert.Nil(t, os.WriteFile(router.InactivePage, []byte("<h1>Coming soon</h1>"), 0o644))
+
+	recorder = recordRequest(router, httptest.NewRequest(http.MethodGet, "/"+record.Id, nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "<h1>Coming soon</h1>", recorder.Body.String())
+}
+
+func TestRouterApiUpdateLink(t *testing.T) {
+	router := newTestRouter()
+
+	record := shrink.Must(router.shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{}))
+
+	patch := func(payload any, version string) *http.Request {
+		request := httptest.NewRequest(http.MethodPatch, "/api/links/"+record.Id, marshalJSON(payload))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("Authorization", "Bearer secret")
+
+		if version != "" {
+			request.Header.Set("If-Match", version)
+		}
+
+		return request
+	}
+
+	payload := map[string]string{"expanded_url": "http://example.org/launch", "changed_by": "alice"}
+
+	assert.Equal(t, http.StatusMethodNotAllowed, recordRequest(router, patch(payload, "")).Code, "updates require an admin token")
+
+	router.AdminToken = "secret"
+
+	request := patch(payload, "")
+	request.Header.Del("Authorization")
+
+	assert.Equal(t, http.StatusUnauthorized, recordRequest(router, request).Code)
+
+	recorder := recordRequest(router, patch(payload, `"1"`))
+
+	var received shrink.Record
+	unmarshalJSON(recorder.Body.Bytes(), &received)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, `"2"`, recorder.Header().Get("ETag"))
+	assert.Equal(t, "http://example.org/launch", received.ExpandedUrl)
+
+	assert.Equal(t, http.StatusPreconditionFailed, recordRequest(router, patch(payload, `"1"`)).Code)
+	assert.Equal(t, http.StatusPreconditionFailed, recordRequest(router, patch(payload, `"1", W/"3"`)).Code)
+	assert.Equal(t, http.StatusPreconditionFailed, recordRequest(router, patch(payload, `"launch"`)).Code)
+	assert.Equal(t, http.StatusBadRequest, recordRequest(router, patch(payload, "launch")).Code)
+	assert.Equal(t, http.StatusBadRequest, recordRequest(router, patch(payload, `"1", 2`)).Code)
+
+	recorder = recordRequest(router, patch(payload, `"1", W/"2"`))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, `"3"`, recorder.Header().Get("ETag"))
+
+	recorder = recordRequest(router, patch(payload, `W/"3"`))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, `"4"`, recorder.Header().Get("ETag"))
+
+	payload["expanded_url"] = "not a url"
+
+	assert.Equal(t, http.StatusBadRequest, recordRequest(router, patch(payload, "")).Code)
+
+	request = httptest.NewRequest(http.MethodGet, "/api/links/"+record.Id+"/history", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder = recordRequest(router, request)
+
+	var history struct {
+		shrink.Record
+		Revisions []shrink.Revision `json:"revisions"`
+	}
+
+	unmarshalJSON(recorder.Body.Bytes(), &history)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, `"4"`, recorder.Header().Get("ETag"))
+	assert.Equal(t, "http://example.org/launch", history.ExpandedUrl)
+	assert.Len(t, history.Revisions, 3)
+	assert.Equal(t, "http://example.org", history.Revisions[0].URL)
+	assert.Equal(t, "alice", history.Revisions[0].ChangedBy)
+
+	request = httptest.NewRequest(http.MethodGet, "/"+record.Id, nil)
+
+	assert.Equal(t, "http://example.org/launch", recordRequest(router, request).Header().Get("Location"))
+}
+
+func TestRouterApiDeleteLink(t *testing.T) {
+	router := newTestRouter()
+
+	record := shrink.Must(router.shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{}))
+	other := shrink.Must(router.shortener.Shorten(context.Background(), localURL, "http://example.net", shrink.LinkOptions{}))
+
+	del := func(id, token string) *http.Request {
+		request := httptest.NewRequest(http.MethodDelete, "/api/links/"+id, nil)
+
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		return request
+	}
+
+	assert.NotEmpty(t, record.Token)
+	assert.Equal(t, http.StatusUnauthorized, recordRequest(router, del(record.Id, "")).Code)
+	assert.Equal(t, http.StatusForbidden, recordRequest(router, del(record.Id, other.Token)).Code)
+	assert.Equal(t, http.StatusForbidden, recordRequest(router, del(record.Id, "secret")).Code, "admin tokens require an admin token to be set")
+	assert.Equal(t, http.StatusNoContent, recordRequest(router, del(record.Id, record.Token)).Code)
+	assert.Equal(t, http.StatusGone, recordRequest(router, del(record.Id, record.Token)).Code)
+	assert.Equal(t, http.StatusNotFound, recordRequest(router, del("missing", record.Token)).Code)
+
+	request := httptest.NewRequest(http.MethodGet, "/"+record.Id, nil)
+
+	assert.Equal(t, http.StatusGone, recordRequest(router, request).Code, "deleted links stay in the trash")
+
+	router.AdminToken = "secret"
+
+	assert.Equal(t, http.StatusNoContent, recordRequest(router, del(other.Id, "secret")).Code)
+}
+
+func TestRouterDeleteLink(t *testing.T) {
+	router := newTestRouter()
+
+	recorder := recordRequest(router, postForm("/shorten", url.Values{"url": {"http://example.org"}}))
+
+	assert.Contains(t, recorder.Body.String(), "Delete this link")
+
+	record := shrink.Must(router.shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{}))
+
+	request := httptest.NewRequest(http.MethodDelete, "/"+record.Id, nil)
+	request.Header.Set("Authorization", "Bearer wrong")
+	recorder = recordRequest(router, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), shrink.ErrInvalidToken.Error())
+
+	request.Header.Set("Authorization", "Bearer "+record.Token)
+	recorder = recordRequest(router, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "Your link was deleted")
+
+	link, err := router.shortener.Store.GetLink(context.Background(), record.Id)
+
+	assert.Nil(t, err)
+	assert.False(t, link.DeletedAt.IsZero())
+}
+
+func TestRouterApiRestoreLink(t *testing.T) {
+	router := newTestRouter()
+	router.AdminToken = "secret"
+
+	record := shrink.Must(router.shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{}))
+
+	withToken := func(request *http.Request, token string) *http.Request {
+		request.Header.Set("Authorization", "Bearer "+token)
+		return request
+	}
+
+	restore := func(token string) *http.Request {
+		return withToken(httptest.NewRequest(http.MethodPost, "/api/links/"+record.Id+"/restore", nil), token)
+	}
+
+	assert.Equal(t, http.StatusNotFound, recordRequest(router, restore(record.Token)).Code, "links not in the trash cannot be restored")
+
+	assert.Nil(t, router.shortener.Delete(context.Background(), record.Id, record.Token))
+
+	recorder := recordRequest(router, withToken(httptest.NewRequest(http.MethodGet, "/api/admin/trash", nil), "secret"))
+
+	var trash []shrink.TrashedRecord
+	unmarshalJSON(recorder.Body.Bytes(), &trash)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Len(t, trash, 1)
+	assert.Equal(t, record.Id, trash[0].Id)
+	assert.Equal(t, trash[0].DeletedAt.Add(30*24*time.Hour), trash[0].PurgeAt)
+
+	assert.Equal(t, http.StatusUnauthorized, recordRequest(router, httptest.NewRequest(http.MethodGet, "/api/admin/trash", nil)).Code)
+	assert.Equal(t, http.StatusForbidden, recordRequest(router, restore("wrong")).Code)
+
+	recorder = recordRequest(router, restore(record.Token))
+
+	var received shrink.Record
+	unmarshalJSON(recorder.Body.Bytes(), &received)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "http://example.org", received.ExpandedUrl)
+
+	request := httptest.NewRequest(http.MethodGet, "/"+record.Id, nil)
+
+	assert.Equal(t, http.StatusFound, recordRequest(router, request).Code)
+
+	assert.Nil(t, router.shortener.ForceDelete(context.Background(), record.Id))
+	assert.Equal(t, http.StatusOK, recordRequest(router, restore("secret")).Code)
+}
+
+func TestRouterApiStats(t *testing.T) {
+	router := newTestRouter()
+	router.AdminToken = "secret"
+
+	stats := func() *http.Request {
+		request := httptest.NewRequest(http.MethodGet, "/api/admin/stats", nil)
+		request.Header.Set("Authorization", "Bearer secret")
+		return request
+	}
+
+	recorder := recordRequest(router, stats())
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{}`, recorder.Body.String(), "stores without a cache or visit queue report nothing")
+
+	assert.Equal(t, http.StatusUnauthorized, recordRequest(router, httptest.NewRequest(http.MethodGet, "/api/admin/stats", nil)).Code)
+
+	store := shrink.Must(shrink.NewRedisStore(shrink.RedisStoreOptions{
+		Expiration:         time.Minute,
+		AsyncVisits:        true,
+		VisitFlushInterval: time.Hour,
+	}))
+	cache := shrink.NewCachedStore(shrink.CachedStoreOptions{Store: store})
+
+	defer cache.Close()
+
+	router.Shortener = shrink.NewShortener(shrink.ShortenerOptions{Store: cache, Random: rand.New(rand.NewSource(0))})
+
+	record := shrink.Must(router.Shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{}))
+
+	defer store.DeleteLink(context.Background(), record.Id)
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusFound, recordRequest(router, httptest.NewRequest(http.MethodGet, "/"+record.Id, nil)).Code)
+	}
+
+	recorder = recordRequest(router, stats())
+
+	var received struct {
+		Cache      *shrink.CacheStats      `json:"cache"`
+		VisitQueue *shrink.VisitQueueStats `json:"visit_queue"`
+	}
+	unmarshalJSON(recorder.Body.Bytes(), &received)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, &shrink.CacheStats{Hits: 2, Misses: 1}, received.Cache)
+	assert.Equal(t, &shrink.VisitQueueStats{Queued: 6}, received.VisitQueue, "the visit queue of the wrapped store is reported, with every visit and its details")
+
+	link := shrink.Must(store.GetLink(context.Background(), record.Id))
+	breakdowns := shrink.Must(store.LinkBreakdowns(context.Background(), record.Id, 10))
+
+	assert.Equal(t, int64(0), link.Visits, "visits to cached links are only written in the background")
+	assert.Empty(t, breakdowns[shrink.ByReferrer])
+}
+
+func TestRouterRedirectAsyncVisits(t *testing.T) {
+	router := newTestRouter()
+
+	store := shrink.Must(shrink.NewRedisStore(shrink.RedisStoreOptions{
+		Expiration:         time.Minute,
+		AsyncVisits:        true,
+		VisitFlushInterval: time.Hour,
+	}))
+	reader := newTestRedisStore()
+
+	defer reader.Close()
+
+	router.Shortener = shrink.NewShortener(shrink.ShortenerOptions{Store: store, Random: rand.New(rand.NewSource(0))})
+
+	record := shrink.Must(router.Shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{}))
+
+	defer reader.DeleteLink(context.Background(), record.Id)
+
+	visit := httptest.NewRequest(http.MethodGet, "/"+record.Id, nil)
+	visit.Header.Set("Referer", "https://news.example.net/story")
+
+	assert.Equal(t, http.StatusFound, recordRequest(router, visit).Code)
+
+	link := shrink.Must(reader.GetLink(context.Background(), record.Id))
+	breakdowns := shrink.Must(reader.LinkBreakdowns(context.Background(), record.Id, 10))
+
+	assert.Equal(t, int64(0), link.Visits, "redirects make no writes to the store")
+	assert.Equal(t, int64(0), link.UniqueVisitors)
+	assert.Empty(t, breakdowns[shrink.ByReferrer])
+
+	assert.Nil(t, store.Close())
+
+	link = shrink.Must(reader.GetLink(context.Background(), record.Id))
+	breakdowns = shrink.Must(reader.LinkBreakdowns(context.Background(), record.Id, 10))
+
+	assert.Equal(t, int64(1), link.Visits)
+	assert.Equal(t, int64(1), link.UniqueVisitors)
+	assert.Equal(t, []shrink.BreakdownEntry{{Value: "news.example.net", Visits: 1}}, breakdowns[shrink.ByReferrer])
+}
+
+func TestRouterApiUnsupported(t *testing.T) {
+	router := newTestRouter()
+	router.AdminToken = "secret"
+	router.shortener.Store = basicStore{router.store}
+
+	record := shrink.Must(router.shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{}))
+
+	admin := func(method, target string) *http.Request {
+		request := httptest.NewRequest(method, target, nil)
+		request.Header.Set("Authorization", "Bearer secret")
+		return request
+	}
+
+	assert.Equal(t, http.StatusFound, recordRequest(router, httptest.NewRequest(http.MethodGet, "/"+record.Id, nil)).Code, "visits are not recorded without stats")
+	assert.Equal(t, http.StatusNotImplemented, recordRequest(router, admin(http.MethodGet, "/api/links/"+record.Id+"/stats")).Code)
+	assert.Equal(t, http.StatusNotImplemented, recordRequest(router, admin(http.MethodGet, "/api/admin/trash")).Code)
+	assert.Equal(t, http.StatusNotImplemented, recordRequest(router, admin(http.MethodPost, "/api/links/"+record.Id+"/restore")).Code)
+}
+
+func TestRouterApiListLinks(t *testing.T) {
+	router := newTestRouter()
+
+	list := func(query string) *http.Request {
+		request := httptest.NewRequest(http.MethodGet, "/api/links?"+query, nil)
+		request.Header.Set("Authorization", "Bearer secret")
+		return request
+	}
+
+	assert.Equal(t, http.StatusMethodNotAllowed, recordRequest(router, list("")).Code, "listing requires an admin token")
+
+	router.AdminToken = "secret"
+
+	for _, link := range []string{"http://example.org/a", "http://example.org/b", "http://example.net/c"} {
+		shrink.Must(router.shortener.Shorten(context.Background(), localURL, link, shrink.LinkOptions{}))
+	}
+
+	request := list("")
+	request.Header.Del("Authorization")
+
+	assert.Equal(t, http.StatusUnauthorized, recordRequest(router, request).Code)
+
+	var page struct {
+		Links      []shrink.Record `json:"links"`
+		NextCursor string          `json:"next_cursor"`
+	}
+
+	seen := map[string]bool{}
+	cursor := ""
+
+	for {
+		recorder := recordRequest(router, list(url.Values{"cursor": {cursor}, "limit": {"2"}}.Encode()))
+
+		page.NextCursor = ""
+		unmarshalJSON(recorder.Body.Bytes(), &page)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.LessOrEqual(t, len(page.Links), 2)
+
+		for _, record := range page.Links {
+			seen[record.ExpandedUrl] = true
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+
+		cursor = page.NextCursor
+	}
+
+	assert.Len(t, seen, 3)
+
+	recorder := recordRequest(router, list("url=example.net"))
+	unmarshalJSON(recorder.Body.Bytes(), &page)
 
 	assert.Equal(t, http.StatusOK, recorder.Code)
-	assert.Equal(t, record, received)
+	assert.Len(t, page.Links, 1)
+	assert.Equal(t, "http://example.net/c", page.Links[0].ExpandedUrl)
+
+	assert.Equal(t, http.StatusBadRequest, recordRequest(router, list("limit=many")).Code)
+	assert.Equal(t, http.StatusBadRequest, recordRequest(router, list("limit=5000")).Code)
+}
+
+func TestRouterApiLinkStats(t *testing.T) {
+	router := newTestRouter()
+	router.AdminToken = "secret"
+
+	record := shrink.Must(router.shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{}))
+
+	router.shortener.Expand(context.Background(), localURL, record.Id)
+
+	stats := func(id, query string) *http.Request {
+		request := httptest.NewRequest(http.MethodGet, "/api/links/"+id+"/stats?"+query, nil)
+		request.Header.Set("Authorization", "Bearer secret")
+		return request
+	}
+
+	now := time.Now()
+	query := url.Values{
+		"granularity": {"day"},
+		"from":        {now.AddDate(0, 0, -6).Format(time.RFC3339)},
+		"to":          {now.Format(time.RFC3339)},
+	}
+
+	recorder := recordRequest(router, stats(record.Id, query.Encode()))
+
+	var received struct {
+		shrink.Record
+		Granularity shrink.Granularity                           `json:"granularity"`
+		Series      []shrink.StatsBucket                         `json:"series"`
+		Breakdowns  map[shrink.Dimension][]shrink.BreakdownEntry `json:"breakdowns"`
+	}
+
+	unmarshalJSON(recorder.Body.Bytes(), &received)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, record.Id, received.Id)
+	assert.Equal(t, shrink.Daily, received.Granularity)
+	assert.Len(t, received.Series, 7)
+	assert.Equal(t, int64(1), received.Series[6].Visits)
+
+	visit := httptest.NewRequest(http.MethodGet, "/"+record.Id, nil)
+	visit.Header.Set("Referer", "https://news.example.net/story")
+	visit.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:126.0) Gecko/20100101 Firefox/126.0")
+
+	assert.Equal(t, http.StatusFound, recordRequest(router, visit).Code)
+
+	recorder = recordRequest(router, stats(record.Id, "top=1"))
+	unmarshalJSON(recorder.Body.Bytes(), &received)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, []shrink.BreakdownEntry{{Value: "news.example.net", Visits: 1}}, received.Breakdowns[shrink.ByReferrer])
+	assert.Equal(t, []shrink.BreakdownEntry{{Value: "Firefox", Visits: 1}}, received.Breakdowns[shrink.ByBrowser])
+	assert.Equal(t, int64(1), received.UniqueVisitors)
+	assert.Equal(t, http.StatusBadRequest, recordRequest(router, stats(record.Id, "top=1000")).Code)
+
+	for _, addr := range []string{"203.0.113.7:1234", "203.0.113.7:5678", "203.0.113.8:1234"} {
+		visit := httptest.NewRequest(http.MethodGet, "/"+record.Id, nil)
+		visit.RemoteAddr = addr
+
+		recordRequest(router, visit)
+	}
+
+	recorder = recordRequest(router, stats(record.Id, ""))
+	unmarshalJSON(recorder.Body.Bytes(), &received)
+
+	assert.Equal(t, int64(5), received.Visits)
+	assert.Equal(t, int64(3), received.UniqueVisitors, "visitors are told apart by IP address and user agent")
+
+	request := stats(record.Id, "")
+	request.Header.Del("Authorization")
+
+	assert.Equal(t, http.StatusUnauthorized, recordRequest(router, request).Code)
+	assert.Equal(t, http.StatusOK, recordRequest(router, stats(record.Id, "")).Code)
+	assert.Equal(t, http.StatusNotFound, recordRequest(router, stats("missing", "")).Code)
+	assert.Equal(t, http.StatusBadRequest, recordRequest(router, stats(record.Id, "granularity=week")).Code)
+	assert.Equal(t, http.StatusBadRequest, recordRequest(router, stats(record.Id, "from=yesterday")).Code)
 }
 
 func recordRequest(router *testRouter, request *http.Request) *httptest.ResponseRecorder {
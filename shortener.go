@@ -1,20 +1,77 @@
 package shrinkmyurl
 
 import (
+	"bufio"
 	"context"
+	crand "crypto/rand"
 	"fmt"
 	"math/rand"
+	"net/http"
 	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/sqids/sqids-go"
 )
 
 // Represents a shortened URL record.
 type Record struct {
-	Id           string `json:"id"`
-	Visits       int64  `json:"visits"`
-	ExpandedUrl  string `json:"expanded_url"`
-	ShortenedUrl string `json:"shortened_url"`
+	Id             string     `json:"id"`
+	Visits         int64      `json:"visits"`
+	UniqueVisitors int64      `json:"unique_visitors"`
+	ExpandedUrl    string     `json:"expanded_url"`
+	ShortenedUrl   string     `json:"shortened_url"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	MaxVisits      int64      `json:"max_visits,omitempty"`
+	Protected      bool       `json:"protected,omitempty"`
+	NotBefore      *time.Time `json:"not_before,omitempty"`
+	NotAfter       *time.Time `json:"not_after,omitempty"`
+	Version        int64      `json:"version,omitempty"`
+	Token          string     `json:"token,omitempty"`
+	DeletedAt      *time.Time `json:"deleted_at,omitempty"`
+}
+
+// Default and maximum number of shortened URLs in a page of a listing.
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 1000
+)
+
+// Aliases that would shadow the service's own routes.
+var DefaultReservedAliases = []string{"api", "shorten", "favicon.ico"}
+
+// An alphabet for generated IDs without easily confused characters such as 0/O and l/1/I.
+const UnambiguousAlphabet = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// Allowed characters and length for custom aliases.
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{3,64}$`)
+
+// Allowed characters for the alphabet of generated IDs, the same as for aliases, so that IDs are safe in URLs
+// and store keys.
+var alphabetPattern = regexp.MustCompile(`^[A-Za-z0-9_-]*$`)
+
+// Generates the numbers that are encoded into link IDs.
+type IDGenerator interface {
+	NextNumber(ctx context.Context) (uint64, error)
+}
+
+// A store that provides a monotonically increasing sequence of numbers.
+type Counter interface {
+	NextSequence(ctx context.Context) (uint64, error)
+}
+
+// Generates IDs from a store's sequence, so that generated IDs never collide with each other
+// and stay short. Collisions with custom aliases are still handled by retrying.
+type CounterIDGenerator struct {
+	Counter Counter
+}
+
+// Get the next number in the store's sequence.
+func (g CounterIDGenerator) NextNumber(ctx context.Context) (uint64, error) {
+	return g.Counter.NextSequence(ctx)
 }
 
 // Options for the Shortener service.
@@ -22,16 +79,201 @@ type ShortenerOptions struct {
 	Store      Store
 	Random     *rand.Rand
 	MaxRetries uint
+	// Strategy for generating IDs. Defaults to random numbers from Random.
+	IDGenerator IDGenerator
+	// Return the existing link for a URL that was already shortened instead of creating another.
+	// Requires a store that implements Deduplicator.
+	Deduplicate bool
+	// Aliases that may not be requested, compared case-insensitively. Defaults to DefaultReservedAliases.
+	ReservedAliases []string
+	// Characters used in generated IDs. Defaults to the sqids alphabet.
+	Alphabet string
+	// Minimum length of generated IDs.
+	MinLength uint8
+	// Words that generated IDs may not contain, in addition to the sqids default blocklist.
+	Blocklist []string
+	// Steps applied to links before they are stored, such as DefaultNormalizers. Disabled if empty.
+	Normalizers []Normalizer
+	// Rules that links must satisfy, checked after normalization. Disabled if nil.
+	Policy *URLPolicy
+	// Domains that links are limited to or may not point to. Disabled if nil.
+	Domains *DomainFilter
+	// Other hosts this service is served under, in addition to the host of the request.
+	Hosts []string
+	// Domain patterns of other URL shorteners, such as DefaultKnownShorteners, whose links are followed to
+	// check where they lead.
+	KnownShorteners []string
+	// Store the final destination of links to this service and known shorteners, instead of rejecting links
+	// to this service.
+	ResolveShortLinks bool
+	// Maximum number of short links to follow. Defaults to 3.
+	MaxRedirects int
+	// Client for following links to known shorteners. Must not follow redirects itself.
+	HTTPClient *http.Client
+	// Number of wrong passwords allowed for a protected link per PasswordAttemptWindow. Defaults to 5.
+	MaxPasswordAttempts int
+	// Window in which wrong password attempts are counted. Defaults to one minute.
+	PasswordAttemptWindow time.Duration
+	// How long deleted links can be restored before they are purged. Defaults to 30 days.
+	TrashRetention time.Duration
+	// Secret mixed into the hashes identifying unique visitors, which must be the same on every instance using
+	// the same store. Defaults to a random salt, so that visitors are counted again after a restart.
+	VisitorSalt string
+}
+
+// Options for shortening a single link.
+type LinkOptions struct {
+	// Custom ID to use instead of a generated one.
+	Alias string
+	// Lifetime of the link, refreshed on every visit unless AbsoluteExpiry. Defaults to the store's expiration.
+	TTL time.Duration
+	// Expire the link a TTL after it was created, rather than a TTL after its last visit.
+	AbsoluteExpiry bool
+	// Fixed time at which the link expires, instead of a TTL.
+	ExpiresAt time.Time
+	// Number of visits after which the link is gone. Zero is unlimited.
+	MaxVisits int64
+	// Password required to visit the link, stored as a bcrypt hash. Empty if the link is not protected.
+	Password string
+	// Time from which the link redirects. Zero is immediately. A TTL is counted from this time.
+	NotBefore time.Time
+	// Time from which the link stops redirecting. Zero is never.
+	NotAfter time.Time
+}
+
+// Validate the options at the given time.
+func (o LinkOptions) validate(now time.Time) error {
+	if o.TTL < 0 || (!o.ExpiresAt.IsZero() && (o.TTL != 0 || !o.ExpiresAt.After(now))) {
+		return ErrInvalidExpiration
+	}
+
+	if o.MaxVisits < 0 {
+		return ErrInvalidMaxVisits
+	}
+
+	if len(o.Password) > maxPasswordLength {
+		return ErrInvalidPassword
+	}
+
+	if !o.NotAfter.IsZero() && (!o.NotAfter.After(now) || !o.NotAfter.After(o.NotBefore)) {
+		return ErrInvalidSchedule
+	}
+
+	if !o.NotBefore.IsZero() && !o.ExpiresAt.IsZero() && !o.ExpiresAt.After(o.NotBefore) {
+		return ErrInvalidSchedule
+	}
+
+	return nil
+}
+
+// Report whether the options give the link settings of its own, rather than the store's defaults.
+func (o LinkOptions) custom() bool {
+	return o.TTL != 0 || o.AbsoluteExpiry || !o.ExpiresAt.IsZero() || o.MaxVisits != 0 || o.Password != "" ||
+		!o.NotBefore.IsZero() || !o.NotAfter.IsZero()
+}
+
+// Get the link to store for the given URL, hashing the password if there is one.
+func (o LinkOptions) link(url string) (Link, error) {
+	link := Link{
+		URL:       url,
+		TTL:       o.TTL,
+		Absolute:  o.AbsoluteExpiry,
+		ExpiresAt: o.ExpiresAt,
+		MaxVisits: o.MaxVisits,
+		NotBefore: o.NotBefore,
+		NotAfter:  o.NotAfter,
+	}
+
+	if o.Password != "" {
+		hash, err := hashPassword(o.Password)
+
+		if err != nil {
+			return Link{}, err
+		}
+
+		link.PasswordHash = hash
+	}
+
+	return link, nil
+}
+
+// Options for changing the destination of a link.
+type UpdateOptions struct {
+	// Version the link must be at for the change to apply, or zero to apply it regardless.
+	Version int64
+	// Who made the change, kept in the revision history.
+	Author string
 }
 
 // Shortener is a service that shortens and expands URLs.
 type Shortener struct {
 	ShortenerOptions
+
+	ids         *sqids.Sqids
+	randomMu    sync.Mutex
+	attempts    *attemptLimiter
+	visitorSalt []byte
 }
 
 // Create a new Shortener with the given options.
+// Panics if the ID alphabet is invalid, which ValidateIDOptions reports beforehand.
 func NewShortener(ops ShortenerOptions) *Shortener {
-	return &Shortener{ShortenerOptions: ops}
+	ids := Must(newSqids(ops))
+
+	salt := []byte(ops.VisitorSalt)
+
+	if len(salt) == 0 {
+		salt = make([]byte, 32)
+		Must(crand.Read(salt))
+	}
+
+	return &Shortener{ShortenerOptions: ops, ids: ids, attempts: newAttemptLimiter(), visitorSalt: salt}
+}
+
+// Check that the alphabet, minimum length and blocklist of the options can generate IDs.
+func ValidateIDOptions(ops ShortenerOptions) error {
+	_, err := newSqids(ops)
+
+	return err
+}
+
+// Create the encoder of generated IDs from the options. Returns ErrInvalidAlphabet if the alphabet has
+// characters that aliases may not.
+func newSqids(ops ShortenerOptions) (*sqids.Sqids, error) {
+	if !alphabetPattern.MatchString(ops.Alphabet) {
+		return nil, ErrInvalidAlphabet
+	}
+
+	return sqids.New(sqids.Options{
+		Alphabet:  ops.Alphabet,
+		MinLength: ops.MinLength,
+		Blocklist: sqids.Blocklist(ops.Blocklist...),
+	})
+}
+
+// Load a blocklist from a file with one word per line, ignoring blank lines and # comments.
+func LoadBlocklist(path string) ([]string, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var words []string
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+
+		if word != "" && !strings.HasPrefix(word, "#") {
+			words = append(words, word)
+		}
+	}
+
+	return words, scanner.Err()
 }
 
 // Validate that the given link is a valid URL.
@@ -41,13 +283,94 @@ func (s *Shortener) Validate(link string) bool {
 	return err == nil
 }
 
+// Normalize the given link with the configured normalizers, so that equivalent URLs are stored the same way.
+func (s *Shortener) Normalize(link string) (string, error) {
+	if len(s.Normalizers) == 0 {
+		return link, nil
+	}
+
+	return Normalize(link, s.Normalizers)
+}
+
+// Validate that the given alias is allowed as a custom ID.
+func (s *Shortener) ValidateAlias(alias string) error {
+	if !aliasPattern.MatchString(alias) {
+		return ErrInvalidAlias
+	}
+
+	reserved := s.ReservedAliases
+
+	if reserved == nil {
+		reserved = DefaultReservedAliases
+	}
+
+	for _, r := range reserved {
+		if strings.EqualFold(alias, r) {
+			return ErrReservedAlias
+		}
+	}
+
+	return nil
+}
+
 // Store a shortened URL using a random unique ID, and return the resulting record.
 // If the ID already exists, retry until a unique ID is generated or the max retries is reached.
-func (s *Shortener) Shorten(ctx context.Context, host url.URL, link string) (Record, error) {
+// If an alias is requested it is used as the ID instead, failing with ErrExists if it is taken.
+// When deduplicating, the existing record for an already shortened URL is returned with its visits,
+// unless the link has settings of its own.
+// The link is normalized and checked against the policy and domain lists before it is stored, and the normalized form
+// is returned in the record. Links to this service or through other shorteners are rejected or resolved.
+// New links are given a management token, returned once in the record, which allows their owner to delete them.
+// Deduplicated links are shared, so they have none.
+func (s *Shortener) Shorten(ctx context.Context, host url.URL, link string, ops LinkOptions) (Record, error) {
 	if !s.Validate(link) {
 		return Record{}, ErrInvalidURL
 	}
 
+	now := time.Now()
+
+	if err := ops.validate(now); err != nil {
+		return Record{}, err
+	}
+
+	link, err := s.prepare(ctx, host, link)
+
+	if err != nil {
+		return Record{}, err
+	}
+
+	stored, err := ops.link(link)
+
+	if err != nil {
+		return Record{}, err
+	}
+
+	token, hash, err := newToken()
+
+	if err != nil {
+		return Record{}, err
+	}
+
+	stored.TokenHash = hash
+
+	if ops.Alias != "" {
+		return s.shortenAlias(ctx, host, stored, token, ops, now)
+	}
+
+	var dedup Deduplicator
+
+	if s.Deduplicate {
+		var ok bool
+
+		if dedup, ok = s.Store.(Deduplicator); !ok {
+			return Record{}, ErrDeduplicateUnsupported
+		}
+
+		if ops.custom() {
+			dedup = nil
+		}
+	}
+
 	var retries uint
 
 	for {
@@ -55,27 +378,102 @@ func (s *Shortener) Shorten(ctx context.Context, host url.URL, link string) (Rec
 			return Record{}, ErrMaxRetries
 		}
 
-		id, err := s.generateId()
+		id, err := s.generateId(ctx)
 
 		if err != nil {
 			return Record{}, err
 		}
 
-		ok, err := s.Store.AddLink(ctx, id, link)
+		if dedup != nil {
+			existing, visits, err := dedup.AddOrGetLink(ctx, id, link)
 
-		if err != nil && err != ErrExists {
-			return Record{}, err
+			if err == nil {
+				return s.withUniqueVisitors(ctx, Record{Id: existing, Visits: visits, ExpandedUrl: link, ShortenedUrl: shortenedUrl(host, existing)})
+			} else if err != ErrExists {
+				return Record{}, err
+			}
+
+			retries++
+			continue
 		}
 
-		if ok {
-			return Record{Id: id, ExpandedUrl: link, ShortenedUrl: shortenedUrl(host, id)}, nil
+		err = s.Store.CreateLink(ctx, id, stored)
+
+		if err == nil {
+			return newRecord(host, id, link, token, ops, now), nil
+		} else if err != ErrExists {
+			return Record{}, err
 		}
 
 		retries++
 	}
 }
 
+// Normalize the link, follow it through this service and known shorteners, and check it, returning the
+// form of the link to store.
+func (s *Shortener) prepare(ctx context.Context, host url.URL, link string) (string, error) {
+	link, err := s.Normalize(link)
+
+	if err != nil {
+		return "", err
+	}
+
+	final, err := s.follow(ctx, host, link)
+
+	if err != nil {
+		return "", err
+	}
+
+	if s.ResolveShortLinks && final != link {
+		if link, err = s.Normalize(final); err != nil {
+			return "", err
+		}
+	}
+
+	if err := s.check(ctx, link); err != nil {
+		return "", err
+	}
+
+	return link, nil
+}
+
+// Check that the link satisfies the policy and domain lists, if configured.
+func (s *Shortener) check(ctx context.Context, link string) error {
+	if s.Policy != nil {
+		if err := s.Policy.Check(ctx, link); err != nil {
+			return err
+		}
+	}
+
+	if s.Domains != nil {
+		u, err := url.Parse(link)
+
+		if err != nil {
+			return ErrInvalidURL
+		}
+
+		return s.Domains.Check(u.Hostname())
+	}
+
+	return nil
+}
+
+// Store a shortened URL using the requested alias as its ID.
+func (s *Shortener) shortenAlias(ctx context.Context, host url.URL, link Link, token string, ops LinkOptions, now time.Time) (Record, error) {
+	if err := s.ValidateAlias(ops.Alias); err != nil {
+		return Record{}, err
+	}
+
+	if err := s.Store.CreateLink(ctx, ops.Alias, link); err != nil {
+		return Record{}, err
+	}
+
+	return newRecord(host, ops.Alias, link.URL, token, ops, now), nil
+}
+
 // Expand the shortened URL by ID, if it exists, and increment the visit count.
+// Returns ErrDeleted if the link is in the trash, ErrNotActive before the link's activation window and
+// ErrExpired after it, or ErrProtected if the link is password protected, which requires Unlock instead.
 func (s *Shortener) Expand(ctx context.Context, host url.URL, id string) (Record, error) {
 	link, visits, err := s.Store.ExpandLink(ctx, id)
 
@@ -93,15 +491,263 @@ func (s *Shortener) Expand(ctx context.Context, host url.URL, id string) (Record
 	return record, nil
 }
 
+// Set the unique visitors of a shortened URL's record from the store, which expanding a link does not read, so
+// that redirects do not pay for it.
+func (s *Shortener) withUniqueVisitors(ctx context.Context, record Record) (Record, error) {
+	link, err := s.Store.GetLink(ctx, record.Id)
+
+	if err != nil {
+		return Record{}, err
+	}
+
+	record.UniqueVisitors = link.UniqueVisitors
+
+	return record, nil
+}
+
+// Change the destination of the shortened URL by ID, keeping the previous destination in its history.
+// The new destination is normalized and checked like a new link. Returns ErrVersionMismatch if the link
+// is not at the expected version.
+func (s *Shortener) Update(ctx context.Context, host url.URL, id, link string, ops UpdateOptions) (Record, error) {
+	if !s.Validate(link) {
+		return Record{}, ErrInvalidURL
+	}
+
+	link, err := s.prepare(ctx, host, link)
+
+	if err != nil {
+		return Record{}, err
+	}
+
+	updated, err := s.Store.UpdateLink(ctx, id, LinkUpdate{URL: link, Version: ops.Version, Author: ops.Author})
+
+	if err != nil {
+		return Record{}, err
+	}
+
+	return linkRecord(host, id, updated), nil
+}
+
+// Get the shortened URL by ID with its past destinations, oldest first, without counting a visit.
+func (s *Shortener) History(ctx context.Context, host url.URL, id string) (Record, []Revision, error) {
+	link, err := s.Store.GetLink(ctx, id)
+
+	if err != nil {
+		return Record{}, nil, err
+	}
+
+	revisions, err := s.Store.LinkHistory(ctx, id)
+
+	if err != nil {
+		return Record{}, nil, err
+	}
+
+	return linkRecord(host, id, link), revisions, nil
+}
+
+// Get a page of at most limit shortened URLs matching the filter, starting from the cursor of a previous page,
+// without counting visits. Returns the cursor of the next page, which is empty after the last page.
+// A limit of zero gets the default page size, and ErrInvalidLimit is returned for one out of range.
+func (s *Shortener) List(ctx context.Context, host url.URL, cursor string, limit int, filter LinkFilter) ([]Record, string, error) {
+	if limit == 0 {
+		limit = defaultPageLimit
+	} else if limit < 0 || limit > maxPageLimit {
+		return nil, "", ErrInvalidLimit
+	}
+
+	links, next, err := s.Store.ListLinks(ctx, cursor, limit, filter)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	records := make([]Record, 0, len(links))
+
+	for _, link := range links {
+		records = append(records, linkRecord(host, link.Id, link.Link))
+	}
+
+	return records, next, nil
+}
+
+// Delete the shortened URL by ID with its management token, moving it to the trash until it is purged, or
+// deleting it permanently if the store has no trash.
+// Returns ErrInvalidToken if the token does not match, or the link has none, and ErrDeleted if the link is
+// already in the trash.
+func (s *Shortener) Delete(ctx context.Context, id, token string) error {
+	link, err := s.Store.GetLink(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if !checkToken(link.TokenHash, token) {
+		return ErrInvalidToken
+	}
+
+	return s.trashLink(ctx, id)
+}
+
+// Delete the shortened URL by ID regardless of its management token, such as on behalf of an admin,
+// moving it to the trash until it is purged, or deleting it permanently if the store has no trash.
+func (s *Shortener) ForceDelete(ctx context.Context, id string) error {
+	return s.trashLink(ctx, id)
+}
+
+// Expand a password protected URL by ID with its password, and increment the visit count.
+// Links that are not protected are expanded as usual. Returns ErrIncorrectPassword if the password is wrong,
+// or ErrTooManyAttempts if too many wrong passwords were tried for the link recently.
+func (s *Shortener) Unlock(ctx context.Context, host url.URL, id, password string) (Record, error) {
+	link, err := s.Store.GetLink(ctx, id)
+
+	if err != nil {
+		return Record{}, err
+	}
+
+	if !link.DeletedAt.IsZero() {
+		return Record{}, ErrDeleted
+	}
+
+	if link.PasswordHash == "" {
+		record, err := s.Expand(ctx, host, id)
+		record.UniqueVisitors = link.UniqueVisitors
+
+		return record, err
+	}
+
+	if !s.attempts.take(id, s.maxPasswordAttempts(), s.passwordAttemptWindow(), time.Now()) {
+		return Record{}, ErrTooManyAttempts
+	}
+
+	if !checkPassword(link.PasswordHash, password) {
+		return Record{}, ErrIncorrectPassword
+	}
+
+	s.attempts.reset(id)
+
+	expanded, visits, err := s.Store.ExpandProtectedLink(ctx, id, link.PasswordHash)
+
+	if err != nil {
+		return Record{}, err
+	}
+
+	record := Record{
+		Id:             id,
+		ExpandedUrl:    expanded,
+		ShortenedUrl:   shortenedUrl(host, id),
+		Visits:         visits,
+		UniqueVisitors: link.UniqueVisitors,
+		Protected:      true,
+	}
+
+	return record, nil
+}
+
+// Get the number of wrong passwords allowed per window.
+func (s *Shortener) maxPasswordAttempts() int {
+	if s.MaxPasswordAttempts <= 0 {
+		return defaultMaxPasswordAttempts
+	}
+
+	return s.MaxPasswordAttempts
+}
+
+// Get the window in which wrong passwords are counted.
+func (s *Shortener) passwordAttemptWindow() time.Duration {
+	if s.PasswordAttemptWindow <= 0 {
+		return defaultPasswordAttemptWindow
+	}
+
+	return s.PasswordAttemptWindow
+}
+
 // Generate a unique ID for the shortened URL.
-func (s *Shortener) generateId() (string, error) {
-	ids, err := sqids.New()
+func (s *Shortener) generateId(ctx context.Context) (string, error) {
+	n, err := s.nextNumber(ctx)
 
 	if err != nil {
 		return "", err
 	}
 
-	return ids.Encode([]uint64{s.Random.Uint64()})
+	return s.ids.Encode([]uint64{n})
+}
+
+// Get the next number to encode from the ID generator, or a random number by default.
+func (s *Shortener) nextNumber(ctx context.Context) (uint64, error) {
+	if s.IDGenerator != nil {
+		return s.IDGenerator.NextNumber(ctx)
+	}
+
+	s.randomMu.Lock()
+	defer s.randomMu.Unlock()
+
+	return s.Random.Uint64(), nil
+}
+
+// Create the record for a link created at the given time with the given options and management token.
+// The expiration and activation window are included if the link has its own.
+func newRecord(host url.URL, id, link, token string, ops LinkOptions, now time.Time) Record {
+	record := Record{
+		Id:           id,
+		ExpandedUrl:  link,
+		ShortenedUrl: shortenedUrl(host, id),
+		MaxVisits:    ops.MaxVisits,
+		Protected:    ops.Password != "",
+		Token:        token,
+	}
+
+	if !ops.NotBefore.IsZero() {
+		record.NotBefore = &ops.NotBefore
+	}
+
+	if !ops.NotAfter.IsZero() {
+		record.NotAfter = &ops.NotAfter
+	}
+
+	if ops.NotBefore.After(now) {
+		now = ops.NotBefore
+	}
+
+	if !ops.ExpiresAt.IsZero() {
+		record.ExpiresAt = &ops.ExpiresAt
+	} else if ops.TTL > 0 {
+		expiresAt := now.Add(ops.TTL)
+		record.ExpiresAt = &expiresAt
+	}
+
+	return record
+}
+
+// Create the record for a link held by the store.
+func linkRecord(host url.URL, id string, link Link) Record {
+	record := Record{
+		Id:             id,
+		Visits:         link.Visits,
+		UniqueVisitors: link.UniqueVisitors,
+		ExpandedUrl:    link.URL,
+		ShortenedUrl:   shortenedUrl(host, id),
+		MaxVisits:      link.MaxVisits,
+		Protected:      link.PasswordHash != "",
+		Version:        link.Version,
+	}
+
+	if !link.ExpiresAt.IsZero() {
+		record.ExpiresAt = &link.ExpiresAt
+	}
+
+	if !link.NotBefore.IsZero() {
+		record.NotBefore = &link.NotBefore
+	}
+
+	if !link.NotAfter.IsZero() {
+		record.NotAfter = &link.NotAfter
+	}
+
+	if !link.DeletedAt.IsZero() {
+		record.DeletedAt = &link.DeletedAt
+	}
+
+	return record
 }
 
 // Return the shortened URL for the given ID.
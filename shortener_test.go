@@ -4,7 +4,12 @@ import (
 	"context"
 	"math/rand"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	shrink "github.com/derek-schaefer/shrink-my-url"
 	"github.com/stretchr/testify/assert"
@@ -16,12 +21,17 @@ type testShortener struct {
 	store shrink.Store
 }
 
+// A store with none of the optional interfaces, such as stats or a trash.
+type basicStore struct {
+	shrink.Store
+}
+
 func (t *testShortener) resetRandom() {
 	t.Random = rand.New(rand.NewSource(0))
 }
 
 func newTestShortener() *testShortener {
-	store := shrink.NewMemoryStore()
+	store := shrink.Must(shrink.NewMemoryStore(shrink.MemoryStoreOptions{}))
 
 	shortener := &testShortener{
 		Shortener: shrink.NewShortener(shrink.ShortenerOptions{
@@ -42,6 +52,124 @@ func TestNewShortener(t *testing.T) {
 	assert.Equal(t, shortener.store, shortener.Store)
 }
 
+func TestNewShortenerInvalidAlphabet(t *testing.T) {
+	assert.Panics(t, func() {
+		shrink.NewShortener(shrink.ShortenerOptions{Alphabet: "aab"})
+	})
+}
+
+func TestValidateIDOptions(t *testing.T) {
+	assert.NoError(t, shrink.ValidateIDOptions(shrink.ShortenerOptions{}))
+	assert.NoError(t, shrink.ValidateIDOptions(shrink.ShortenerOptions{Alphabet: shrink.UnambiguousAlphabet, MinLength: 20}))
+	assert.Error(t, shrink.ValidateIDOptions(shrink.ShortenerOptions{Alphabet: "aab"}))
+	assert.Error(t, shrink.ValidateIDOptions(shrink.ShortenerOptions{Alphabet: "ab"}))
+	assert.Error(t, shrink.ValidateIDOptions(shrink.ShortenerOptions{Alphabet: "abcdé"}))
+	assert.Equal(t, shrink.ErrInvalidAlphabet, shrink.ValidateIDOptions(shrink.ShortenerOptions{Alphabet: "ab/?#:%cdefgh"}))
+	assert.Equal(t, shrink.ErrInvalidAlphabet, shrink.ValidateIDOptions(shrink.ShortenerOptions{Alphabet: "abcdefgh:"}))
+	assert.NoError(t, shrink.ValidateIDOptions(shrink.ShortenerOptions{Alphabet: "abcdefgh-_"}))
+}
+
+func TestShortenerIdOptions(t *testing.T) {
+	shortener := shrink.NewShortener(shrink.ShortenerOptions{
+		Store:     shrink.Must(shrink.NewMemoryStore(shrink.MemoryStoreOptions{})),
+		Random:    rand.New(rand.NewSource(0)),
+		Alphabet:  shrink.UnambiguousAlphabet,
+		MinLength: 20,
+	})
+
+	url := url.URL{
+		Scheme: "http",
+		Host:   "example.com",
+	}
+
+	for i := 0; i < 100; i++ {
+		record := shrink.Must(shortener.Shorten(context.Background(), url, "http://asdf.com", shrink.LinkOptions{}))
+
+		assert.GreaterOrEqual(t, len(record.Id), 20)
+		assert.False(t, strings.ContainsAny(record.Id, "0O1lI"), record.Id)
+	}
+}
+
+func TestShortenerCounterIDGenerator(t *testing.T) {
+	store := shrink.Must(shrink.NewMemoryStore(shrink.MemoryStoreOptions{}))
+
+	shortener := shrink.NewShortener(shrink.ShortenerOptions{
+		Store:       store,
+		MaxRetries:  1,
+		IDGenerator: shrink.CounterIDGenerator{Counter: store},
+	})
+
+	url := url.URL{
+		Scheme: "http",
+		Host:   "example.com",
+	}
+
+	first := shrink.Must(shortener.Shorten(context.Background(), url, "http://asdf.com", shrink.LinkOptions{}))
+	second := shrink.Must(shortener.Shorten(context.Background(), url, "http://asdf.com", shrink.LinkOptions{}))
+
+	assert.Equal(t, "Uk", first.Id)
+	assert.Equal(t, "gb", second.Id)
+
+	// A taken ID is skipped rather than retried with the same number.
+	shrink.Must(store.AddLink(context.Background(), "Ef", "http://asdf.com"))
+
+	third := shrink.Must(shortener.Shorten(context.Background(), url, "http://asdf.com", shrink.LinkOptions{}))
+
+	assert.Equal(t, "Vq", third.Id)
+}
+
+func TestShortenerDeduplicate(t *testing.T) {
+	shortener := newTestShortener()
+	shortener.Deduplicate = true
+
+	url := url.URL{
+		Scheme: "http",
+		Host:   "example.com",
+	}
+
+	first := shrink.Must(shortener.Shorten(context.Background(), url, "http://asdf.com", shrink.LinkOptions{}))
+
+	shortener.Expand(context.Background(), url, first.Id)
+
+	second := shrink.Must(shortener.Shorten(context.Background(), url, "http://asdf.com", shrink.LinkOptions{}))
+
+	assert.Equal(t, first.Id, second.Id)
+	assert.Equal(t, int64(1), second.Visits)
+
+	other := shrink.Must(shortener.Shorten(context.Background(), url, "http://qwer.com", shrink.LinkOptions{}))
+
+	assert.NotEqual(t, first.Id, other.Id)
+}
+
+func TestShortenerDeduplicateUnsupported(t *testing.T) {
+	shortener := shrink.NewShortener(shrink.ShortenerOptions{
+		Store:       shrink.Must(shrink.NewSQLiteStore(shrink.SQLiteStoreOptions{Path: ":memory:"})),
+		Random:      rand.New(rand.NewSource(0)),
+		Deduplicate: true,
+	})
+
+	url := url.URL{
+		Scheme: "http",
+		Host:   "example.com",
+	}
+
+	_, err := shortener.Shorten(context.Background(), url, "http://asdf.com", shrink.LinkOptions{})
+
+	assert.Equal(t, shrink.ErrDeduplicateUnsupported, err)
+}
+
+func TestLoadBlocklist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+
+	assert.Nil(t, os.WriteFile(path, []byte("# words\nfoo\n\n  bar  \n"), 0o644))
+
+	assert.Equal(t, []string{"foo", "bar"}, shrink.Must(shrink.LoadBlocklist(path)))
+
+	_, err := shrink.LoadBlocklist(filepath.Join(t.TempDir(), "missing.txt"))
+
+	assert.NotNil(t, err)
+}
+
 func TestShortenerValidate(t *testing.T) {
 	shortener := newTestShortener()
 
@@ -57,7 +185,7 @@ func TestShortenerShorten(t *testing.T) {
 		Host:   "example.com",
 	}
 
-	record := shrink.Must(shortener.Shorten(context.Background(), url, "http://asdf.com"))
+	record := shrink.Must(shortener.Shorten(context.Background(), url, "http://asdf.com", shrink.LinkOptions{}))
 
 	defer shortener.store.DeleteLink(context.Background(), record.Id)
 
@@ -67,11 +195,126 @@ func TestShortenerShorten(t *testing.T) {
 
 	shortener.resetRandom()
 
-	record, err := shortener.Shorten(context.Background(), url, "http://asdf.com")
+	record, err := shortener.Shorten(context.Background(), url, "http://asdf.com", shrink.LinkOptions{})
 
 	assert.Equal(t, shrink.ErrMaxRetries, err)
 }
 
+func TestShortenerShortenNormalized(t *testing.T) {
+	shortener := newTestShortener()
+	shortener.Normalizers = shrink.DefaultNormalizers
+	shortener.Deduplicate = true
+
+	url := url.URL{
+		Scheme: "http",
+		Host:   "example.com",
+	}
+
+	first := shrink.Must(shortener.Shorten(context.Background(), url, "HTTP://Example.org:80/a/../b?", shrink.LinkOptions{}))
+
+	assert.Equal(t, "http://example.org/b", first.ExpandedUrl)
+
+	second := shrink.Must(shortener.Shorten(context.Background(), url, "http://example.org/b", shrink.LinkOptions{}))
+
+	assert.Equal(t, first.Id, second.Id)
+}
+
+func TestShortenerShortenPolicy(t *testing.T) {
+	shortener := newTestShortener()
+	shortener.Policy = newTestPolicy()
+
+	_, err := shortener.Shorten(context.Background(), localURL, "file:///etc/passwd", shrink.LinkOptions{})
+
+	assert.ErrorIs(t, err, shrink.ErrPolicy)
+
+	_, err = shortener.Shorten(context.Background(), localURL, "http://localhost/", shrink.LinkOptions{Alias: "local"})
+
+	assert.ErrorIs(t, err, shrink.ErrPolicy)
+
+	record := shrink.Must(shortener.Shorten(context.Background(), localURL, "http://example.org/", shrink.LinkOptions{}))
+
+	assert.Equal(t, "http://example.org/", record.ExpandedUrl)
+}
+
+func TestShortenerShortenLinkOptions(t *testing.T) {
+	shortener := newTestShortener()
+	shortener.Deduplicate = true
+
+	invalid := map[shrink.LinkOptions]error{
+		{TTL: -time.Second}:                                    shrink.ErrInvalidExpiration,
+		{ExpiresAt: time.Now().Add(-time.Second)}:              shrink.ErrInvalidExpiration,
+		{ExpiresAt: time.Now().Add(time.Hour), TTL: time.Hour}: shrink.ErrInvalidExpiration,
+		{MaxVisits: -1}:                                        shrink.ErrInvalidMaxVisits,
+	}
+
+	for ops, expected := range invalid {
+		_, err := shortener.Shorten(context.Background(), localURL, "http://example.org", ops)
+
+		assert.Equal(t, expected, err)
+	}
+
+	plain := shrink.Must(shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{}))
+
+	assert.Nil(t, plain.ExpiresAt)
+
+	record := shrink.Must(shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{
+		TTL:       time.Hour,
+		MaxVisits: 1,
+	}))
+
+	assert.NotEqual(t, plain.Id, record.Id, "links with their own settings must not be deduplicated")
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *record.ExpiresAt, time.Second)
+	assert.Equal(t, int64(1), record.MaxVisits)
+
+	shrink.Must(shortener.Expand(context.Background(), localURL, record.Id))
+
+	_, err := shortener.Expand(context.Background(), localURL, record.Id)
+
+	assert.Equal(t, shrink.ErrGone, err)
+}
+
+func TestShortenerValidateAlias(t *testing.T) {
+	shortener := newTestShortener()
+
+	assert.Nil(t, shortener.ValidateAlias("q3-launch"))
+	assert.Nil(t, shortener.ValidateAlias("Q3_Launch"))
+	assert.Equal(t, shrink.ErrInvalidAlias, shortener.ValidateAlias("ab"))
+	assert.Equal(t, shrink.ErrInvalidAlias, shortener.ValidateAlias("q3 launch"))
+	assert.Equal(t, shrink.ErrInvalidAlias, shortener.ValidateAlias("q3/launch"))
+	assert.Equal(t, shrink.ErrReservedAlias, shortener.ValidateAlias("api"))
+	assert.Equal(t, shrink.ErrReservedAlias, shortener.ValidateAlias("Shorten"))
+
+	shortener.ReservedAliases = []string{"admin"}
+
+	assert.Nil(t, shortener.ValidateAlias("api"))
+	assert.Equal(t, shrink.ErrReservedAlias, shortener.ValidateAlias("admin"))
+}
+
+func TestShortenerShortenAlias(t *testing.T) {
+	shortener := newTestShortener()
+
+	url := url.URL{
+		Scheme: "http",
+		Host:   "example.com",
+	}
+
+	ops := shrink.LinkOptions{Alias: "q3-launch"}
+
+	record := shrink.Must(shortener.Shorten(context.Background(), url, "http://asdf.com", ops))
+
+	assert.Equal(t, "q3-launch", record.Id)
+	assert.Equal(t, "http://example.com/q3-launch", record.ShortenedUrl)
+	assert.Equal(t, "http://asdf.com", record.ExpandedUrl)
+
+	_, err := shortener.Shorten(context.Background(), url, "http://asdf.com", ops)
+
+	assert.Equal(t, shrink.ErrExists, err)
+
+	_, err = shortener.Shorten(context.Background(), url, "http://asdf.com", shrink.LinkOptions{Alias: "api"})
+
+	assert.Equal(t, shrink.ErrReservedAlias, err)
+}
+
 func TestShortenerExpand(t *testing.T) {
 	shortener := newTestShortener()
 
@@ -80,7 +323,7 @@ func TestShortenerExpand(t *testing.T) {
 		Host:   "example.com",
 	}
 
-	original := shrink.Must(shortener.Shorten(context.Background(), url, "http://asdf.com"))
+	original := shrink.Must(shortener.Shorten(context.Background(), url, "http://asdf.com", shrink.LinkOptions{}))
 
 	defer shortener.store.DeleteLink(context.Background(), original.Id)
 
@@ -91,3 +334,338 @@ func TestShortenerExpand(t *testing.T) {
 	assert.Equal(t, "http://example.com/"+original.Id, record.ShortenedUrl)
 	assert.Equal(t, "http://asdf.com", record.ExpandedUrl)
 }
+
+func TestShortenerUnlock(t *testing.T) {
+	shortener := newTestShortener()
+	shortener.MaxPasswordAttempts = 2
+
+	_, err := shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{Password: strings.Repeat("a", 73)})
+
+	assert.Equal(t, shrink.ErrInvalidPassword, err)
+
+	record := shrink.Must(shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{Password: "hunter2"}))
+
+	assert.True(t, record.Protected)
+
+	link := shrink.Must(shortener.store.GetLink(context.Background(), record.Id))
+
+	assert.NotEqual(t, "hunter2", link.PasswordHash, "the password must be stored as a hash")
+
+	_, err = shortener.Expand(context.Background(), localURL, record.Id)
+
+	assert.Equal(t, shrink.ErrProtected, err)
+
+	_, err = shortener.Unlock(context.Background(), localURL, record.Id, "wrong")
+
+	assert.Equal(t, shrink.ErrIncorrectPassword, err)
+
+	unlocked, err := shortener.Unlock(context.Background(), localURL, record.Id, "hunter2")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "http://example.org", unlocked.ExpandedUrl)
+	assert.Equal(t, int64(1), unlocked.Visits)
+
+	for i := 0; i < 2; i++ {
+		_, err = shortener.Unlock(context.Background(), localURL, record.Id, "wrong")
+
+		assert.Equal(t, shrink.ErrIncorrectPassword, err)
+	}
+
+	_, err = shortener.Unlock(context.Background(), localURL, record.Id, "hunter2")
+
+	assert.Equal(t, shrink.ErrTooManyAttempts, err, "attempts must be throttled even with the right password")
+
+	plain := shrink.Must(shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{}))
+
+	unlocked, err = shortener.Unlock(context.Background(), localURL, plain.Id, "anything")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "http://example.org", unlocked.ExpandedUrl)
+}
+
+func TestShortenerUnlockConcurrentAttempts(t *testing.T) {
+	shortener := newTestShortener()
+	shortener.MaxPasswordAttempts = 2
+
+	record := shrink.Must(shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{Password: "hunter2"}))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, err := shortener.Unlock(context.Background(), localURL, record.Id, "wrong")
+			errs <- err
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	counts := make(map[error]int)
+
+	for err := range errs {
+		counts[err]++
+	}
+
+	assert.Equal(t, map[error]int{shrink.ErrIncorrectPassword: 2, shrink.ErrTooManyAttempts: 8}, counts,
+		"concurrent attempts must not pass the limit while passwords are checked")
+}
+
+func TestShortenerShortenSchedule(t *testing.T) {
+	shortener := newTestShortener()
+	now := time.Now()
+
+	invalid := []shrink.LinkOptions{
+		{NotAfter: now.Add(-time.Second)},
+		{NotBefore: now.Add(2 * time.Hour), NotAfter: now.Add(time.Hour)},
+		{NotBefore: now.Add(2 * time.Hour), ExpiresAt: now.Add(time.Hour)},
+	}
+
+	for _, ops := range invalid {
+		_, err := shortener.Shorten(context.Background(), localURL, "http://example.org", ops)
+
+		assert.Equal(t, shrink.ErrInvalidSchedule, err)
+	}
+
+	ops := shrink.LinkOptions{NotBefore: now.Add(time.Hour), NotAfter: now.Add(2 * time.Hour), TTL: time.Hour}
+	record := shrink.Must(shortener.Shorten(context.Background(), localURL, "http://example.org", ops))
+
+	assert.Equal(t, ops.NotBefore, *record.NotBefore)
+	assert.Equal(t, ops.NotAfter, *record.NotAfter)
+	assert.WithinDuration(t, ops.NotBefore.Add(time.Hour), *record.ExpiresAt, time.Second, "the TTL must be counted from the start")
+
+	_, err := shortener.Expand(context.Background(), localURL, record.Id)
+
+	assert.Equal(t, shrink.ErrNotActive, err)
+
+	assert.Nil(t, shortener.store.CreateLink(context.Background(), "ended", shrink.Link{URL: "http://example.org", NotAfter: now}))
+
+	_, err = shortener.Expand(context.Background(), localURL, "ended")
+
+	assert.Equal(t, shrink.ErrExpired, err)
+}
+
+func TestShortenerUpdate(t *testing.T) {
+	shortener := newTestShortener()
+	shortener.Normalizers = shrink.DefaultNormalizers
+
+	record := shrink.Must(shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{}))
+
+	_, err := shortener.Update(context.Background(), localURL, record.Id, "not a url", shrink.UpdateOptions{})
+
+	assert.Equal(t, shrink.ErrInvalidURL, err)
+
+	_, err = shortener.Update(context.Background(), localURL, record.Id, "http://example.com/"+record.Id, shrink.UpdateOptions{})
+
+	assert.Equal(t, shrink.ErrSelfLink, err)
+
+	updated, err := shortener.Update(context.Background(), localURL, record.Id, "HTTP://Example.org/launch", shrink.UpdateOptions{Version: 1, Author: "alice"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "http://example.org/launch", updated.ExpandedUrl)
+	assert.Equal(t, int64(2), updated.Version)
+
+	_, err = shortener.Update(context.Background(), localURL, record.Id, "http://example.org/other", shrink.UpdateOptions{Version: 1})
+
+	assert.Equal(t, shrink.ErrVersionMismatch, err)
+
+	current, revisions, err := shortener.History(context.Background(), localURL, record.Id)
+
+	assert.Nil(t, err)
+	assert.Equal(t, updated, current)
+	assert.Len(t, revisions, 1)
+	assert.Equal(t, record.ExpandedUrl, revisions[0].URL)
+	assert.Equal(t, "alice", revisions[0].ChangedBy)
+}
+
+func TestShortenerDelete(t *testing.T) {
+	shortener := newTestShortener()
+
+	record := shrink.Must(shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{}))
+
+	assert.NotEmpty(t, record.Token)
+	assert.Equal(t, shrink.ErrInvalidToken, shortener.Delete(context.Background(), record.Id, ""))
+	assert.Equal(t, shrink.ErrInvalidToken, shortener.Delete(context.Background(), record.Id, "wrong"))
+	assert.Nil(t, shortener.Delete(context.Background(), record.Id, record.Token))
+	assert.Equal(t, shrink.ErrDeleted, shortener.Delete(context.Background(), record.Id, record.Token))
+	assert.Equal(t, shrink.ErrNil, shortener.Delete(context.Background(), "missing", record.Token))
+
+	shrink.Must(shortener.Store.AddLink(context.Background(), "unowned", "http://example.org"))
+
+	assert.Equal(t, shrink.ErrInvalidToken, shortener.Delete(context.Background(), "unowned", ""), "links without a token can only be force deleted")
+	assert.Nil(t, shortener.ForceDelete(context.Background(), "unowned"))
+	assert.Equal(t, shrink.ErrDeleted, shortener.ForceDelete(context.Background(), "unowned"))
+	assert.Equal(t, shrink.ErrNil, shortener.ForceDelete(context.Background(), "missing"))
+}
+
+func TestShortenerDeleteWithoutTrash(t *testing.T) {
+	shortener := newTestShortener()
+	shortener.Store = basicStore{shortener.store}
+
+	record := shrink.Must(shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{}))
+
+	assert.Nil(t, shortener.Delete(context.Background(), record.Id, record.Token))
+
+	_, err := shortener.Store.GetLink(context.Background(), record.Id)
+
+	assert.Equal(t, shrink.ErrNil, err, "links are deleted permanently if the store has no trash")
+
+	_, err = shortener.Trash(context.Background(), localURL)
+
+	assert.Equal(t, shrink.ErrTrashUnsupported, err)
+	assert.Equal(t, 0, shrink.Must(shortener.PurgeTrash(context.Background())))
+}
+
+func TestShortenerTrash(t *testing.T) {
+	shortener := newTestShortener()
+	shortener.TrashRetention = 50 * time.Millisecond
+
+	record := shrink.Must(shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{}))
+
+	_, err := shortener.Restore(context.Background(), localURL, record.Id, record.Token)
+
+	assert.Equal(t, shrink.ErrNil, err, "links not in the trash cannot be restored")
+	assert.Nil(t, shortener.Delete(context.Background(), record.Id, record.Token))
+
+	_, err = shortener.Expand(context.Background(), localURL, record.Id)
+
+	assert.Equal(t, shrink.ErrDeleted, err)
+
+	_, err = shortener.Unlock(context.Background(), localURL, record.Id, "")
+
+	assert.Equal(t, shrink.ErrDeleted, err)
+
+	trash := shrink.Must(shortener.Trash(context.Background(), localURL))
+
+	assert.Len(t, trash, 1)
+	assert.Equal(t, record.Id, trash[0].Id)
+	assert.Equal(t, record.ShortenedUrl, trash[0].ShortenedUrl)
+
+	_, err = shortener.Restore(context.Background(), localURL, record.Id, "wrong")
+
+	assert.Equal(t, shrink.ErrInvalidToken, err)
+
+	restored, err := shortener.Restore(context.Background(), localURL, record.Id, record.Token)
+
+	assert.Nil(t, err)
+	assert.Equal(t, record.ExpandedUrl, restored.ExpandedUrl)
+
+	assert.Nil(t, shortener.Delete(context.Background(), record.Id, record.Token))
+
+	time.Sleep(2 * shortener.TrashRetention)
+
+	assert.Empty(t, shrink.Must(shortener.Trash(context.Background(), localURL)), "links past their retention are not listed")
+
+	_, err = shortener.Restore(context.Background(), localURL, record.Id, record.Token)
+
+	assert.Equal(t, shrink.ErrNil, err, "links past their retention cannot be restored")
+	assert.Equal(t, 1, shrink.Must(shortener.PurgeTrash(context.Background())))
+
+	_, err = shortener.Store.GetLink(context.Background(), record.Id)
+
+	assert.Equal(t, shrink.ErrNil, err)
+}
+
+func TestShortenerList(t *testing.T) {
+	shortener := newTestShortener()
+
+	first := shrink.Must(shortener.Shorten(context.Background(), localURL, "http://example.org/a", shrink.LinkOptions{}))
+	second := shrink.Must(shortener.Shorten(context.Background(), localURL, "http://example.net/b", shrink.LinkOptions{}))
+
+	shortener.Expand(context.Background(), localURL, first.Id)
+
+	records, next, err := shortener.List(context.Background(), localURL, "", 0, shrink.LinkFilter{})
+
+	assert.Nil(t, err)
+	assert.Empty(t, next)
+	assert.Len(t, records, 2)
+
+	records, _, err = shortener.List(context.Background(), localURL, "", 0, shrink.LinkFilter{URL: "example.org"})
+
+	assert.Nil(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, first.Id, records[0].Id)
+	assert.Equal(t, first.ShortenedUrl, records[0].ShortenedUrl)
+	assert.Equal(t, int64(1), records[0].Visits)
+	assert.Empty(t, records[0].Token, "management tokens are never listed")
+
+	assert.Nil(t, shortener.Delete(context.Background(), second.Id, second.Token))
+
+	records, _, err = shortener.List(context.Background(), localURL, "", 1, shrink.LinkFilter{IncludeDeleted: true})
+
+	assert.Nil(t, err)
+	assert.Len(t, records, 1)
+
+	_, _, err = shortener.List(context.Background(), localURL, "", -1, shrink.LinkFilter{})
+
+	assert.Equal(t, shrink.ErrInvalidLimit, err)
+
+	_, _, err = shortener.List(context.Background(), localURL, "", 1001, shrink.LinkFilter{})
+
+	assert.Equal(t, shrink.ErrInvalidLimit, err)
+}
+
+func TestShortenerVisitorId(t *testing.T) {
+	shortener := shrink.NewShortener(shrink.ShortenerOptions{VisitorSalt: "salt"})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	id := shortener.VisitorId("203.0.113.7", "Firefox", now)
+
+	assert.Len(t, id, 32)
+	assert.NotContains(t, id, "203.0.113.7")
+	assert.Equal(t, id, shortener.VisitorId("203.0.113.7", "Firefox", now.Add(6*time.Hour)), "the ID is the same all day")
+	assert.NotEqual(t, id, shortener.VisitorId("203.0.113.7", "Firefox", now.Add(24*time.Hour)), "the ID changes every day")
+	assert.NotEqual(t, id, shortener.VisitorId("203.0.113.8", "Firefox", now))
+	assert.NotEqual(t, id, shortener.VisitorId("203.0.113.7", "Chrome", now))
+	assert.Equal(t, id, shrink.NewShortener(shrink.ShortenerOptions{VisitorSalt: "salt"}).VisitorId("203.0.113.7", "Firefox", now))
+	assert.NotEqual(t, id, shrink.NewShortener(shrink.ShortenerOptions{VisitorSalt: "other"}).VisitorId("203.0.113.7", "Firefox", now))
+	assert.NotEqual(t, id, shrink.NewShortener(shrink.ShortenerOptions{}).VisitorId("203.0.113.7", "Firefox", now), "a random salt is used by default")
+}
+
+func TestShortenerStats(t *testing.T) {
+	shortener := newTestShortener()
+
+	record := shrink.Must(shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{}))
+
+	shortener.Expand(context.Background(), localURL, record.Id)
+	shortener.Expand(context.Background(), localURL, record.Id)
+
+	now := time.Now()
+
+	stats, series, err := shortener.Stats(context.Background(), localURL, record.Id, shrink.Hourly, now.Add(-5*time.Hour), now)
+
+	assert.Nil(t, err)
+	assert.Equal(t, record.Id, stats.Id)
+	assert.Equal(t, int64(2), stats.Visits)
+	assert.Len(t, series, 6, "buckets without visits are included")
+	assert.Equal(t, now.Truncate(time.Hour).UTC(), series[5].Start)
+	assert.Equal(t, int64(2), series[5].Visits)
+	assert.Equal(t, int64(0), series[0].Visits)
+
+	_, series, err = shortener.Stats(context.Background(), localURL, record.Id, shrink.Daily, time.Time{}, time.Time{})
+
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, len(series), 28)
+	assert.Equal(t, int64(2), series[len(series)-1].Visits)
+
+	_, _, err = shortener.Stats(context.Background(), localURL, record.Id, "week", time.Time{}, time.Time{})
+
+	assert.Equal(t, shrink.ErrInvalidGranularity, err)
+
+	_, _, err = shortener.Stats(context.Background(), localURL, record.Id, shrink.Hourly, now, now.Add(-time.Hour))
+
+	assert.Equal(t, shrink.ErrInvalidStatsRange, err)
+
+	_, _, err = shortener.Stats(context.Background(), localURL, record.Id, shrink.Hourly, now.AddDate(-5, 0, 0), now)
+
+	assert.Equal(t, shrink.ErrInvalidStatsRange, err, "ranges with too many buckets are rejected")
+
+	_, _, err = shortener.Stats(context.Background(), localURL, "missing", shrink.Hourly, time.Time{}, time.Time{})
+
+	assert.Equal(t, shrink.ErrNil, err)
+}
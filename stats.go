@@ -0,0 +1,207 @@
+package shrinkmyurl
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStatsRetention = 90 * 24 * time.Hour
+
+	// Upper bound on the number of buckets in a time series, so that a range cannot be arbitrarily large.
+	maxStatsBuckets = 10000
+)
+
+// Width of the buckets that visits to a link are counted in.
+type Granularity string
+
+const (
+	Hourly Granularity = "hour"
+	Daily  Granularity = "day"
+)
+
+// The granularities that every visit is counted in.
+var granularities = []Granularity{Hourly, Daily}
+
+// Parse a granularity from its name: hour or day.
+func ParseGranularity(name string) (Granularity, error) {
+	switch g := Granularity(name); g {
+	case Hourly, Daily:
+		return g, nil
+	}
+
+	return "", ErrInvalidGranularity
+}
+
+// Get the width of the granularity's buckets.
+func (g Granularity) duration() time.Duration {
+	if g == Daily {
+		return 24 * time.Hour
+	}
+
+	return time.Hour
+}
+
+// Get the start of the bucket holding the given time. Days start at midnight UTC.
+func (g Granularity) bucket(t time.Time) time.Time {
+	return t.Truncate(g.duration()).UTC()
+}
+
+// The number of visits to a link in the bucket starting at the given time.
+type StatsBucket struct {
+	Start  time.Time `json:"start"`
+	Visits int64     `json:"visits"`
+}
+
+// Get how long visit statistics are kept, using the default for a zero retention.
+func statsRetention(retention time.Duration) time.Duration {
+	if retention <= 0 {
+		return defaultStatsRetention
+	}
+
+	return retention
+}
+
+// Get the field for the bucket of the given granularity starting at the given time, as stored by the Redis and
+// memory stores, such as "hour:1700000000" for the hour starting at that Unix time.
+func statsField(g Granularity, start time.Time) string {
+	return fmt.Sprintf("%s:%d", g, start.Unix())
+}
+
+// Parse a field of the Redis and memory stores into its granularity and bucket start.
+func parseStatsField(field string) (Granularity, time.Time, bool) {
+	name, unix, ok := strings.Cut(field, ":")
+
+	if !ok {
+		return "", time.Time{}, false
+	}
+
+	sec, err := strconv.ParseInt(unix, 10, 64)
+
+	if err != nil {
+		return "", time.Time{}, false
+	}
+
+	return Granularity(name), time.Unix(sec, 0).UTC(), true
+}
+
+// Get the buckets of the given granularity starting within [from, to) from the fields of the Redis and
+// memory stores, ordered by start.
+func collectStats(fields map[string]int64, g Granularity, from, to time.Time) []StatsBucket {
+	buckets := make([]StatsBucket, 0)
+
+	for field, visits := range fields {
+		name, start, ok := parseStatsField(field)
+
+		if ok && name == g && !start.Before(from) && start.Before(to) {
+			buckets = append(buckets, StatsBucket{Start: start, Visits: visits})
+		}
+	}
+
+	slices.SortFunc(buckets, func(a, b StatsBucket) int {
+		return a.Start.Compare(b.Start)
+	})
+
+	return buckets
+}
+
+// Count a visit at the given time in the fields of the Redis and memory stores, dropping the buckets that
+// started before the retention whenever a new one starts.
+func countVisit(fields map[string]int64, at time.Time, retention time.Duration) {
+	var started bool
+
+	for _, g := range granularities {
+		field := statsField(g, g.bucket(at))
+		fields[field]++
+		started = started || fields[field] == 1
+	}
+
+	if !started {
+		return
+	}
+
+	cutoff := at.Add(-retention)
+
+	for field := range fields {
+		if _, start, ok := parseStatsField(field); ok && start.Before(cutoff) {
+			delete(fields, field)
+		}
+	}
+}
+
+// Get the visits to the shortened URL by ID as a time series of the given granularity, with a bucket for every
+// hour or day from the one holding from up to to, including those without visits. A zero to is now, and a zero
+// from is a day or a month before to, for hourly and daily buckets. Visits older than the store's retention
+// are not counted.
+func (s *Shortener) Stats(ctx context.Context, host url.URL, id string, g Granularity, from, to time.Time) (Record, []StatsBucket, error) {
+	if _, err := ParseGranularity(string(g)); err != nil {
+		return Record{}, nil, err
+	}
+
+	if to.IsZero() {
+		to = time.Now()
+	}
+
+	if from.IsZero() {
+		from = to.Add(-24 * g.duration())
+
+		if g == Daily {
+			from = to.AddDate(0, -1, 0)
+		}
+	}
+
+	from = g.bucket(from)
+
+	if !from.Before(to) || to.Sub(from) > maxStatsBuckets*g.duration() {
+		return Record{}, nil, ErrInvalidStatsRange
+	}
+
+	link, err := s.Store.GetLink(ctx, id)
+
+	if err != nil {
+		return Record{}, nil, err
+	}
+
+	stats, err := s.stats()
+
+	if err != nil {
+		return Record{}, nil, err
+	}
+
+	counted, err := stats.LinkStats(ctx, id, g, from, to)
+
+	if err != nil {
+		return Record{}, nil, err
+	}
+
+	series := make([]StatsBucket, 0, to.Sub(from)/g.duration()+1)
+
+	for start := from; start.Before(to); start = start.Add(g.duration()) {
+		bucket := StatsBucket{Start: start}
+
+		if len(counted) > 0 && counted[0].Start.Equal(start) {
+			bucket.Visits = counted[0].Visits
+			counted = counted[1:]
+		}
+
+		series = append(series, bucket)
+	}
+
+	return linkRecord(host, id, link), series, nil
+}
+
+// Get the store's stats. Returns ErrStatsUnsupported if it keeps none.
+func (s *Shortener) stats() (StatsRecorder, error) {
+	stats, ok := s.Store.(StatsRecorder)
+
+	if !ok {
+		return nil, ErrStatsUnsupported
+	}
+
+	return stats, nil
+}
@@ -2,7 +2,11 @@ package shrinkmyurl
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -13,69 +17,745 @@ type Store interface {
 	Close() error
 	Ping(ctx context.Context) error
 	AddLink(ctx context.Context, id, url string) (bool, error)
+	CreateLink(ctx context.Context, id string, link Link) error
 	ExpandLink(ctx context.Context, id string) (string, int64, error)
+	ExpandProtectedLink(ctx context.Context, id, passwordHash string) (string, int64, error)
+	GetLink(ctx context.Context, id string) (Link, error)
+	ListLinks(ctx context.Context, cursor string, limit int, filter LinkFilter) ([]ListedLink, string, error)
+	UpdateLink(ctx context.Context, id string, update LinkUpdate) (Link, error)
+	LinkHistory(ctx context.Context, id string) ([]Revision, error)
 	DeleteLink(ctx context.Context, id string) error
 }
 
-// A simple in-memory store implementation.
-type MemoryStore struct {
-	links  map[string]string
-	visits map[string]int64
+// A link held by a store, with its settings.
+type Link struct {
+	URL    string
+	Visits int64
+	// Estimated number of unique visitors counted by RecordVisit, each once a day. Set by stores.
+	UniqueVisitors int64
+	// Lifetime of the link, refreshed on every visit unless Absolute. Zero uses the store's expiration.
+	TTL time.Duration
+	// Expire the link a TTL after it was created, rather than a TTL after its last visit.
+	Absolute bool
+	// Fixed time at which the link expires, instead of a TTL. When read from a store, the current expiration.
+	ExpiresAt time.Time
+	// Number of visits after which the link is gone. Zero is unlimited.
+	MaxVisits int64
+	// Hash of the password protecting the link, empty if the link is not protected.
+	PasswordHash string
+	// Time from which the link redirects. Zero is immediately.
+	NotBefore time.Time
+	// Time from which the link stops redirecting. Zero is never.
+	NotAfter time.Time
+	// Version of the destination, starting at 1 and incremented by every change. Set by stores.
+	Version int64
+	// Hash of the management token that allows deleting the link, empty if the link has no owner.
+	TokenHash string
+	// Time at which the link was moved to the trash. Zero if it is not in the trash. Set by stores.
+	DeletedAt time.Time
 }
 
-// Create a new memory store.
-func NewMemoryStore() *MemoryStore {
-	return &MemoryStore{
-		links:  make(map[string]string),
-		visits: make(map[string]int64),
+// A link listed by a store, with its ID.
+type ListedLink struct {
+	Id string
+	Link
+}
+
+// Criteria for the links listed by a store.
+type LinkFilter struct {
+	// Only list links whose URL contains this text, compared case-insensitively. Empty lists all links.
+	URL string
+	// List links in the trash as well.
+	IncludeDeleted bool
+}
+
+// Report whether the filter matches the link.
+func (f LinkFilter) match(link Link) bool {
+	if !f.IncludeDeleted && !link.DeletedAt.IsZero() {
+		return false
 	}
+
+	return f.URL == "" || strings.Contains(strings.ToLower(link.URL), strings.ToLower(f.URL))
 }
 
-// Close the memory store.
-func (s *MemoryStore) Close() error {
-	return nil
+// A link in the trash, which no longer redirects but can be restored until it is purged.
+type TrashedLink struct {
+	Id        string
+	URL       string
+	DeletedAt time.Time
 }
 
-// Ping the memory store.
-func (s *MemoryStore) Ping(ctx context.Context) error {
-	return nil
+// A change to the destination of a link.
+type LinkUpdate struct {
+	URL string
+	// Version the link must be at for the change to apply, or zero to apply it regardless.
+	Version int64
+	// Who made the change, kept in the revision history.
+	Author string
 }
 
-// Add a link to the memory store.
-func (s *MemoryStore) AddLink(ctx context.Context, id, url string) (bool, error) {
-	if _, ok := s.links[id]; ok {
-		return false, ErrExists
+// A past destination of a link, replaced by a change.
+type Revision struct {
+	// Version of the link that had this destination.
+	Version   int64     `json:"version"`
+	URL       string    `json:"url"`
+	ChangedBy string    `json:"changed_by"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// Report whether the link has its own expiration rather than the store's.
+func (l Link) customExpiration() bool {
+	return l.TTL > 0 || l.Absolute || !l.ExpiresAt.IsZero()
+}
+
+// Get the TTL refreshed on every visit and the expiration time of a link created at the given time, using
+// the store's expiration unless the link has its own. A zero TTL means the expiration is fixed.
+// A TTL is counted from when the link becomes active, so that scheduled links do not expire before then.
+func (l Link) expiration(now time.Time, fallback time.Duration) (time.Duration, time.Time) {
+	if l.NotBefore.After(now) {
+		now = l.NotBefore
 	}
 
-	s.links[id] = url
-	s.visits[id] = 0
+	switch {
+	case !l.ExpiresAt.IsZero():
+		return 0, l.ExpiresAt
+	case l.TTL > 0 && l.Absolute:
+		return 0, now.Add(l.TTL)
+	case l.TTL > 0:
+		return l.TTL, now.Add(l.TTL)
+	case l.Absolute && fallback > 0:
+		return 0, now.Add(fallback)
+	case fallback > 0:
+		return fallback, now.Add(fallback)
+	}
 
-	return true, nil
+	return 0, time.Time{}
 }
 
-// Expand a shortened link from the memory store with the number of visits, incrementing the visit count.
-func (s *MemoryStore) ExpandLink(ctx context.Context, id string) (string, int64, error) {
-	if url, ok := s.links[id]; ok {
-		s.visits[id]++
-		return url, s.visits[id], nil
-	}
+// A store that indexes links by their URL, so that identical URLs can share a single link.
+type Deduplicator interface {
+	// Atomically add a link unless the URL already has one, returning the ID and visits of the link
+	// holding the URL. Returns ErrExists if the ID is taken by a different URL.
+	AddOrGetLink(ctx context.Context, id, url string) (string, int64, error)
+}
 
-	return "", 0, ErrNil
+// A store that keeps the visits to each link in buckets of time, and the details of the visits in breakdowns
+// and unique visitors.
+type StatsRecorder interface {
+	// Get the visits to a link in buckets of the given granularity that start in the given range.
+	LinkStats(ctx context.Context, id string, granularity Granularity, from, to time.Time) ([]StatsBucket, error)
+	// Count the details of a visit to a link. Visits to links that do not exist are ignored.
+	RecordVisit(ctx context.Context, id string, visit Visit) error
+	// Get the most common values of every dimension of the visits to a link, up to top of each.
+	LinkBreakdowns(ctx context.Context, id string, top int) (map[Dimension][]BreakdownEntry, error)
 }
 
-// Delete a link and its visit count from the memory store.
-func (s *MemoryStore) DeleteLink(ctx context.Context, id string) error {
-	delete(s.links, id)
-	delete(s.visits, id)
+// A store that moves deleted links to a trash, from which they can be restored until they are purged.
+type Trasher interface {
+	// Move a link to the trash. Returns ErrDeleted if it is already there.
+	TrashLink(ctx context.Context, id string) error
+	// Take a link out of the trash. Returns ErrNil if it is not there.
+	RestoreLink(ctx context.Context, id string) error
+	// Get the links in the trash, oldest first.
+	ListTrash(ctx context.Context) ([]TrashedLink, error)
+	// Permanently delete the links moved to the trash at or before the given time, returning how many were
+	// deleted.
+	PurgeTrash(ctx context.Context, before time.Time) (int, error)
+}
 
-	return nil
+// A store that can count a visit to a link without expanding it, which lets CachedStore serve links from its
+// cache while their visits are still counted.
+type VisitCounter interface {
+	// Count a visit to a link, its stats and the expiration it refreshes, without checking whether it can be
+	// expanded. Visits to links that do not exist are ignored.
+	CountVisit(ctx context.Context, id string) error
+}
+
+// A store that keeps expired links until they are purged, rather than expiring them by itself.
+type ExpiredPurger interface {
+	// Permanently delete the links that expired at or before the given time with everything recorded about
+	// them, returning how many were deleted.
+	PurgeExpired(ctx context.Context, before time.Time) (int, error)
+}
+
+// A store that serves links from a cache, and reports how often it does.
+type CacheReporter interface {
+	// Get the number of expansions served from the cache and read from the store so far.
+	CacheStats() CacheStats
 }
 
+// A store that counts visits in the background, and reports what became of them.
+type VisitQueueReporter interface {
+	// Get the number of visits counted in the background that were queued, flushed and dropped so far.
+	VisitQueueStats() VisitQueueStats
+}
+
+// The key holding the sequence used to generate IDs.
+// Aliases cannot contain colons, so it cannot collide with a link.
+const sequenceKey = "shrink:sequence"
+
+// The fewest keys examined for a page of links, so that small pages filtered by URL still make progress.
+const minListScanKeys = 1000
+
+// The scripts below access the visits, settings, history, stats, breakdowns, visitors and URL index keys of other
+// links, which are derived from the link ID as "<id>:visits", "<id>:meta", "<id>:history", "<id>:stats",
+// "<id>:breakdowns" and "<id>:visitors", and from the URL as "shrink:url:<sha1 of url>",
+// as well as the "shrink:trash" sorted set of the IDs of links in the trash, scored by when they were deleted.
+// Aliases cannot contain colons, so these keys cannot collide with a link.
+//
+// The settings hash exists for links with their own settings or a management token, and for links that were
+// changed or moved to the trash, and expires along with the link. Its "ttl" field, if set, holds the TTL in
+// milliseconds refreshed on every visit, where 0 means the expiration is fixed, and otherwise visits refresh
+// the store's expiration, so that a hash holding only other fields does not change it. "max_visits" holds
+// the number of visits after which the link is gone, "password" holds the password hash, and "not_before"
+// and "not_after" hold the activation window in Unix milliseconds, and "token" holds the management token hash.
+// Its "version" field holds the version of the destination once it has been changed, and the history list
+// holds a JSON revision for every change. Its "deleted_at" field holds when the link was moved to the trash
+// in Unix milliseconds. The stats hash holds the visits in every hour and day, in fields such as "hour:<Unix time>"
+// for the bucket starting at that time, of which those older than the retention are dropped whenever a new one
+// starts. The breakdowns hash holds the visits with every value of every dimension, in fields such as
+// "browser:Firefox", and the number of values of every dimension, in fields such as "values:browser", and the
+// visitors key holds a HyperLogLog of the visitors.
+
+// Atomically add a link, its visit count and settings, unless the link already exists.
+// KEYS: link, visits, meta, history, stats, breakdowns, visitors. ARGV: url, expiration in milliseconds (0 for none), TTL in milliseconds
+// ("" for the store's expiration), max visits (0 for unlimited), password hash ("" for none),
+// not before and not after in Unix milliseconds ("" for none), management token hash ("" for none).
+var addLinkScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+
+redis.call("DEL", KEYS[3], KEYS[4], KEYS[5], KEYS[6], KEYS[7])
+
+local ttl = tonumber(ARGV[2])
+
+if ttl > 0 then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ttl)
+	redis.call("SET", KEYS[2], 0, "PX", ttl)
+else
+	redis.call("SET", KEYS[1], ARGV[1])
+	redis.call("SET", KEYS[2], 0)
+end
+
+local fields = {}
+
+if ARGV[3] ~= "" then
+	table.insert(fields, "ttl")
+	table.insert(fields, ARGV[3])
+end
+
+if tonumber(ARGV[4]) > 0 then
+	table.insert(fields, "max_visits")
+	table.insert(fields, ARGV[4])
+end
+
+if ARGV[5] ~= "" then
+	table.insert(fields, "password")
+	table.insert(fields, ARGV[5])
+end
+
+if ARGV[6] ~= "" then
+	table.insert(fields, "not_before")
+	table.insert(fields, ARGV[6])
+end
+
+if ARGV[7] ~= "" then
+	table.insert(fields, "not_after")
+	table.insert(fields, ARGV[7])
+end
+
+if ARGV[8] ~= "" then
+	table.insert(fields, "token")
+	table.insert(fields, ARGV[8])
+end
+
+if #fields > 0 then
+	redis.call("HSET", KEYS[3], unpack(fields))
+
+	if ttl > 0 then
+		redis.call("PEXPIRE", KEYS[3], ttl)
+	end
+end
+
+return 1
+`)
+
+// Atomically expand a link, incrementing its visit count and stats and refreshing the expiration of its keys.
+// Returns the URL, the visits and 1 if the visit was counted. Unless told to count every visit, only visits to
+// links with max visits are counted, and other links are only read, returning 0 as the third element.
+// Missing links are left untouched and return nil. Links in the trash, outside their activation window, whose
+// password hash does not match the given one, or that reached their max visits, are left untouched and return
+// a third element with the reason.
+// KEYS: link, visits, meta, history, stats, breakdowns, visitors. ARGV: expiration in milliseconds (0 for none), password hash ("" for none),
+// current time in Unix milliseconds, hour and day stats fields, retention cutoff in Unix seconds, whether to
+// count every visit ("1" or "0").
+var expandLinkScript = redis.NewScript(`
+local url = redis.call("GET", KEYS[1])
+
+if not url then
+	return false
+end
+
+local meta = redis.call("HMGET", KEYS[3], "ttl", "max_visits", "password", "not_before", "not_after", "deleted_at")
+local now = tonumber(ARGV[3])
+
+if meta[6] then
+	return {"", 0, "deleted"}
+end
+
+if meta[4] and now < tonumber(meta[4]) then
+	return {"", 0, "inactive"}
+end
+
+if meta[5] and now >= tonumber(meta[5]) then
+	return {"", 0, "expired"}
+end
+
+if (meta[3] or "") ~= ARGV[2] then
+	return {"", 0, "protected"}
+end
+
+local max = tonumber(meta[2] or "0")
+local visits = tonumber(redis.call("GET", KEYS[2]) or "0")
+
+if max > 0 and visits >= max then
+	return {"", 0, "gone"}
+end
+
+if ARGV[7] ~= "1" and max == 0 then
+	return {url, visits, 0}
+end
+
+visits = redis.call("INCR", KEYS[2])
+local started = false
+
+for _, field in ipairs({ARGV[4], ARGV[5]}) do
+	if redis.call("HINCRBY", KEYS[5], field, 1) == 1 then
+		started = true
+	end
+end
+
+if started then
+	local cutoff = tonumber(ARGV[6])
+
+	for _, field in ipairs(redis.call("HKEYS", KEYS[5])) do
+		if tonumber(string.match(field, ":(%d+)$") or "0") < cutoff then
+			redis.call("HDEL", KEYS[5], field)
+		end
+	end
+
+	local pttl = redis.call("PTTL", KEYS[1])
+
+	if pttl > 0 then
+		redis.call("PEXPIRE", KEYS[5], pttl)
+	end
+end
+
+local ttl = tonumber(meta[1] or ARGV[1])
+
+if meta[1] and ttl == 0 then
+	return {url, visits, 1}
+end
+
+local index = "shrink:url:" .. redis.sha1hex(url)
+local keys = {KEYS[1], KEYS[2], KEYS[3], KEYS[4], KEYS[5], KEYS[6], KEYS[7]}
+
+if redis.call("GET", index) == KEYS[1] then
+	table.insert(keys, index)
+end
+
+for _, key in ipairs(keys) do
+	if ttl > 0 then
+		redis.call("PEXPIRE", key, ttl)
+	else
+		redis.call("PERSIST", key)
+	end
+end
+
+return {url, visits, 1}
+`)
+
+// Atomically count visits to a link made since the last batch, incrementing its visit count and stats and
+// refreshing the expiration of its keys, like expanding it, unless the link was moved to the trash since. Returns
+// 0 if the link no longer exists, in which case its visits are left uncounted.
+// KEYS: link, visits, meta, history, stats, breakdowns, visitors. ARGV: visits, expiration in milliseconds
+// (0 for none), retention cutoff in Unix seconds, followed by pairs of stats fields and their visits.
+var countVisitsScript = redis.NewScript(`
+local url = redis.call("GET", KEYS[1])
+
+if not url then
+	return 0
+end
+
+redis.call("INCRBY", KEYS[2], ARGV[1])
+
+local started = false
+
+for i = 4, #ARGV, 2 do
+	if redis.call("HINCRBY", KEYS[5], ARGV[i], ARGV[i + 1]) == tonumber(ARGV[i + 1]) then
+		started = true
+	end
+end
+
+if started then
+	local cutoff = tonumber(ARGV[3])
+
+	for _, field in ipairs(redis.call("HKEYS", KEYS[5])) do
+		if tonumber(string.match(field, ":(%d+)$") or "0") < cutoff then
+			redis.call("HDEL", KEYS[5], field)
+		end
+	end
+
+	local pttl = redis.call("PTTL", KEYS[1])
+
+	if pttl > 0 then
+		redis.call("PEXPIRE", KEYS[5], pttl)
+	end
+end
+
+local meta = redis.call("HMGET", KEYS[3], "ttl", "deleted_at")
+
+if meta[2] or (meta[1] and tonumber(meta[1]) == 0) then
+	return 1
+end
+
+local ttl = tonumber(meta[1] or ARGV[2])
+
+local index = "shrink:url:" .. redis.sha1hex(url)
+local keys = {KEYS[1], KEYS[2], KEYS[3], KEYS[4], KEYS[5], KEYS[6], KEYS[7]}
+
+if redis.call("GET", index) == KEYS[1] then
+	table.insert(keys, index)
+end
+
+for _, key in ipairs(keys) do
+	if ttl > 0 then
+		redis.call("PEXPIRE", key, ttl)
+	else
+		redis.call("PERSIST", key)
+	end
+end
+
+return 1
+`)
+
+// Atomically return the link already holding a URL, or add a link, its visit count and URL index.
+// Returns nil if the ID is taken by a different URL.
+// KEYS: link, visits, meta, history, stats, breakdowns, visitors. ARGV: id, url, expiration in milliseconds (0 for none).
+var addOrGetLinkScript = redis.NewScript(`
+local index = "shrink:url:" .. redis.sha1hex(ARGV[2])
+local existing = redis.call("GET", index)
+
+if existing and redis.call("GET", existing) == ARGV[2] then
+	local visits = redis.call("GET", existing .. ":visits")
+	return {existing, tonumber(visits or "0")}
+end
+
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return false
+end
+
+redis.call("DEL", KEYS[3], KEYS[4], KEYS[5], KEYS[6], KEYS[7])
+
+local ttl = tonumber(ARGV[3])
+
+if ttl > 0 then
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ttl)
+	redis.call("SET", KEYS[2], 0, "PX", ttl)
+	redis.call("SET", index, ARGV[1], "PX", ttl)
+else
+	redis.call("SET", KEYS[1], ARGV[2])
+	redis.call("SET", KEYS[2], 0)
+	redis.call("SET", index, ARGV[1])
+end
+
+return {ARGV[1], 0}
+`)
+
+// Atomically get a link with its visits, remaining time to live in milliseconds, settings and estimated unique
+// visitors.
+// Returns nil if the link does not exist.
+// KEYS: link, visits, meta, history, stats, breakdowns, visitors.
+var getLinkScript = redis.NewScript(`
+local url = redis.call("GET", KEYS[1])
+
+if not url then
+	return false
+end
+
+local visits = tonumber(redis.call("GET", KEYS[2]) or "0")
+local meta = redis.call(
+	"HMGET", KEYS[3], "ttl", "max_visits", "password", "not_before", "not_after", "version", "token", "deleted_at"
+)
+local pttl = redis.call("PTTL", KEYS[1])
+
+return {
+	url, visits, pttl, meta[1] or "", meta[2] or "", meta[3] or "", meta[4] or "", meta[5] or "", meta[6] or "1",
+	meta[7] or "", meta[8] or "", redis.call("PFCOUNT", KEYS[7]),
+}
+`)
+
+// Atomically change the destination of a link, appending the previous one to its history and removing
+// the URL index pointing to the link. The settings and history keys expire with the link.
+// Returns nil if the link does not exist, or a third element with the reason if it is in the trash or the version
+// does not match.
+// KEYS: link, visits, meta, history, stats, breakdowns, visitors. ARGV: url, expected version (0 for any), author, current time in
+// Unix milliseconds.
+var updateLinkScript = redis.NewScript(`
+local url = redis.call("GET", KEYS[1])
+
+if not url then
+	return false
+end
+
+local meta = redis.call("HMGET", KEYS[3], "version", "deleted_at")
+
+if meta[2] then
+	return {0, 0, "deleted"}
+end
+
+local version = tonumber(meta[1] or "1")
+local expected = tonumber(ARGV[2])
+
+if expected > 0 and expected ~= version then
+	return {0, 0, "mismatch"}
+end
+
+local revision = {version = version, url = url, changed_by = ARGV[3], changed_at = tonumber(ARGV[4])}
+
+redis.call("SET", KEYS[1], ARGV[1], "KEEPTTL")
+redis.call("HSET", KEYS[3], "version", version + 1)
+redis.call("RPUSH", KEYS[4], cjson.encode(revision))
+
+local pttl = redis.call("PTTL", KEYS[1])
+
+if pttl > 0 then
+	redis.call("PEXPIRE", KEYS[3], pttl)
+	redis.call("PEXPIRE", KEYS[4], pttl)
+end
+
+local index = "shrink:url:" .. redis.sha1hex(url)
+
+if redis.call("GET", index) == KEYS[1] then
+	redis.call("DEL", index)
+end
+
+return {version + 1, tonumber(redis.call("GET", KEYS[2]) or "0")}
+`)
+
+// Atomically get the history of a link, oldest first. Returns nil if the link does not exist.
+// KEYS: link, visits, meta, history, stats, breakdowns, visitors.
+var linkHistoryScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return false
+end
+
+return redis.call("LRANGE", KEYS[4], 0, -1)
+`)
+
+// Atomically move a link to the trash, removing the URL index pointing to the link. Links in the trash do not
+// expire until they are restored, so that they can be restored until they are purged, and their expiration is
+// kept in the "trashed_expiry" field of the settings hash in Unix milliseconds. Returns nil if the link does not
+// exist, or 0 if it is already in the trash.
+// KEYS: link, visits, meta, history, stats, breakdowns, visitors. ARGV: current time in Unix milliseconds.
+var trashLinkScript = redis.NewScript(`
+local url = redis.call("GET", KEYS[1])
+
+if not url then
+	return false
+end
+
+if redis.call("HEXISTS", KEYS[3], "deleted_at") == 1 then
+	return 0
+end
+
+local pttl = redis.call("PTTL", KEYS[1])
+
+redis.call("HSET", KEYS[3], "deleted_at", ARGV[1])
+redis.call("ZADD", "shrink:trash", ARGV[1], KEYS[1])
+
+if pttl > 0 then
+	redis.call("HSET", KEYS[3], "trashed_expiry", tonumber(ARGV[1]) + pttl)
+end
+
+for _, key in ipairs(KEYS) do
+	redis.call("PERSIST", key)
+end
+
+local index = "shrink:url:" .. redis.sha1hex(url)
+
+if redis.call("GET", index) == KEYS[1] then
+	redis.call("DEL", index)
+end
+
+return 1
+`)
+
+// Atomically count the details of visits in the breakdowns and visitors of a link, which expire with the link.
+// New values of a dimension that holds the most values it can are counted as the other value instead.
+// Returns 0 if the link does not exist.
+// KEYS: link, visits, meta, history, stats, breakdowns, visitors. ARGV: most values per dimension, other value,
+// number of visitors, the visitors, followed by pairs of breakdown fields and their visits.
+var recordVisitsScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+
+local max = tonumber(ARGV[1])
+local visitors = tonumber(ARGV[3])
+
+for i = 4 + visitors, #ARGV, 2 do
+	local field = ARGV[i]
+	local visits = tonumber(ARGV[i + 1])
+	local name = string.match(field, "^[^:]*")
+	local values = "values:" .. name
+
+	if redis.call("HEXISTS", KEYS[6], field) == 0 and tonumber(redis.call("HGET", KEYS[6], values) or "0") >= max then
+		field = name .. ":" .. ARGV[2]
+	end
+
+	if redis.call("HINCRBY", KEYS[6], field, visits) == visits then
+		redis.call("HINCRBY", KEYS[6], values, 1)
+	end
+end
+
+local keys = {KEYS[6]}
+
+if visitors > 0 then
+	-- Add the visitors in chunks, since unpacking too many arguments at once overflows the Lua stack.
+	for i = 4, 3 + visitors, 100 do
+		redis.call("PFADD", KEYS[7], unpack(ARGV, i, math.min(i + 99, 3 + visitors)))
+	end
+
+	table.insert(keys, KEYS[7])
+end
+
+local pttl = redis.call("PTTL", KEYS[1])
+
+for _, key in ipairs(keys) do
+	if pttl > 0 then
+		redis.call("PEXPIRE", key, pttl)
+	else
+		redis.call("PERSIST", key)
+	end
+end
+
+return 1
+`)
+
+// Atomically take a link out of the trash, putting back its expiration. Links with a sliding expiration expire
+// a TTL after they are restored, while links with a fixed expiration expire when they would have, or right away
+// if that passed while they were in the trash. Returns 0 if the link does not exist or is not in the trash.
+// KEYS: link, visits, meta, history, stats, breakdowns, visitors. ARGV: expiration in milliseconds (0 for none).
+var restoreLinkScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+
+local meta = redis.call("HMGET", KEYS[3], "deleted_at", "ttl", "trashed_expiry")
+
+if not meta[1] then
+	return 0
+end
+
+redis.call("HDEL", KEYS[3], "deleted_at", "trashed_expiry")
+redis.call("ZREM", "shrink:trash", KEYS[1])
+
+local ttl = tonumber(meta[2] or ARGV[1])
+
+for _, key in ipairs(KEYS) do
+	if meta[2] and ttl == 0 then
+		if meta[3] then
+			redis.call("PEXPIREAT", key, meta[3])
+		end
+	elseif ttl > 0 then
+		redis.call("PEXPIRE", key, ttl)
+	end
+end
+
+return 1
+`)
+
+// Atomically get the ID, URL and deletion time of the links in the trash, oldest first, forgetting links that
+// expired or were replaced since they were deleted.
+var listTrashScript = redis.NewScript(`
+local links = {}
+
+for _, id in ipairs(redis.call("ZRANGE", "shrink:trash", 0, -1)) do
+	local url = redis.call("GET", id)
+	local deleted = redis.call("HGET", id .. ":meta", "deleted_at")
+
+	if url and deleted then
+		table.insert(links, {id, url, deleted})
+	else
+		redis.call("ZREM", "shrink:trash", id)
+	end
+end
+
+return links
+`)
+
+// Atomically delete the links moved to the trash at or before the given time with their visit counts, settings,
+// history, stats, breakdowns and visitors. Returns the number of links deleted.
+// ARGV: time in Unix milliseconds.
+var purgeTrashScript = redis.NewScript(`
+local before = tonumber(ARGV[1])
+local purged = 0
+
+for _, id in ipairs(redis.call("ZRANGEBYSCORE", "shrink:trash", "-inf", before)) do
+	local deleted = redis.call("HGET", id .. ":meta", "deleted_at")
+
+	if deleted and tonumber(deleted) <= before and redis.call("EXISTS", id) == 1 then
+		redis.call(
+			"DEL", id, id .. ":visits", id .. ":meta", id .. ":history", id .. ":stats", id .. ":breakdowns",
+			id .. ":visitors"
+		)
+		purged = purged + 1
+	end
+
+	redis.call("ZREM", "shrink:trash", id)
+end
+
+return purged
+`)
+
+// Atomically delete a link, its visit count, settings, history, stats, breakdowns, visitors and URL index, if the
+// index points to the link, and take it out of the trash.
+// KEYS: link, visits, meta, history, stats, breakdowns, visitors.
+var deleteLinkScript = redis.NewScript(`
+local url = redis.call("GET", KEYS[1])
+
+redis.call("DEL", KEYS[1], KEYS[2], KEYS[3], KEYS[4], KEYS[5], KEYS[6], KEYS[7])
+redis.call("ZREM", "shrink:trash", KEYS[1])
+
+if url then
+	local index = "shrink:url:" .. redis.sha1hex(url)
+
+	if redis.call("GET", index) == KEYS[1] then
+		redis.call("DEL", index)
+	end
+end
+
+return 1
+`)
+
 // Options for the Redis store.
 type RedisStoreOptions struct {
 	redis.Options
 
 	Expiration time.Duration
+	// How long visit statistics are kept. Defaults to 90 days.
+	StatsRetention time.Duration
+	// Count visits and their details in the background, in batches, so that expanding a link and recording its
+	// visit only read from Redis. Visits beyond the buffer are dropped, and visits not yet flushed are left out
+	// of visit counts, stats and breakdowns. Visits to links with max visits are still counted while expanding,
+	// so that the limit holds.
+	AsyncVisits bool
+	// Number of visits buffered for counting in the background. Defaults to 10000.
+	VisitBufferSize int
+	// How often visits counted in the background are flushed. Defaults to one second.
+	VisitFlushInterval time.Duration
 }
 
 // A Store implementation that uses Redis.
@@ -83,13 +763,19 @@ type RedisStore struct {
 	RedisStoreOptions
 
 	client *redis.Client
+	visits *visitQueue
 }
 
-// Create a new Redis store with the given options.
+// Create a new Redis store with the given options, counting visits in the background if AsyncVisits is set.
 func NewRedisStore(ops RedisStoreOptions) (*RedisStore, error) {
 	client := redis.NewClient(&ops.Options)
+	s := &RedisStore{RedisStoreOptions: ops, client: client}
 
-	return &RedisStore{RedisStoreOptions: ops, client: client}, nil
+	if ops.AsyncVisits {
+		s.visits = newVisitQueue(ops.VisitBufferSize, ops.VisitFlushInterval, s.flushVisits)
+	}
+
+	return s, nil
 }
 
 // Ping the Redis store.
@@ -97,75 +783,656 @@ func (s *RedisStore) Ping(ctx context.Context) error {
 	return s.client.Ping(ctx).Err()
 }
 
-// Close the Redis store.
+// Close the Redis store, flushing the visits waiting to be counted in the background.
 func (s *RedisStore) Close() error {
+	if s.visits != nil {
+		s.visits.Close()
+	}
+
 	return s.client.Close()
 }
 
 // Add a link to the store with the configured expiration.
 // Returns true if the link was successfully added, or false if it was not.
 func (s *RedisStore) AddLink(ctx context.Context, id, url string) (bool, error) {
-	cmds, err := s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
-		p.SetNX(ctx, id, url, s.Expiration)
-		p.SetNX(ctx, visitId(id), 0, s.Expiration)
+	if err := s.CreateLink(ctx, id, Link{URL: url}); err != nil {
+		return false, err
+	}
 
-		return nil
-	})
+	return true, nil
+}
+
+// Add a link to the store with its own settings. Returns ErrExists if the ID is taken.
+func (s *RedisStore) CreateLink(ctx context.Context, id string, link Link) error {
+	now := time.Now()
+	ttl, expiresAt := link.expiration(now, s.Expiration)
+
+	var expiry int64
+
+	if !expiresAt.IsZero() {
+		expiry = max(1, expiresAt.Sub(now).Milliseconds())
+	}
+
+	var customTTL string
+
+	if link.customExpiration() {
+		customTTL = strconv.FormatInt(ttl.Milliseconds(), 10)
+	}
+
+	args := []any{
+		link.URL, expiry, customTTL, link.MaxVisits, link.PasswordHash, unixMilli(link.NotBefore), unixMilli(link.NotAfter),
+		link.TokenHash,
+	}
+
+	added, err := addLinkScript.Run(ctx, s.client, linkKeys(id), args...).Bool()
 
 	if err != nil {
-		return false, NormalizeError(err)
+		return NormalizeError(err)
+	}
+
+	if !added {
+		return ErrExists
+	}
+
+	return nil
+}
+
+// Expand a shortened link from the store with the number of visits, incrementing the visit count, or queuing
+// the visit to be counted in the background with AsyncVisits.
+// Returns ErrDeleted if the link is in the trash, ErrNotActive or ErrExpired if it is outside its activation
+// window, ErrProtected if it is password protected, or ErrGone if it reached its max visits.
+func (s *RedisStore) ExpandLink(ctx context.Context, id string) (string, int64, error) {
+	return s.ExpandProtectedLink(ctx, id, "")
+}
+
+// Expand a password protected link, provided its password hash is still the given one.
+func (s *RedisStore) ExpandProtectedLink(ctx context.Context, id, passwordHash string) (string, int64, error) {
+	now := time.Now()
+
+	args := []any{
+		s.Expiration.Milliseconds(), passwordHash, now.UnixMilli(), statsField(Hourly, Hourly.bucket(now)),
+		statsField(Daily, Daily.bucket(now)), now.Add(-statsRetention(s.StatsRetention)).Unix(), s.visits == nil,
 	}
 
-	var exists bool
+	result, err := expandLinkScript.Run(ctx, s.client, linkKeys(id), args...).Slice()
 
-	for _, c := range cmds {
-		if !c.(*redis.BoolCmd).Val() {
-			exists = true
+	if err != nil {
+		return "", 0, NormalizeError(err)
+	}
+
+	if reason, ok := result[2].(string); ok {
+		switch reason {
+		case "deleted":
+			return "", 0, ErrDeleted
+		case "inactive":
+			return "", 0, ErrNotActive
+		case "expired":
+			return "", 0, ErrExpired
+		case "protected":
+			return "", 0, ErrProtected
 		}
+
+		return "", 0, ErrGone
 	}
 
-	if exists {
-		return false, ErrExists
+	visits := result[1].(int64)
+
+	// The visit was not counted, so count it in the background, including it in the visits returned.
+	if result[2].(int64) == 0 {
+		s.visits.add(queuedVisit{id: id, at: now})
+		visits++
 	}
 
-	return true, nil
+	return result[0].(string), visits, nil
 }
 
-// Expand a shortened link from the store with the number of visits, incrementing the visit count.
-func (s *RedisStore) ExpandLink(ctx context.Context, id string) (string, int64, error) {
-	vid := visitId(id)
+// Count a batch of visits and their details made in the background, pipelined in a single round trip. Visits to
+// links that no longer exist are left uncounted.
+func (s *RedisStore) flushVisits(ctx context.Context, batch visitBatch) error {
+	// Scripts cannot fall back to sending their source in a pipeline, so make sure Redis has them cached.
+	for _, script := range []*redis.Script{countVisitsScript, recordVisitsScript} {
+		if err := script.Load(ctx, s.client).Err(); err != nil {
+			return err
+		}
+	}
 
-	cmds, err := s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
-		p.GetEx(ctx, id, s.Expiration)
-		p.Incr(ctx, vid)
-		p.Expire(ctx, vid, s.Expiration)
+	now := time.Now()
+	pipe := s.client.Pipeline()
 
+	for id, delta := range batch {
+		if delta.visits > 0 {
+			countVisitsScript.EvalSha(ctx, pipe, linkKeys(id), s.countVisitsArgs(delta, now)...)
+		}
+
+		if delta.details > 0 {
+			recordVisitsScript.EvalSha(ctx, pipe, linkKeys(id), recordVisitsArgs(delta)...)
+		}
+	}
+
+	_, err := pipe.Exec(ctx)
+
+	return err
+}
+
+// Count a visit to a link without expanding it, or queue it to be counted in the background with AsyncVisits.
+// Visits to links that do not exist are ignored.
+func (s *RedisStore) CountVisit(ctx context.Context, id string) error {
+	now := time.Now()
+
+	if s.visits != nil {
+		s.visits.add(queuedVisit{id: id, at: now})
 		return nil
-	})
+	}
+
+	batch := make(visitBatch)
+	batch.add(queuedVisit{id: id, at: now})
+
+	err := countVisitsScript.Run(ctx, s.client, linkKeys(id), s.countVisitsArgs(batch[id], now)...).Err()
+
+	return NormalizeError(err)
+}
+
+// Get the arguments of the script counting the visits to a link at the given time.
+func (s *RedisStore) countVisitsArgs(delta *visitDelta, now time.Time) []any {
+	args := []any{delta.visits, s.Expiration.Milliseconds(), now.Add(-statsRetention(s.StatsRetention)).Unix()}
+
+	for field, visits := range delta.stats {
+		args = append(args, field, visits)
+	}
+
+	return args
+}
+
+// Get the number of visits counted in the background that were queued, flushed and dropped since the store
+// was created. All zero unless AsyncVisits is set.
+func (s *RedisStore) VisitQueueStats() VisitQueueStats {
+	if s.visits == nil {
+		return VisitQueueStats{}
+	}
+
+	return s.visits.stats()
+}
+
+// Get a link from the store with the number of visits, without counting a visit.
+func (s *RedisStore) GetLink(ctx context.Context, id string) (Link, error) {
+	result, err := getLinkScript.Run(ctx, s.client, linkKeys(id)).Slice()
 
 	if err != nil {
-		return "", 0, NormalizeError(err)
+		return Link{}, NormalizeError(err)
+	}
+
+	link := Link{
+		URL:    result[0].(string),
+		Visits: result[1].(int64),
+		TTL:    s.Expiration,
+	}
+
+	if pttl := result[2].(int64); pttl > 0 {
+		link.ExpiresAt = time.Now().Add(time.Duration(pttl) * time.Millisecond)
 	}
 
-	link := cmds[0].(*redis.StringCmd).Val()
-	count := cmds[1].(*redis.IntCmd).Val()
+	if ttl := result[3].(string); ttl != "" {
+		ms, err := strconv.ParseInt(ttl, 10, 64)
+
+		if err != nil {
+			return Link{}, err
+		}
+
+		link.TTL = time.Duration(ms) * time.Millisecond
+		link.Absolute = ms == 0 && !link.ExpiresAt.IsZero()
+	}
+
+	if maxVisits := result[4].(string); maxVisits != "" {
+		if link.MaxVisits, err = strconv.ParseInt(maxVisits, 10, 64); err != nil {
+			return Link{}, err
+		}
+	}
 
-	return link, count, nil
+	link.PasswordHash = result[5].(string)
+
+	if link.NotBefore, err = parseUnixMilli(result[6].(string)); err != nil {
+		return Link{}, err
+	}
+
+	if link.NotAfter, err = parseUnixMilli(result[7].(string)); err != nil {
+		return Link{}, err
+	}
+
+	if link.Version, err = strconv.ParseInt(result[8].(string), 10, 64); err != nil {
+		return Link{}, err
+	}
+
+	link.TokenHash = result[9].(string)
+
+	if link.DeletedAt, err = parseUnixMilli(result[10].(string)); err != nil {
+		return Link{}, err
+	}
+
+	link.UniqueVisitors = result[11].(int64)
+
+	return link, nil
 }
 
-// Delete a link and its visit count from the store.
-func (s *RedisStore) DeleteLink(ctx context.Context, id string) error {
-	_, err := s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
-		p.Del(ctx, id)
-		p.Del(ctx, visitId(id))
+// Change the destination of a link, keeping the previous one in its history.
+// Returns ErrDeleted if the link is in the trash, or ErrVersionMismatch if it is not at the expected version.
+func (s *RedisStore) UpdateLink(ctx context.Context, id string, update LinkUpdate) (Link, error) {
+	args := []any{update.URL, update.Version, update.Author, time.Now().UnixMilli()}
+
+	result, err := updateLinkScript.Run(ctx, s.client, linkKeys(id), args...).Slice()
+
+	if err != nil {
+		return Link{}, NormalizeError(err)
+	}
+
+	if len(result) > 2 {
+		if result[2] == "deleted" {
+			return Link{}, ErrDeleted
+		}
+
+		return Link{}, ErrVersionMismatch
+	}
+
+	return s.GetLink(ctx, id)
+}
+
+// Get the past destinations of a link, oldest first.
+func (s *RedisStore) LinkHistory(ctx context.Context, id string) ([]Revision, error) {
+	entries, err := linkHistoryScript.Run(ctx, s.client, linkKeys(id)).StringSlice()
+
+	if err != nil {
+		return nil, NormalizeError(err)
+	}
+
+	revisions := make([]Revision, 0, len(entries))
+
+	for _, entry := range entries {
+		var r struct {
+			Version   int64  `json:"version"`
+			URL       string `json:"url"`
+			ChangedBy string `json:"changed_by"`
+			ChangedAt int64  `json:"changed_at"`
+		}
+
+		if err := json.Unmarshal([]byte(entry), &r); err != nil {
+			return nil, err
+		}
+
+		revisions = append(revisions, Revision{
+			Version:   r.Version,
+			URL:       r.URL,
+			ChangedBy: r.ChangedBy,
+			ChangedAt: time.UnixMilli(r.ChangedAt),
+		})
+	}
+
+	return revisions, nil
+}
+
+// Get the visits to a link in the buckets of the given granularity starting within [from, to), ordered by
+// start. Buckets without visits are left out.
+func (s *RedisStore) LinkStats(ctx context.Context, id string, granularity Granularity, from, to time.Time) ([]StatsBucket, error) {
+	result, err := s.client.HGetAll(ctx, statsId(id)).Result()
+
+	if err != nil {
+		return nil, NormalizeError(err)
+	}
 
+	fields := make(map[string]int64, len(result))
+
+	for field, value := range result {
+		if fields[field], err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+
+	return collectStats(fields, granularity, from, to), nil
+}
+
+// Count the details of a visit in the breakdowns and unique visitors of a link, or queue them to be counted in
+// the background with AsyncVisits. Visits to links that do not exist are ignored.
+func (s *RedisStore) RecordVisit(ctx context.Context, id string, visit Visit) error {
+	v := queuedVisit{id: id, at: time.Now(), visit: &visit}
+
+	if s.visits != nil {
+		s.visits.add(v)
 		return nil
-	})
+	}
+
+	batch := make(visitBatch)
+	batch.add(v)
+
+	err := recordVisitsScript.Run(ctx, s.client, linkKeys(id), recordVisitsArgs(batch[id])...).Err()
+
+	return NormalizeError(err)
+}
+
+// Get the arguments of the script counting the details of the visits to a link.
+func recordVisitsArgs(delta *visitDelta) []any {
+	args := make([]any, 0, 3+len(delta.visitors)+2*len(delta.fields))
+	args = append(args, MaxBreakdownValues, OtherValue, len(delta.visitors))
+
+	for visitor := range delta.visitors {
+		args = append(args, visitor)
+	}
+
+	for field, visits := range delta.fields {
+		args = append(args, field, visits)
+	}
+
+	return args
+}
+
+// Get the most common values of every dimension of the visits to a link, up to top of them, ordered by their
+// visits, most first, and then by value.
+func (s *RedisStore) LinkBreakdowns(ctx context.Context, id string, top int) (map[Dimension][]BreakdownEntry, error) {
+	result, err := s.client.HGetAll(ctx, breakdownsId(id)).Result()
+
+	if err != nil {
+		return nil, NormalizeError(err)
+	}
+
+	fields := make(map[string]int64, len(result))
+
+	for field, value := range result {
+		if fields[field], err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+
+	return topBreakdowns(fields, top), nil
+}
+
+// Move a link to the trash, where it no longer redirects until it is restored or purged.
+// Returns ErrDeleted if the link is already in the trash.
+func (s *RedisStore) TrashLink(ctx context.Context, id string) error {
+	trashed, err := trashLinkScript.Run(ctx, s.client, linkKeys(id), time.Now().UnixMilli()).Bool()
+
+	if err != nil {
+		return NormalizeError(err)
+	}
+
+	if !trashed {
+		return ErrDeleted
+	}
+
+	return nil
+}
+
+// Take a link out of the trash. Returns ErrNil if the link is not in the trash.
+func (s *RedisStore) RestoreLink(ctx context.Context, id string) error {
+	restored, err := restoreLinkScript.Run(ctx, s.client, linkKeys(id), s.Expiration.Milliseconds()).Bool()
+
+	if err != nil {
+		return NormalizeError(err)
+	}
+
+	if !restored {
+		return ErrNil
+	}
+
+	return nil
+}
+
+// Get the links in the trash, oldest first.
+func (s *RedisStore) ListTrash(ctx context.Context) ([]TrashedLink, error) {
+	result, err := listTrashScript.Run(ctx, s.client, nil).Slice()
+
+	if err != nil {
+		return nil, NormalizeError(err)
+	}
+
+	links := make([]TrashedLink, 0, len(result))
+
+	for _, entry := range result {
+		fields := entry.([]any)
+		deletedAt, err := parseUnixMilli(fields[2].(string))
+
+		if err != nil {
+			return nil, err
+		}
+
+		links = append(links, TrashedLink{Id: fields[0].(string), URL: fields[1].(string), DeletedAt: deletedAt})
+	}
+
+	return links, nil
+}
+
+// Permanently delete the links moved to the trash at or before the given time, returning how many were deleted.
+func (s *RedisStore) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	n, err := purgeTrashScript.Run(ctx, s.client, nil, before.UnixMilli()).Int()
+
+	return n, NormalizeError(err)
+}
+
+// Get a page of at most limit links from the store with their visit counts, starting at the given cursor,
+// and the cursor of the next page, which is empty after the last page. Links are listed in no particular
+// order, and links added or removed while listing may or may not be listed. At most ten times the limit keys,
+// and no fewer than minListScanKeys, are examined per page, so pages filtered by URL may hold fewer links, or
+// none, before the last page.
+// The cursor holds the SCAN cursor to continue from, and the keys SCAN returned beyond the page that are yet
+// to be examined. Returns ErrInvalidLimit if the limit is not positive.
+func (s *RedisStore) ListLinks(ctx context.Context, cursor string, limit int, filter LinkFilter) ([]ListedLink, string, error) {
+	if limit <= 0 {
+		return nil, "", ErrInvalidLimit
+	}
+
+	scan, pending, err := parseScanCursor(cursor)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	// A SCAN cursor of zero ends the iteration, unless it is just starting.
+	more := cursor == "" || scan != 0
+	budget := max(10*limit, minListScanKeys)
+	examined := 0
+
+	links := make([]ListedLink, 0, limit)
+
+	for {
+		for len(pending) > 0 {
+			if len(links) == limit {
+				return links, formatScanCursor(scan, pending), nil
+			}
+
+			key := pending[0]
+			pending = pending[1:]
+			examined++
+
+			if !isLinkKey(key) {
+				continue
+			}
+
+			link, err := s.GetLink(ctx, key)
+
+			if err == ErrNil {
+				continue
+			} else if err != nil {
+				return nil, "", err
+			}
+
+			if filter.match(link) {
+				links = append(links, ListedLink{Id: key, Link: link})
+			}
+		}
+
+		if !more {
+			return links, "", nil
+		}
+
+		if len(links) == limit || examined >= budget {
+			return links, formatScanCursor(scan, nil), nil
+		}
+
+		// Only asking for the room left on the page keeps the keys carried over to the next page few.
+		keys, next, err := s.client.ScanType(ctx, scan, "*", int64(limit-len(links)), "string").Result()
+
+		if err != nil {
+			return nil, "", NormalizeError(err)
+		}
+
+		scan, pending, more = next, keys, next != 0
+	}
+}
+
+// Delete a link, its visit count, settings, history, stats, breakdowns, visitors and URL index from the store.
+func (s *RedisStore) DeleteLink(ctx context.Context, id string) error {
+	err := deleteLinkScript.Run(ctx, s.client, linkKeys(id)).Err()
 
 	return NormalizeError(err)
 }
 
+// Return the link already holding the URL with its visits, or add a link with the configured expiration.
+func (s *RedisStore) AddOrGetLink(ctx context.Context, id, url string) (string, int64, error) {
+	result, err := addOrGetLinkScript.Run(ctx, s.client, linkKeys(id), id, url, s.Expiration.Milliseconds()).Slice()
+
+	if err == redis.Nil {
+		return "", 0, ErrExists
+	} else if err != nil {
+		return "", 0, NormalizeError(err)
+	}
+
+	return result[0].(string), result[1].(int64), nil
+}
+
+// Get the next number in the store's sequence.
+func (s *RedisStore) NextSequence(ctx context.Context) (uint64, error) {
+	n, err := s.client.Incr(ctx, sequenceKey).Uint64()
+
+	return n, NormalizeError(err)
+}
+
+// Report whether a string key holds a link, rather than a visit count, the visitors of a link, which are held in
+// a string, or one of the service's own keys.
+func isLinkKey(key string) bool {
+	return !strings.HasPrefix(key, "shrink:") && !strings.HasSuffix(key, ":visits") &&
+		!strings.HasSuffix(key, ":visitors")
+}
+
+// Parse a cursor of the Redis store into the SCAN cursor and the keys it returned that are yet to be examined.
+// An empty cursor starts from the beginning.
+func parseScanCursor(cursor string) (uint64, []string, error) {
+	if cursor == "" {
+		return 0, nil, nil
+	}
+
+	scan, encoded, _ := strings.Cut(cursor, "-")
+
+	n, err := strconv.ParseUint(scan, 10, 64)
+
+	if err != nil {
+		return 0, nil, ErrInvalidCursor
+	}
+
+	if encoded == "" {
+		return n, nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+
+	if err != nil {
+		return 0, nil, ErrInvalidCursor
+	}
+
+	var pending []string
+
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return 0, nil, ErrInvalidCursor
+	}
+
+	return n, pending, nil
+}
+
+// Format a cursor of the Redis store from the SCAN cursor and the keys it returned that are yet to be examined.
+func formatScanCursor(scan uint64, pending []string) string {
+	if len(pending) == 0 {
+		return strconv.FormatUint(scan, 10)
+	}
+
+	data, _ := json.Marshal(pending)
+
+	return fmt.Sprintf("%d-%s", scan, base64.RawURLEncoding.EncodeToString(data))
+}
+
+// Get the error for a link outside its activation window at the given time, or nil if it is active.
+func activationError(notBefore, notAfter, now time.Time) error {
+	switch {
+	case !notBefore.IsZero() && now.Before(notBefore):
+		return ErrNotActive
+	case !notAfter.IsZero() && !now.Before(notAfter):
+		return ErrExpired
+	}
+
+	return nil
+}
+
+// Get the error for a link that exists but could not be expanded, or ErrNil if it could have been.
+func unexpandableError(protected, gone bool) error {
+	switch {
+	case protected:
+		return ErrProtected
+	case gone:
+		return ErrGone
+	}
+
+	return ErrNil
+}
+
+// Format a time in Unix milliseconds, or as an empty string if it is zero.
+func unixMilli(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+// Parse a time in Unix milliseconds, where an empty string is the zero time.
+func parseUnixMilli(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	ms, err := strconv.ParseInt(s, 10, 64)
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.UnixMilli(ms), nil
+}
+
 // Get the visits count ID for the given link ID.
 func visitId(id string) string {
 	return fmt.Sprintf("%s:visits", id)
 }
+
+// Get the settings ID for the given link ID.
+func metaId(id string) string {
+	return fmt.Sprintf("%s:meta", id)
+}
+
+// Get the history ID for the given link ID.
+func historyId(id string) string {
+	return fmt.Sprintf("%s:history", id)
+}
+
+// Get the stats ID for the given link ID.
+func statsId(id string) string {
+	return fmt.Sprintf("%s:stats", id)
+}
+
+// Get the breakdowns ID for the given link ID.
+func breakdownsId(id string) string {
+	return fmt.Sprintf("%s:breakdowns", id)
+}
+
+// Get the visitors ID for the given link ID.
+func visitorsId(id string) string {
+	return fmt.Sprintf("%s:visitors", id)
+}
+
+// Get the keys of the given link ID passed to the scripts.
+func linkKeys(id string) []string {
+	return []string{id, visitId(id), metaId(id), historyId(id), statsId(id), breakdownsId(id), visitorsId(id)}
+}
@@ -0,0 +1,379 @@
+package shrinkmyurl
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultCacheSize = 10000
+	defaultCacheTTL  = time.Minute
+)
+
+// Options for the cached store.
+type CachedStoreOptions struct {
+	// The store that links are cached from, and that everything else is passed on to.
+	Store Store
+	// Maximum number of links cached, beyond which the least recently used are evicted. Defaults to 10000.
+	Size int
+	// How long a link is cached before it is read from the store again. Defaults to one minute.
+	TTL time.Duration
+}
+
+// The number of expansions served from the cache, and of those that had to read the store.
+type CacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+// A link held by the cache, with when it must be read from the store again.
+type cacheEntry struct {
+	id      string
+	link    Link
+	expires time.Time
+}
+
+// A Store that wraps another store, caching the links it expands in a size-bounded LRU so that visits to
+// popular links do not read the wrapped store. Concurrent misses for the same link are collapsed into a single
+// read. Visits to cached links are still counted by the wrapped store, which must be a VisitCounter for links
+// to be cached; other stores expand every link as usual.
+//
+// Only links that can be expanded without further checks are cached, so links with max visits or a password,
+// and links in the trash, are always expanded by the wrapped store. Changes made through the cached store
+// remove the link from the cache, while changes made elsewhere, such as by other instances, are seen once the
+// link's TTL passes. The visits returned for cached links are counted from when they were cached.
+//
+// Every visit to a cached link still writes its count and details to the wrapped store, unless the wrapped store
+// counts them in the background, as a RedisStore does with AsyncVisits.
+type CachedStore struct {
+	CachedStoreOptions
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// Entries from the most to the least recently used.
+	order *list.List
+	// Incremented whenever links are removed from the cache, so that reads started before are not cached.
+	generation uint64
+
+	group  singleflight.Group
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// Create a new cached store with the given options. Panics if there is no store to cache.
+func NewCachedStore(ops CachedStoreOptions) *CachedStore {
+	if ops.Store == nil {
+		panic(ErrStoreRequired)
+	}
+
+	if ops.Size <= 0 {
+		ops.Size = defaultCacheSize
+	}
+
+	if ops.TTL <= 0 {
+		ops.TTL = defaultCacheTTL
+	}
+
+	return &CachedStore{CachedStoreOptions: ops, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// Get the number of expansions served from the cache and read from the store so far.
+func (s *CachedStore) CacheStats() CacheStats {
+	return CacheStats{Hits: s.hits.Load(), Misses: s.misses.Load()}
+}
+
+// Close the wrapped store.
+func (s *CachedStore) Close() error {
+	return s.Store.Close()
+}
+
+// Ping the wrapped store.
+func (s *CachedStore) Ping(ctx context.Context) error {
+	return s.Store.Ping(ctx)
+}
+
+// Add a link to the wrapped store, removing any link with the same ID from the cache.
+func (s *CachedStore) AddLink(ctx context.Context, id, url string) (bool, error) {
+	defer s.invalidate(id)
+
+	return s.Store.AddLink(ctx, id, url)
+}
+
+// Add a link to the wrapped store with its own settings, removing any link with the same ID from the cache.
+func (s *CachedStore) CreateLink(ctx context.Context, id string, link Link) error {
+	defer s.invalidate(id)
+
+	return s.Store.CreateLink(ctx, id, link)
+}
+
+// Expand a shortened link from the cache, counting the visit in the wrapped store, or from the wrapped store
+// if the link is not cached, caching it if it can be.
+func (s *CachedStore) ExpandLink(ctx context.Context, id string) (string, int64, error) {
+	counter, ok := s.Store.(VisitCounter)
+
+	if !ok {
+		return s.Store.ExpandLink(ctx, id)
+	}
+
+	now := time.Now()
+	link, hit := s.visit(id, now)
+
+	if hit {
+		s.hits.Add(1)
+	} else {
+		s.misses.Add(1)
+
+		if err := s.load(ctx, id, now); err != nil {
+			return "", 0, err
+		}
+
+		// The link could not be cached, so leave its checks to the wrapped store.
+		if link, hit = s.visit(id, now); !hit {
+			return s.Store.ExpandLink(ctx, id)
+		}
+	}
+
+	if err := counter.CountVisit(ctx, id); err != nil {
+		return "", 0, err
+	}
+
+	return link.URL, link.Visits, nil
+}
+
+// Expand a password protected link from the wrapped store. Protected links are never cached.
+func (s *CachedStore) ExpandProtectedLink(ctx context.Context, id, passwordHash string) (string, int64, error) {
+	return s.Store.ExpandProtectedLink(ctx, id, passwordHash)
+}
+
+// Get a link from the wrapped store, which always has its latest visits and settings.
+func (s *CachedStore) GetLink(ctx context.Context, id string) (Link, error) {
+	return s.Store.GetLink(ctx, id)
+}
+
+// Get a page of links from the wrapped store.
+func (s *CachedStore) ListLinks(ctx context.Context, cursor string, limit int, filter LinkFilter) ([]ListedLink, string, error) {
+	return s.Store.ListLinks(ctx, cursor, limit, filter)
+}
+
+// Change the destination of a link in the wrapped store, removing it from the cache.
+func (s *CachedStore) UpdateLink(ctx context.Context, id string, update LinkUpdate) (Link, error) {
+	defer s.invalidate(id)
+
+	return s.Store.UpdateLink(ctx, id, update)
+}
+
+// Get the past destinations of a link from the wrapped store.
+func (s *CachedStore) LinkHistory(ctx context.Context, id string) ([]Revision, error) {
+	return s.Store.LinkHistory(ctx, id)
+}
+
+// Get the visits to a link in buckets of the given granularity from the wrapped store, if it keeps stats.
+// Returns ErrStatsUnsupported if it does not.
+func (s *CachedStore) LinkStats(ctx context.Context, id string, granularity Granularity, from, to time.Time) ([]StatsBucket, error) {
+	stats, ok := s.Store.(StatsRecorder)
+
+	if !ok {
+		return nil, ErrStatsUnsupported
+	}
+
+	return stats.LinkStats(ctx, id, granularity, from, to)
+}
+
+// Count the details of a visit in the wrapped store, if it keeps stats. Returns ErrStatsUnsupported if it
+// does not.
+func (s *CachedStore) RecordVisit(ctx context.Context, id string, visit Visit) error {
+	stats, ok := s.Store.(StatsRecorder)
+
+	if !ok {
+		return ErrStatsUnsupported
+	}
+
+	return stats.RecordVisit(ctx, id, visit)
+}
+
+// Get the most common values of every dimension of the visits to a link from the wrapped store, if it keeps
+// stats. Returns ErrStatsUnsupported if it does not.
+func (s *CachedStore) LinkBreakdowns(ctx context.Context, id string, top int) (map[Dimension][]BreakdownEntry, error) {
+	stats, ok := s.Store.(StatsRecorder)
+
+	if !ok {
+		return nil, ErrStatsUnsupported
+	}
+
+	return stats.LinkBreakdowns(ctx, id, top)
+}
+
+// Move a link to the trash in the wrapped store, if it has one, removing it from the cache. Returns
+// ErrTrashUnsupported if it does not.
+func (s *CachedStore) TrashLink(ctx context.Context, id string) error {
+	trash, ok := s.Store.(Trasher)
+
+	if !ok {
+		return ErrTrashUnsupported
+	}
+
+	defer s.invalidate(id)
+
+	return trash.TrashLink(ctx, id)
+}
+
+// Take a link out of the trash in the wrapped store, if it has one, removing it from the cache. Returns
+// ErrTrashUnsupported if it does not.
+func (s *CachedStore) RestoreLink(ctx context.Context, id string) error {
+	trash, ok := s.Store.(Trasher)
+
+	if !ok {
+		return ErrTrashUnsupported
+	}
+
+	defer s.invalidate(id)
+
+	return trash.RestoreLink(ctx, id)
+}
+
+// Get the links in the trash from the wrapped store, if it has one. Returns ErrTrashUnsupported if it does not.
+func (s *CachedStore) ListTrash(ctx context.Context) ([]TrashedLink, error) {
+	trash, ok := s.Store.(Trasher)
+
+	if !ok {
+		return nil, ErrTrashUnsupported
+	}
+
+	return trash.ListTrash(ctx)
+}
+
+// Permanently delete the links moved to the trash at or before the given time from the wrapped store, if it
+// has one. Links in the trash are never cached, so the cache is left as is. Returns ErrTrashUnsupported if it
+// does not.
+func (s *CachedStore) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	trash, ok := s.Store.(Trasher)
+
+	if !ok {
+		return 0, ErrTrashUnsupported
+	}
+
+	return trash.PurgeTrash(ctx, before)
+}
+
+// Delete a link from the wrapped store and the cache.
+func (s *CachedStore) DeleteLink(ctx context.Context, id string) error {
+	defer s.invalidate(id)
+
+	return s.Store.DeleteLink(ctx, id)
+}
+
+// Return the link already holding the URL, or add a link, if the wrapped store supports deduplication.
+// Returns ErrDeduplicateUnsupported if it does not.
+func (s *CachedStore) AddOrGetLink(ctx context.Context, id, url string) (string, int64, error) {
+	dedup, ok := s.Store.(Deduplicator)
+
+	if !ok {
+		return "", 0, ErrDeduplicateUnsupported
+	}
+
+	defer s.invalidate(id)
+
+	return dedup.AddOrGetLink(ctx, id, url)
+}
+
+// Get the cached link by ID at the given time, counting a visit to it, and report whether it was cached.
+// Links whose TTL passed or that can no longer be expanded as cached are removed.
+func (s *CachedStore) visit(id string, now time.Time) (Link, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.entries[id]
+
+	if !ok {
+		return Link{}, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+
+	if !now.Before(entry.expires) || !cacheable(entry.link, now) {
+		s.order.Remove(element)
+		delete(s.entries, id)
+		return Link{}, false
+	}
+
+	s.order.MoveToFront(element)
+	entry.link.Visits++
+
+	return entry.link, true
+}
+
+// Read a link from the wrapped store and cache it, if it can be cached. Concurrent reads of the same link are
+// collapsed into one.
+func (s *CachedStore) load(ctx context.Context, id string, now time.Time) error {
+	_, err, _ := s.group.Do(id, func() (any, error) {
+		s.mu.Lock()
+		generation := s.generation
+		s.mu.Unlock()
+
+		link, err := s.Store.GetLink(ctx, id)
+
+		if err != nil || !cacheable(link, now) {
+			return nil, err
+		}
+
+		s.put(id, link, generation, now)
+
+		return nil, nil
+	})
+
+	return err
+}
+
+// Cache a link read at the given generation, unless links were removed from the cache since, evicting the least
+// recently used link if the cache is full.
+func (s *CachedStore) put(id string, link Link, generation uint64, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if generation != s.generation {
+		return
+	}
+
+	entry := &cacheEntry{id: id, link: link, expires: now.Add(s.TTL)}
+
+	if element, ok := s.entries[id]; ok {
+		element.Value = entry
+		s.order.MoveToFront(element)
+		return
+	}
+
+	s.entries[id] = s.order.PushFront(entry)
+
+	if s.order.Len() > s.Size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*cacheEntry).id)
+	}
+}
+
+// Remove a link from the cache, and keep reads of it in progress from caching it.
+func (s *CachedStore) invalidate(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.generation++
+
+	if element, ok := s.entries[id]; ok {
+		s.order.Remove(element)
+		delete(s.entries, id)
+	}
+
+	s.group.Forget(id)
+}
+
+// Report whether a link can be expanded from the cache at the given time without further checks.
+func cacheable(link Link, now time.Time) bool {
+	return link.MaxVisits == 0 && link.PasswordHash == "" && link.DeletedAt.IsZero() &&
+		activationError(link.NotBefore, link.NotAfter, now) == nil &&
+		(link.ExpiresAt.IsZero() || now.Before(link.ExpiresAt))
+}
@@ -0,0 +1,193 @@
+package shrinkmyurl_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	shrink "github.com/derek-schaefer/shrink-my-url"
+	"github.com/derek-schaefer/shrink-my-url/storetest"
+	"github.com/stretchr/testify/assert"
+)
+
+// A Redis store that counts and slows down reads of links, to tell when the cache reads the store.
+type countingRedisStore struct {
+	*shrink.RedisStore
+
+	gets atomic.Int64
+}
+
+func (s *countingRedisStore) GetLink(ctx context.Context, id string) (shrink.Link, error) {
+	s.gets.Add(1)
+	time.Sleep(10 * time.Millisecond)
+
+	return s.RedisStore.GetLink(ctx, id)
+}
+
+func newTestCachedStore(size int) (*shrink.CachedStore, *countingRedisStore) {
+	store := &countingRedisStore{RedisStore: newTestRedisStore().RedisStore}
+
+	return shrink.NewCachedStore(shrink.CachedStoreOptions{Store: store, Size: size, TTL: time.Minute}), store
+}
+
+func TestCachedStoreConformance(t *testing.T) {
+	storetest.Run(t, func() shrink.Store {
+		return shrink.NewCachedStore(shrink.CachedStoreOptions{Store: newTestRedisStore()})
+	})
+
+	storetest.RunExpiration(t, func(expiration time.Duration) shrink.Store {
+		return shrink.NewCachedStore(shrink.CachedStoreOptions{Store: shrink.Must(shrink.NewRedisStore(shrink.RedisStoreOptions{
+			Expiration: expiration,
+		}))})
+	})
+}
+
+func TestCachedStoreMemoryConformance(t *testing.T) {
+	storetest.Run(t, func() shrink.Store {
+		return shrink.NewCachedStore(shrink.CachedStoreOptions{Store: shrink.Must(shrink.NewMemoryStore(shrink.MemoryStoreOptions{}))})
+	})
+}
+
+func TestCachedStoreMemoryExpandLink(t *testing.T) {
+	store := shrink.Must(shrink.NewMemoryStore(shrink.MemoryStoreOptions{}))
+	cache := shrink.NewCachedStore(shrink.CachedStoreOptions{Store: store, Size: 10, TTL: time.Minute})
+
+	defer cache.Close()
+
+	assert.True(t, shrink.Must(cache.AddLink(context.Background(), "cached", "url")))
+
+	for i := int64(1); i <= 3; i++ {
+		_, visits, err := cache.ExpandLink(context.Background(), "cached")
+
+		assert.Nil(t, err)
+		assert.Equal(t, i, visits)
+	}
+
+	assert.Equal(t, shrink.CacheStats{Hits: 2, Misses: 1}, cache.CacheStats())
+
+	link, err := store.GetLink(context.Background(), "cached")
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), link.Visits, "visits to cached links are counted by the store")
+}
+
+func TestCachedStoreExpandLink(t *testing.T) {
+	cache, store := newTestCachedStore(10)
+
+	defer cache.Close()
+	defer cache.DeleteLink(context.Background(), "cached")
+
+	assert.True(t, shrink.Must(cache.AddLink(context.Background(), "cached", "url")))
+
+	for i := int64(1); i <= 3; i++ {
+		link, visits, err := cache.ExpandLink(context.Background(), "cached")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "url", link)
+		assert.Equal(t, i, visits)
+	}
+
+	assert.Equal(t, int64(1), store.gets.Load(), "the link is read once")
+	assert.Equal(t, shrink.CacheStats{Hits: 2, Misses: 1}, cache.CacheStats())
+
+	link, err := store.RedisStore.GetLink(context.Background(), "cached")
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), link.Visits, "visits to cached links are counted by the store")
+
+	_, err = cache.UpdateLink(context.Background(), "cached", shrink.LinkUpdate{URL: "changed"})
+
+	assert.Nil(t, err)
+
+	expanded, _, err := cache.ExpandLink(context.Background(), "cached")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "changed", expanded, "changes remove the link from the cache")
+
+	assert.Nil(t, cache.TrashLink(context.Background(), "cached"))
+
+	_, _, err = cache.ExpandLink(context.Background(), "cached")
+
+	assert.Equal(t, shrink.ErrDeleted, err)
+
+	assert.Nil(t, cache.DeleteLink(context.Background(), "cached"))
+
+	_, _, err = cache.ExpandLink(context.Background(), "cached")
+
+	assert.Equal(t, shrink.ErrNil, err)
+}
+
+func TestCachedStoreExpandLinkConcurrentMisses(t *testing.T) {
+	cache, store := newTestCachedStore(10)
+
+	defer cache.Close()
+	defer cache.DeleteLink(context.Background(), "concurrent")
+
+	assert.True(t, shrink.Must(cache.AddLink(context.Background(), "concurrent", "url")))
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			link, _, err := cache.ExpandLink(context.Background(), "concurrent")
+
+			assert.Nil(t, err)
+			assert.Equal(t, "url", link)
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, int64(1), store.gets.Load(), "concurrent misses read the link once")
+
+	link, err := store.RedisStore.GetLink(context.Background(), "concurrent")
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(20), link.Visits)
+}
+
+func TestCachedStoreExpandLinkEviction(t *testing.T) {
+	cache, store := newTestCachedStore(1)
+
+	defer cache.Close()
+	defer cache.DeleteLink(context.Background(), "evicted-a")
+	defer cache.DeleteLink(context.Background(), "evicted-b")
+
+	assert.True(t, shrink.Must(cache.AddLink(context.Background(), "evicted-a", "url-a")))
+	assert.True(t, shrink.Must(cache.AddLink(context.Background(), "evicted-b", "url-b")))
+
+	for _, id := range []string{"evicted-a", "evicted-a", "evicted-b", "evicted-a"} {
+		_, _, err := cache.ExpandLink(context.Background(), id)
+
+		assert.Nil(t, err)
+	}
+
+	assert.Equal(t, int64(3), store.gets.Load(), "the least recently used link is evicted")
+	assert.Equal(t, shrink.CacheStats{Hits: 1, Misses: 3}, cache.CacheStats())
+}
+
+func TestCachedStoreExpandLinkUncacheable(t *testing.T) {
+	cache, store := newTestCachedStore(10)
+
+	defer cache.Close()
+	defer cache.DeleteLink(context.Background(), "limited")
+
+	assert.Nil(t, cache.CreateLink(context.Background(), "limited", shrink.Link{URL: "url", MaxVisits: 2}))
+
+	for i := 0; i < 2; i++ {
+		_, _, err := cache.ExpandLink(context.Background(), "limited")
+
+		assert.Nil(t, err)
+	}
+
+	_, _, err := cache.ExpandLink(context.Background(), "limited")
+
+	assert.Equal(t, shrink.ErrGone, err, "links with max visits are not cached")
+	assert.Equal(t, int64(3), store.gets.Load())
+}
@@ -0,0 +1,850 @@
+package shrinkmyurl
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"hash/fnv"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMemoryShards          = 32
+	defaultMemoryJanitorInterval = time.Minute
+	defaultMemoryCompactInterval = time.Hour
+)
+
+// Options for the memory store.
+type MemoryStoreOptions struct {
+	// Default expiration for links, refreshed on every visit. Zero disables expiration.
+	Expiration time.Duration
+	// How often expired links are purged. Defaults to one minute.
+	JanitorInterval time.Duration
+	// Number of independently locked shards. Defaults to 32.
+	Shards int
+	// Directory for the append-only log and snapshot. Empty disables persistence.
+	Dir string
+	// How often the append-only log is synced to disk.
+	Sync SyncPolicy
+	// How often the append-only log is compacted into a snapshot. Defaults to one hour.
+	CompactInterval time.Duration
+	// How long visit statistics are kept. Defaults to 90 days.
+	StatsRetention time.Duration
+}
+
+// A link held by the memory store.
+type memoryEntry struct {
+	URL        string           `json:"url"`
+	Visits     int64            `json:"visits"`
+	TTL        time.Duration    `json:"ttl,omitempty"`
+	ExpiresAt  time.Time        `json:"expires_at"`
+	MaxVisits  int64            `json:"max_visits,omitempty"`
+	Password   string           `json:"password,omitempty"`
+	NotBefore  time.Time        `json:"not_before"`
+	NotAfter   time.Time        `json:"not_after"`
+	Version    int64            `json:"version,omitempty"`
+	History    []Revision       `json:"history,omitempty"`
+	Token      string           `json:"token,omitempty"`
+	DeletedAt  time.Time        `json:"deleted_at"`
+	Stats      map[string]int64 `json:"stats,omitempty"`
+	Breakdowns map[string]int64 `json:"breakdowns,omitempty"`
+	Visitors   hyperLogLog      `json:"visitors,omitempty"`
+	Indexed    bool             `json:"indexed,omitempty"`
+
+	// The number of values in the breakdowns of every dimension, counted when first needed.
+	breakdownValues map[string]int
+}
+
+// Report whether the entry has expired at the given time. Entries in the trash do not expire, so that they can
+// be restored until they are purged.
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.trashed() && !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt)
+}
+
+// Push the expiration forward from the given time, or from when the entry becomes active, if the entry
+// has a sliding expiration.
+func (e *memoryEntry) touch(now time.Time) {
+	if e.NotBefore.After(now) {
+		now = e.NotBefore
+	}
+
+	if e.TTL > 0 {
+		e.ExpiresAt = now.Add(e.TTL)
+	}
+}
+
+// Count a visit to the entry at the given time, keeping its stats for the given retention, and push its
+// expiration forward.
+func (e *memoryEntry) visit(at time.Time, retention time.Duration) {
+	if e.Stats == nil {
+		e.Stats = make(map[string]int64)
+	}
+
+	e.Visits++
+	countVisit(e.Stats, at, retention)
+	e.touch(at)
+}
+
+// Count the details of a visit in the entry's breakdowns and visitors, counting new values as OtherValue once a
+// dimension holds the most values it can.
+func (e *memoryEntry) record(visit Visit) {
+	if e.Breakdowns == nil {
+		e.Breakdowns = make(map[string]int64)
+	}
+
+	if e.breakdownValues == nil {
+		e.breakdownValues = make(map[string]int, len(dimensions))
+
+		for field := range e.Breakdowns {
+			name, _, _ := strings.Cut(field, ":")
+			e.breakdownValues[name]++
+		}
+	}
+
+	for _, d := range dimensions {
+		value := visit.value(d)
+		_, counted := e.Breakdowns[string(d)+":"+value]
+		field := string(d) + ":" + breakdownValue(value, counted, e.breakdownValues[string(d)])
+
+		if _, ok := e.Breakdowns[field]; !ok {
+			e.breakdownValues[string(d)]++
+		}
+
+		e.Breakdowns[field]++
+	}
+
+	if visit.Visitor != "" {
+		e.Visitors.add(visit.Visitor)
+	}
+}
+
+// Get the link held by the entry.
+func (e *memoryEntry) link() Link {
+	return Link{
+		URL:            e.URL,
+		Visits:         e.Visits,
+		UniqueVisitors: e.Visitors.count(),
+		TTL:            e.TTL,
+		Absolute:       e.TTL == 0 && !e.ExpiresAt.IsZero(),
+		ExpiresAt:      e.ExpiresAt,
+		MaxVisits:      e.MaxVisits,
+		PasswordHash:   e.Password,
+		NotBefore:      e.NotBefore,
+		NotAfter:       e.NotAfter,
+		Version:        e.version(),
+		TokenHash:      e.Token,
+		DeletedAt:      e.DeletedAt,
+	}
+}
+
+// Get the version of the entry's destination, which is 1 until it is changed.
+func (e *memoryEntry) version() int64 {
+	return max(1, e.Version)
+}
+
+// Change the destination of the entry, keeping the previous one in its history.
+func (e *memoryEntry) update(url string, revision Revision) {
+	e.History = append(e.History, revision)
+	e.URL = url
+	e.Version = revision.Version + 1
+}
+
+// Report whether the entry is in the trash.
+func (e *memoryEntry) trashed() bool {
+	return !e.DeletedAt.IsZero()
+}
+
+// Report whether the entry reached its max visits.
+func (e *memoryEntry) gone() bool {
+	return e.MaxVisits > 0 && e.Visits >= e.MaxVisits
+}
+
+// A subset of the memory store's links guarded by its own lock.
+type memoryShard struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+// An in-memory store implementation, safe for concurrent use.
+// Links are spread across sharded locks and expired links are purged by a background janitor.
+// Optionally, changes are persisted to an append-only log that is periodically compacted into a
+// snapshot, both of which are replayed on startup.
+type MemoryStore struct {
+	MemoryStoreOptions
+
+	shards []*memoryShard
+	done   chan struct{}
+	wg     sync.WaitGroup
+	once   sync.Once
+
+	logMu     sync.Mutex
+	compactMu sync.Mutex
+	aof       *os.File
+	seq       uint64
+
+	counterMu sync.Mutex
+	counter   uint64
+
+	// Index of URLs to the IDs of deduplicated links. Entries are validated against the link on use
+	// rather than removed eagerly, and the index lock is always taken before any shard lock.
+	indexMu sync.Mutex
+	index   map[string]string
+}
+
+// Create a new memory store with the given options, restoring any persisted links, and start its janitor.
+func NewMemoryStore(ops MemoryStoreOptions) (*MemoryStore, error) {
+	if ops.Shards <= 0 {
+		ops.Shards = defaultMemoryShards
+	}
+
+	if ops.JanitorInterval <= 0 {
+		ops.JanitorInterval = defaultMemoryJanitorInterval
+	}
+
+	if ops.CompactInterval <= 0 {
+		ops.CompactInterval = defaultMemoryCompactInterval
+	}
+
+	s := &MemoryStore{
+		MemoryStoreOptions: ops,
+		shards:             make([]*memoryShard, ops.Shards),
+		done:               make(chan struct{}),
+		index:              make(map[string]string),
+	}
+
+	for i := range s.shards {
+		s.shards[i] = &memoryShard{entries: make(map[string]*memoryEntry)}
+	}
+
+	if ops.Dir != "" {
+		if err := s.restore(); err != nil {
+			if s.aof != nil {
+				s.aof.Close()
+			}
+
+			return nil, err
+		}
+	}
+
+	s.wg.Add(1)
+	go s.janitor()
+
+	return s, nil
+}
+
+// Close the memory store, stopping its janitor and syncing the log to disk.
+func (s *MemoryStore) Close() error {
+	var err error
+
+	s.once.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+
+		s.logMu.Lock()
+		defer s.logMu.Unlock()
+
+		if s.aof != nil {
+			err = errors.Join(s.aof.Sync(), s.aof.Close())
+			s.aof = nil
+		}
+	})
+
+	return err
+}
+
+// Ping the memory store.
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Add a link to the memory store with the configured expiration.
+func (s *MemoryStore) AddLink(ctx context.Context, id, url string) (bool, error) {
+	return s.AddLinkTTL(ctx, id, url, s.Expiration)
+}
+
+// Add a link to the memory store that expires after the given TTL, refreshed on every visit.
+// A TTL of zero means the link does not expire.
+func (s *MemoryStore) AddLinkTTL(ctx context.Context, id, url string, ttl time.Duration) (bool, error) {
+	if err := s.insert(id, &memoryEntry{URL: url, TTL: ttl}); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Add a link to the memory store with its own settings. Returns ErrExists if the ID is taken.
+func (s *MemoryStore) CreateLink(ctx context.Context, id string, link Link) error {
+	ttl, expiresAt := link.expiration(time.Now(), s.Expiration)
+
+	entry := &memoryEntry{
+		URL:       link.URL,
+		TTL:       ttl,
+		ExpiresAt: expiresAt,
+		MaxVisits: link.MaxVisits,
+		Password:  link.PasswordHash,
+		NotBefore: link.NotBefore,
+		NotAfter:  link.NotAfter,
+		Token:     link.TokenHash,
+	}
+
+	return s.insert(id, entry)
+}
+
+// Return the link already holding the URL with its visits, or add a link with the configured expiration.
+func (s *MemoryStore) AddOrGetLink(ctx context.Context, id, url string) (string, int64, error) {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	if existing, ok := s.index[url]; ok {
+		if visits, ok := s.indexedVisits(existing, url); ok {
+			return existing, visits, nil
+		}
+	}
+
+	if err := s.insert(id, &memoryEntry{URL: url, TTL: s.Expiration, Indexed: true}); err != nil {
+		return "", 0, err
+	}
+
+	s.index[url] = id
+
+	return id, 0, nil
+}
+
+// Get the visits of the link with the given ID if it is live and still holds the URL.
+func (s *MemoryStore) indexedVisits(id, url string) (int64, bool) {
+	shard := s.shard(id)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	e, ok := shard.entries[id]
+
+	if !ok || e.expired(time.Now()) || e.trashed() || e.URL != url {
+		return 0, false
+	}
+
+	return e.Visits, true
+}
+
+// Insert a new link, unless a live link with the same ID exists.
+func (s *MemoryStore) insert(id string, e *memoryEntry) error {
+	shard := s.shard(id)
+	now := time.Now()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if existing, ok := shard.entries[id]; ok && !existing.expired(now) {
+		return ErrExists
+	}
+
+	e.touch(now)
+
+	if err := s.append(memoryEvent{Op: memoryOpAdd, Id: id, Entry: e}); err != nil {
+		return err
+	}
+
+	shard.entries[id] = e
+
+	return nil
+}
+
+// Expand a shortened link from the memory store with the number of visits, incrementing the visit count.
+// The expiration is refreshed on every visit, unless it is fixed. Returns ErrDeleted if the link is in the
+// trash, ErrNotActive or ErrExpired if it is outside its activation window, ErrProtected if it is password
+// protected, or ErrGone if it reached its max visits.
+func (s *MemoryStore) ExpandLink(ctx context.Context, id string) (string, int64, error) {
+	return s.ExpandProtectedLink(ctx, id, "")
+}
+
+// Expand a password protected link, provided its password hash is still the given one.
+func (s *MemoryStore) ExpandProtectedLink(ctx context.Context, id, passwordHash string) (string, int64, error) {
+	shard := s.shard(id)
+	now := time.Now()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	e, ok := shard.entries[id]
+
+	if !ok || e.expired(now) {
+		return "", 0, ErrNil
+	}
+
+	if e.trashed() {
+		return "", 0, ErrDeleted
+	}
+
+	if err := activationError(e.NotBefore, e.NotAfter, now); err != nil {
+		return "", 0, err
+	}
+
+	if e.Password != passwordHash {
+		return "", 0, ErrProtected
+	}
+
+	if e.gone() {
+		return "", 0, ErrGone
+	}
+
+	if err := s.append(memoryEvent{Op: memoryOpVisit, Id: id, At: now}); err != nil {
+		return "", 0, err
+	}
+
+	e.visit(now, statsRetention(s.StatsRetention))
+
+	return e.URL, e.Visits, nil
+}
+
+// Count a visit to a link without expanding it, refreshing its expiration unless it is fixed. Visits to links
+// that do not exist are ignored.
+func (s *MemoryStore) CountVisit(ctx context.Context, id string) error {
+	shard := s.shard(id)
+	now := time.Now()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	e, ok := shard.entries[id]
+
+	if !ok || e.expired(now) {
+		return nil
+	}
+
+	if err := s.append(memoryEvent{Op: memoryOpVisit, Id: id, At: now}); err != nil {
+		return err
+	}
+
+	e.visit(now, statsRetention(s.StatsRetention))
+
+	return nil
+}
+
+// Get a link from the memory store with the number of visits, without counting a visit.
+func (s *MemoryStore) GetLink(ctx context.Context, id string) (Link, error) {
+	shard := s.shard(id)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	e, ok := shard.entries[id]
+
+	if !ok || e.expired(time.Now()) {
+		return Link{}, ErrNil
+	}
+
+	return e.link(), nil
+}
+
+// Get a page of at most limit links from the memory store with their visit counts, ordered by ID, starting
+// after the ID in the cursor, and the cursor of the next page, which is empty after the last page. Only the
+// first limit links are kept while scanning, so that a page costs memory for its own links only.
+// Returns ErrInvalidLimit if the limit is not positive.
+func (s *MemoryStore) ListLinks(ctx context.Context, cursor string, limit int, filter LinkFilter) ([]ListedLink, string, error) {
+	if limit <= 0 {
+		return nil, "", ErrInvalidLimit
+	}
+
+	now := time.Now()
+	page := make(listedLinkHeap, 0, limit+1)
+
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+
+		for id, e := range shard.entries {
+			if (cursor != "" && id <= cursor) || (len(page) > limit && id >= page[0].Id) {
+				continue
+			}
+
+			if e.expired(now) || !filter.match(e.link()) {
+				continue
+			}
+
+			heap.Push(&page, ListedLink{Id: id, Link: e.link()})
+
+			// Keep one link past the page, to tell whether there is a next page.
+			if len(page) > limit+1 {
+				heap.Pop(&page)
+			}
+		}
+
+		shard.mu.Unlock()
+	}
+
+	more := len(page) > limit
+
+	if more {
+		heap.Pop(&page)
+	}
+
+	links := make([]ListedLink, len(page))
+
+	for i := len(links) - 1; i >= 0; i-- {
+		links[i] = heap.Pop(&page).(ListedLink)
+	}
+
+	if !more {
+		return links, "", nil
+	}
+
+	return links, links[limit-1].Id, nil
+}
+
+// A max-heap of listed links by ID, holding the links with the lowest IDs seen while listing.
+type listedLinkHeap []ListedLink
+
+func (h listedLinkHeap) Len() int           { return len(h) }
+func (h listedLinkHeap) Less(i, j int) bool { return h[i].Id > h[j].Id }
+func (h listedLinkHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *listedLinkHeap) Push(x any)        { *h = append(*h, x.(ListedLink)) }
+
+func (h *listedLinkHeap) Pop() any {
+	old := *h
+	link := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return link
+}
+
+// Change the destination of a link in the memory store, keeping the previous one in its history.
+// Returns ErrVersionMismatch if the link is not at the expected version.
+func (s *MemoryStore) UpdateLink(ctx context.Context, id string, update LinkUpdate) (Link, error) {
+	shard := s.shard(id)
+	now := time.Now()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	e, ok := shard.entries[id]
+
+	if !ok || e.expired(now) {
+		return Link{}, ErrNil
+	}
+
+	if e.trashed() {
+		return Link{}, ErrDeleted
+	}
+
+	if update.Version != 0 && update.Version != e.version() {
+		return Link{}, ErrVersionMismatch
+	}
+
+	revision := Revision{Version: e.version(), URL: e.URL, ChangedBy: update.Author, ChangedAt: now}
+
+	if err := s.append(memoryEvent{Op: memoryOpUpdate, Id: id, URL: update.URL, Revision: &revision}); err != nil {
+		return Link{}, err
+	}
+
+	e.update(update.URL, revision)
+
+	return e.link(), nil
+}
+
+// Get the past destinations of a link in the memory store, oldest first.
+func (s *MemoryStore) LinkHistory(ctx context.Context, id string) ([]Revision, error) {
+	shard := s.shard(id)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	e, ok := shard.entries[id]
+
+	if !ok || e.expired(time.Now()) {
+		return nil, ErrNil
+	}
+
+	return append([]Revision{}, e.History...), nil
+}
+
+// Get the visits to a link in the buckets of the given granularity starting within [from, to), ordered by
+// start. Buckets without visits are left out.
+func (s *MemoryStore) LinkStats(ctx context.Context, id string, granularity Granularity, from, to time.Time) ([]StatsBucket, error) {
+	shard := s.shard(id)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	e, ok := shard.entries[id]
+
+	if !ok || e.expired(time.Now()) {
+		return []StatsBucket{}, nil
+	}
+
+	return collectStats(e.Stats, granularity, from, to), nil
+}
+
+// Count the details of a visit in the breakdowns and unique visitors of a link. Visits to links that do not
+// exist are ignored.
+func (s *MemoryStore) RecordVisit(ctx context.Context, id string, visit Visit) error {
+	shard := s.shard(id)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	e, ok := shard.entries[id]
+
+	if !ok || e.expired(time.Now()) {
+		return nil
+	}
+
+	if err := s.append(memoryEvent{Op: memoryOpRecord, Id: id, Visit: &visit}); err != nil {
+		return err
+	}
+
+	e.record(visit)
+
+	return nil
+}
+
+// Get the most common values of every dimension of the visits to a link, up to top of them, ordered by their
+// visits, most first, and then by value.
+func (s *MemoryStore) LinkBreakdowns(ctx context.Context, id string, top int) (map[Dimension][]BreakdownEntry, error) {
+	shard := s.shard(id)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	e, ok := shard.entries[id]
+
+	if !ok || e.expired(time.Now()) {
+		return topBreakdowns(nil, top), nil
+	}
+
+	return topBreakdowns(e.Breakdowns, top), nil
+}
+
+// Move a link to the trash, where it no longer redirects until it is restored or purged.
+// Returns ErrDeleted if the link is already in the trash.
+func (s *MemoryStore) TrashLink(ctx context.Context, id string) error {
+	shard := s.shard(id)
+	now := time.Now()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	e, ok := shard.entries[id]
+
+	if !ok || e.expired(now) {
+		return ErrNil
+	}
+
+	if e.trashed() {
+		return ErrDeleted
+	}
+
+	if err := s.append(memoryEvent{Op: memoryOpTrash, Id: id, At: now}); err != nil {
+		return err
+	}
+
+	e.DeletedAt = now
+
+	return nil
+}
+
+// Take a link out of the trash, refreshing its expiration unless it is fixed, in which case a link whose
+// expiration passed while in the trash expires right away. Returns ErrNil if the link is not in the trash.
+func (s *MemoryStore) RestoreLink(ctx context.Context, id string) error {
+	shard := s.shard(id)
+	now := time.Now()
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	e, ok := shard.entries[id]
+
+	if !ok || !e.trashed() {
+		return ErrNil
+	}
+
+	if err := s.append(memoryEvent{Op: memoryOpRestore, Id: id, At: now}); err != nil {
+		return err
+	}
+
+	e.DeletedAt = time.Time{}
+	e.touch(now)
+
+	return nil
+}
+
+// Get the links in the trash, oldest first.
+func (s *MemoryStore) ListTrash(ctx context.Context) ([]TrashedLink, error) {
+	now := time.Now()
+	links := make([]TrashedLink, 0)
+
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+
+		for id, e := range shard.entries {
+			if e.trashed() && !e.expired(now) {
+				links = append(links, TrashedLink{Id: id, URL: e.URL, DeletedAt: e.DeletedAt})
+			}
+		}
+
+		shard.mu.Unlock()
+	}
+
+	slices.SortFunc(links, func(a, b TrashedLink) int {
+		return a.DeletedAt.Compare(b.DeletedAt)
+	})
+
+	return links, nil
+}
+
+// Permanently delete the links moved to the trash at or before the given time, one shard at a time,
+// returning how many were deleted.
+func (s *MemoryStore) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	var n int
+
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+
+		for id, e := range shard.entries {
+			if !e.trashed() || e.DeletedAt.After(before) {
+				continue
+			}
+
+			if err := s.append(memoryEvent{Op: memoryOpDelete, Id: id}); err != nil {
+				shard.mu.Unlock()
+				return n, err
+			}
+
+			delete(shard.entries, id)
+			n++
+		}
+
+		shard.mu.Unlock()
+	}
+
+	return n, nil
+}
+
+// Delete a link and its visit count from the memory store.
+func (s *MemoryStore) DeleteLink(ctx context.Context, id string) error {
+	shard := s.shard(id)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if _, ok := shard.entries[id]; !ok {
+		return nil
+	}
+
+	if err := s.append(memoryEvent{Op: memoryOpDelete, Id: id}); err != nil {
+		return err
+	}
+
+	delete(shard.entries, id)
+
+	return nil
+}
+
+// Get the next number in the store's sequence.
+func (s *MemoryStore) NextSequence(ctx context.Context) (uint64, error) {
+	s.counterMu.Lock()
+	defer s.counterMu.Unlock()
+
+	n := s.counter + 1
+
+	if err := s.append(memoryEvent{Op: memoryOpSequence, Value: n}); err != nil {
+		return 0, err
+	}
+
+	s.counter = n
+
+	return n, nil
+}
+
+// Return the number of links held by the store, including expired links not yet purged.
+func (s *MemoryStore) Len() int {
+	var n int
+
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		n += len(shard.entries)
+		shard.mu.Unlock()
+	}
+
+	return n
+}
+
+// Get the shard responsible for the given link ID.
+func (s *MemoryStore) shard(id string) *memoryShard {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+// Periodically purge expired links, sync and compact the log until the store is closed.
+func (s *MemoryStore) janitor() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.JanitorInterval)
+	defer ticker.Stop()
+
+	var syncs, compactions <-chan time.Time
+
+	if s.Dir != "" {
+		compactTicker := time.NewTicker(s.CompactInterval)
+		defer compactTicker.Stop()
+
+		compactions = compactTicker.C
+
+		if s.Sync == SyncEverySecond {
+			syncTicker := time.NewTicker(time.Second)
+			defer syncTicker.Stop()
+
+			syncs = syncTicker.C
+		}
+	}
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.purgeExpired(now)
+			s.pruneIndex()
+		case <-syncs:
+			if err := s.syncLog(); err != nil {
+				log.Printf("memory store: sync failed: %v", err)
+			}
+		case <-compactions:
+			if err := s.Compact(); err != nil {
+				log.Printf("memory store: compaction failed: %v", err)
+			}
+		}
+	}
+}
+
+// Remove index entries whose links have been deleted, expired or replaced.
+func (s *MemoryStore) pruneIndex() {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	for url, id := range s.index {
+		if _, ok := s.indexedVisits(id, url); !ok {
+			delete(s.index, url)
+		}
+	}
+}
+
+// Remove all links that have expired at the given time, one shard at a time.
+func (s *MemoryStore) purgeExpired(now time.Time) {
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+
+		for id, e := range shard.entries {
+			if e.expired(now) {
+				delete(shard.entries, id)
+			}
+		}
+
+		shard.mu.Unlock()
+	}
+}
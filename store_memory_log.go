@@ -0,0 +1,437 @@
+package shrinkmyurl
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const (
+	memoryLogFile      = "links.aof"
+	memoryOldLogFile   = "links.aof.old"
+	memorySnapshotFile = "links.snapshot"
+
+	// Upper bound on the size of a single log line, which holds at most one link.
+	memoryMaxLineSize = 1 << 20
+)
+
+// How often the memory store's append-only log is synced to disk.
+type SyncPolicy int
+
+const (
+	// Sync the log once per second, losing at most a second of writes on power loss.
+	SyncEverySecond SyncPolicy = iota
+	// Sync the log after every write.
+	SyncAlways
+	// Never sync the log, leaving it to the operating system.
+	SyncNever
+)
+
+// Parse a sync policy from its name: always, everysec or never.
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	switch name {
+	case "always":
+		return SyncAlways, nil
+	case "everysec":
+		return SyncEverySecond, nil
+	case "never":
+		return SyncNever, nil
+	}
+
+	return 0, fmt.Errorf("unknown sync policy: %s", name)
+}
+
+// Operations recorded in the append-only log.
+const (
+	memoryOpSnapshot = "snapshot"
+	memoryOpAdd      = "add"
+	memoryOpVisit    = "visit"
+	memoryOpRecord   = "record"
+	memoryOpUpdate   = "update"
+	memoryOpTrash    = "trash"
+	memoryOpRestore  = "restore"
+	memoryOpDelete   = "delete"
+	memoryOpSequence = "sequence"
+)
+
+// An event in the append-only log or snapshot.
+// Events carry a sequence number so that replay can skip events already captured by the snapshot.
+type memoryEvent struct {
+	Seq   uint64       `json:"seq"`
+	Op    string       `json:"op"`
+	Id    string       `json:"id,omitempty"`
+	At    time.Time    `json:"at,omitempty"`
+	Value uint64       `json:"value,omitempty"`
+	Entry *memoryEntry `json:"entry,omitempty"`
+	// The new destination and the revision it replaced, for updates.
+	URL      string    `json:"url,omitempty"`
+	Revision *Revision `json:"revision,omitempty"`
+	// The details of a visit, for records.
+	Visit *Visit `json:"visit,omitempty"`
+}
+
+// Load the snapshot and logs from the store directory, then compact them.
+func (s *MemoryStore) restore() error {
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+
+	seq, err := s.replay(filepath.Join(s.Dir, memorySnapshotFile), 0)
+
+	if err != nil {
+		return err
+	}
+
+	s.seq = seq
+
+	for _, name := range []string{memoryOldLogFile, memoryLogFile} {
+		last, err := s.replay(filepath.Join(s.Dir, name), seq)
+
+		if err != nil {
+			return err
+		}
+
+		s.seq = max(s.seq, last)
+	}
+
+	s.purgeExpired(time.Now())
+
+	for _, shard := range s.shards {
+		for id, e := range shard.entries {
+			if e.Indexed {
+				s.index[e.URL] = id
+			}
+		}
+	}
+
+	s.aof, err = os.OpenFile(filepath.Join(s.Dir, memoryLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+
+	if err != nil {
+		return err
+	}
+
+	return s.Compact()
+}
+
+// Apply the events in the given file to the store, skipping those at or below the given sequence number.
+// Returns the highest sequence number seen. A missing file is treated as empty, and a truncated final
+// line, left by a crash during a write, is ignored.
+func (s *MemoryStore) replay(path string, after uint64) (uint64, error) {
+	f, err := os.Open(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return after, nil
+	} else if err != nil {
+		return after, err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), memoryMaxLineSize)
+
+	last := after
+	var pending error
+
+	for scanner.Scan() {
+		if pending != nil {
+			return last, pending
+		}
+
+		var event memoryEvent
+
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			pending = fmt.Errorf("%s: %w", path, err)
+			continue
+		}
+
+		last = max(last, event.Seq)
+
+		if event.Op == memoryOpSnapshot {
+			s.counter = max(s.counter, event.Value)
+		}
+
+		if event.Op == memoryOpSnapshot || event.Seq <= after {
+			continue
+		}
+
+		s.apply(event)
+	}
+
+	return last, scanner.Err()
+}
+
+// Apply a replayed event to the store.
+func (s *MemoryStore) apply(event memoryEvent) {
+	if event.Op == memoryOpSequence {
+		s.counter = max(s.counter, event.Value)
+		return
+	}
+
+	shard := s.shard(event.Id)
+
+	switch event.Op {
+	case memoryOpAdd:
+		// Expired entries are kept until the logs are replayed, as they may have been moved to the trash since.
+		if event.Entry != nil {
+			shard.entries[event.Id] = event.Entry
+		}
+	case memoryOpVisit:
+		if e, ok := shard.entries[event.Id]; ok {
+			e.visit(event.At, statsRetention(s.StatsRetention))
+		}
+	case memoryOpRecord:
+		if e, ok := shard.entries[event.Id]; ok && event.Visit != nil {
+			e.record(*event.Visit)
+		}
+	case memoryOpUpdate:
+		if e, ok := shard.entries[event.Id]; ok && event.Revision != nil {
+			e.update(event.URL, *event.Revision)
+		}
+	case memoryOpTrash:
+		if e, ok := shard.entries[event.Id]; ok {
+			e.DeletedAt = event.At
+		}
+	case memoryOpRestore:
+		if e, ok := shard.entries[event.Id]; ok {
+			e.DeletedAt = time.Time{}
+
+			if !event.At.IsZero() {
+				e.touch(event.At)
+			}
+		}
+	case memoryOpDelete:
+		delete(shard.entries, event.Id)
+	}
+}
+
+// Append an event to the log, if persistence is enabled. Must be called while holding the lock
+// of the shard the event applies to, so that the log order matches the order of changes.
+func (s *MemoryStore) append(event memoryEvent) error {
+	if s.Dir == "" {
+		return nil
+	}
+
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+
+	if s.aof == nil {
+		return ErrClosed
+	}
+
+	s.seq++
+	event.Seq = s.seq
+
+	data, err := json.Marshal(event)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.aof.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	if s.Sync == SyncAlways {
+		return s.aof.Sync()
+	}
+
+	return nil
+}
+
+// Sync the log to disk.
+func (s *MemoryStore) syncLog() error {
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+
+	if s.aof == nil {
+		return nil
+	}
+
+	return s.aof.Sync()
+}
+
+// Compact the append-only log into a snapshot of the current links.
+// Writes continue while the snapshot is written, and are appended to a fresh log.
+func (s *MemoryStore) Compact() error {
+	if s.Dir == "" {
+		return nil
+	}
+
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	events, seq, counter, err := s.rotate()
+
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.Dir, memorySnapshotFile)
+
+	if err := writeSnapshot(path, seq, counter, events); err != nil {
+		return err
+	}
+
+	err = os.Remove(filepath.Join(s.Dir, memoryOldLogFile))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// Capture the current links, log sequence number and counter, moving the log aside so new events start
+// a fresh one. All shards and the counter are locked so that the capture is consistent with the log.
+func (s *MemoryStore) rotate() ([]memoryEvent, uint64, uint64, error) {
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		defer shard.mu.Unlock()
+	}
+
+	s.counterMu.Lock()
+	defer s.counterMu.Unlock()
+
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+
+	if s.aof == nil {
+		return nil, 0, 0, ErrClosed
+	}
+
+	now := time.Now()
+	events := make([]memoryEvent, 0)
+
+	for _, shard := range s.shards {
+		for id, e := range shard.entries {
+			if e.expired(now) {
+				continue
+			}
+
+			entry := *e
+			entry.Stats = maps.Clone(e.Stats)
+			entry.Breakdowns = maps.Clone(e.Breakdowns)
+			entry.Visitors = slices.Clone(e.Visitors)
+			events = append(events, memoryEvent{Op: memoryOpAdd, Id: id, Entry: &entry})
+		}
+	}
+
+	if err := s.aof.Close(); err != nil {
+		return nil, 0, 0, err
+	}
+
+	current := filepath.Join(s.Dir, memoryLogFile)
+	old := filepath.Join(s.Dir, memoryOldLogFile)
+
+	// A previous compaction failed before its snapshot was written, so keep its events.
+	if _, err := os.Stat(old); err == nil {
+		if err := appendFile(old, current); err != nil {
+			return nil, 0, 0, err
+		}
+	} else if err := os.Rename(current, old); err != nil {
+		return nil, 0, 0, err
+	}
+
+	aof, err := os.OpenFile(current, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0o644)
+
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	s.aof = aof
+
+	return events, s.seq, s.counter, nil
+}
+
+// Atomically write a snapshot of the given events and counter, replacing any existing snapshot.
+func writeSnapshot(path string, seq, counter uint64, events []memoryEvent) error {
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+
+	if err := enc.Encode(memoryEvent{Seq: seq, Op: memoryOpSnapshot, Value: counter}); err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		event.Seq = seq
+
+		if err := enc.Encode(event); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// Append the contents of one file to another.
+func appendFile(dst, src string) error {
+	in, err := os.Open(src)
+
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND, 0o644)
+
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+
+	return out.Sync()
+}
+
+// Sync a directory so that renames within it are durable.
+func syncDir(path string) error {
+	d, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,404 @@
+package shrinkmyurl_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	shrink "github.com/derek-schaefer/shrink-my-url"
+	"github.com/derek-schaefer/shrink-my-url/storetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := shrink.Store(shrink.Must(shrink.NewMemoryStore(shrink.MemoryStoreOptions{})))
+
+	assert.Nil(t, store.Close())
+	assert.Nil(t, store.Ping(context.Background()))
+
+	ok, err := store.AddLink(context.Background(), "id", "url")
+
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	link, visits, err := store.ExpandLink(context.Background(), "id")
+
+	assert.Equal(t, "url", link)
+	assert.Equal(t, int64(1), visits)
+	assert.Nil(t, err)
+}
+
+func TestMemoryStoreConformance(t *testing.T) {
+	storetest.Run(t, func() shrink.Store {
+		return shrink.Must(shrink.NewMemoryStore(shrink.MemoryStoreOptions{}))
+	})
+
+	storetest.RunExpiration(t, func(expiration time.Duration) shrink.Store {
+		return shrink.Must(shrink.NewMemoryStore(shrink.MemoryStoreOptions{Expiration: expiration}))
+	})
+}
+
+func TestMemoryStorePersistentConformance(t *testing.T) {
+	storetest.Run(t, func() shrink.Store {
+		return shrink.Must(shrink.NewMemoryStore(shrink.MemoryStoreOptions{
+			Dir:  t.TempDir(),
+			Sync: shrink.SyncAlways,
+		}))
+	})
+}
+
+func TestMemoryStoreAddLinkTTL(t *testing.T) {
+	store := shrink.Must(shrink.NewMemoryStore(shrink.MemoryStoreOptions{Expiration: time.Minute}))
+
+	defer store.Close()
+
+	shrink.Must(store.AddLinkTTL(context.Background(), "short", "url", 50*time.Millisecond))
+	shrink.Must(store.AddLinkTTL(context.Background(), "forever", "url", 0))
+
+	time.Sleep(100 * time.Millisecond)
+
+	_, _, err := store.ExpandLink(context.Background(), "short")
+
+	assert.Equal(t, shrink.ErrNil, err)
+
+	link, visits, err := store.ExpandLink(context.Background(), "forever")
+
+	assert.Equal(t, "url", link)
+	assert.Equal(t, int64(1), visits)
+	assert.Nil(t, err)
+}
+
+func TestMemoryStoreListLinksPages(t *testing.T) {
+	store := shrink.Must(shrink.NewMemoryStore(shrink.MemoryStoreOptions{}))
+
+	defer store.Close()
+
+	var ids []string
+
+	for i := 24; i >= 0; i-- {
+		id := fmt.Sprintf("link-%02d", i)
+		ids = append([]string{id}, ids...)
+
+		shrink.Must(store.AddLink(context.Background(), id, "url"))
+	}
+
+	var listed []string
+	cursor := ""
+
+	for {
+		links, next, err := store.ListLinks(context.Background(), cursor, 7, shrink.LinkFilter{})
+
+		assert.Nil(t, err)
+		assert.LessOrEqual(t, len(links), 7)
+
+		for _, link := range links {
+			listed = append(listed, link.Id)
+		}
+
+		if next == "" {
+			break
+		}
+
+		cursor = next
+	}
+
+	assert.Equal(t, ids, listed, "pages must list every link once, ordered by ID")
+}
+
+func TestMemoryStoreJanitor(t *testing.T) {
+	store := shrink.Must(shrink.NewMemoryStore(shrink.MemoryStoreOptions{
+		Expiration:      20 * time.Millisecond,
+		JanitorInterval: 10 * time.Millisecond,
+	}))
+
+	defer store.Close()
+
+	shrink.Must(store.AddLink(context.Background(), "a", "url"))
+	shrink.Must(store.AddLink(context.Background(), "b", "url"))
+
+	assert.Equal(t, 2, store.Len())
+
+	assert.Eventually(t, func() bool {
+		return store.Len() == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMemoryStoreClose(t *testing.T) {
+	store := shrink.Must(shrink.NewMemoryStore(shrink.MemoryStoreOptions{}))
+
+	assert.Nil(t, store.Close())
+	assert.Nil(t, store.Close())
+}
+
+func TestMemoryStorePersistence(t *testing.T) {
+	ops := shrink.MemoryStoreOptions{Dir: t.TempDir(), Sync: shrink.SyncNever}
+
+	store := shrink.Must(shrink.NewMemoryStore(ops))
+
+	shrink.Must(store.AddLink(context.Background(), "a", "url-a"))
+	shrink.Must(store.AddLink(context.Background(), "b", "url-b"))
+	store.ExpandLink(context.Background(), "a")
+	store.ExpandLink(context.Background(), "a")
+
+	assert.Nil(t, store.DeleteLink(context.Background(), "b"))
+	assert.Nil(t, store.Close())
+
+	store = shrink.Must(shrink.NewMemoryStore(ops))
+
+	defer store.Close()
+
+	link, visits, err := store.ExpandLink(context.Background(), "a")
+
+	assert.Equal(t, "url-a", link)
+	assert.Equal(t, int64(3), visits)
+	assert.Nil(t, err)
+
+	_, _, err = store.ExpandLink(context.Background(), "b")
+
+	assert.Equal(t, shrink.ErrNil, err)
+}
+
+func TestMemoryStoreCompact(t *testing.T) {
+	ops := shrink.MemoryStoreOptions{Dir: t.TempDir()}
+
+	store := shrink.Must(shrink.NewMemoryStore(ops))
+
+	shrink.Must(store.AddLink(context.Background(), "a", "url-a"))
+	store.ExpandLink(context.Background(), "a")
+
+	assert.Nil(t, store.Compact())
+
+	info := shrink.Must(os.Stat(filepath.Join(ops.Dir, "links.aof")))
+
+	assert.Equal(t, int64(0), info.Size())
+
+	shrink.Must(store.AddLink(context.Background(), "b", "url-b"))
+	store.ExpandLink(context.Background(), "a")
+
+	assert.Nil(t, store.Close())
+
+	store = shrink.Must(shrink.NewMemoryStore(ops))
+
+	defer store.Close()
+
+	_, visits, err := store.ExpandLink(context.Background(), "a")
+
+	assert.Equal(t, int64(3), visits)
+	assert.Nil(t, err)
+
+	link, _, err := store.ExpandLink(context.Background(), "b")
+
+	assert.Equal(t, "url-b", link)
+	assert.Nil(t, err)
+}
+
+func TestMemoryStoreNextSequencePersistence(t *testing.T) {
+	ops := shrink.MemoryStoreOptions{Dir: t.TempDir()}
+
+	store := shrink.Must(shrink.NewMemoryStore(ops))
+
+	assert.Equal(t, uint64(1), shrink.Must(store.NextSequence(context.Background())))
+	assert.Nil(t, store.Compact())
+	assert.Equal(t, uint64(2), shrink.Must(store.NextSequence(context.Background())))
+	assert.Nil(t, store.Close())
+
+	store = shrink.Must(shrink.NewMemoryStore(ops))
+
+	defer store.Close()
+
+	assert.Equal(t, uint64(3), shrink.Must(store.NextSequence(context.Background())))
+}
+
+func TestMemoryStoreIndexPersistence(t *testing.T) {
+	ops := shrink.MemoryStoreOptions{Dir: t.TempDir()}
+
+	store := shrink.Must(shrink.NewMemoryStore(ops))
+
+	store.AddOrGetLink(context.Background(), "a", "url")
+
+	assert.Nil(t, store.Close())
+
+	store = shrink.Must(shrink.NewMemoryStore(ops))
+
+	defer store.Close()
+
+	id, _, err := store.AddOrGetLink(context.Background(), "b", "url")
+
+	assert.Equal(t, "a", id)
+	assert.Nil(t, err)
+}
+
+func TestMemoryStoreUpdatePersistence(t *testing.T) {
+	ops := shrink.MemoryStoreOptions{Dir: t.TempDir()}
+
+	store := shrink.Must(shrink.NewMemoryStore(ops))
+
+	shrink.Must(store.AddLink(context.Background(), "a", "url-a"))
+
+	_, err := store.UpdateLink(context.Background(), "a", shrink.LinkUpdate{URL: "url-b", Author: "admin"})
+
+	assert.Nil(t, err)
+	assert.Nil(t, store.Close())
+
+	store = shrink.Must(shrink.NewMemoryStore(ops))
+
+	defer store.Close()
+
+	link, err := store.GetLink(context.Background(), "a")
+
+	assert.Equal(t, "url-b", link.URL)
+	assert.Equal(t, int64(2), link.Version)
+	assert.Nil(t, err)
+
+	history, err := store.LinkHistory(context.Background(), "a")
+
+	assert.Len(t, history, 1)
+	assert.Equal(t, "url-a", history[0].URL)
+	assert.Equal(t, "admin", history[0].ChangedBy)
+	assert.Nil(t, err)
+}
+
+func TestMemoryStoreTrashPersistence(t *testing.T) {
+	ops := shrink.MemoryStoreOptions{Dir: t.TempDir()}
+
+	store := shrink.Must(shrink.NewMemoryStore(ops))
+
+	shrink.Must(store.AddLink(context.Background(), "a", "url-a"))
+	shrink.Must(store.AddLink(context.Background(), "b", "url-b"))
+
+	assert.Nil(t, store.TrashLink(context.Background(), "a"))
+	assert.Nil(t, store.TrashLink(context.Background(), "b"))
+	assert.Nil(t, store.RestoreLink(context.Background(), "b"))
+	assert.Nil(t, store.Close())
+
+	store = shrink.Must(shrink.NewMemoryStore(ops))
+
+	defer store.Close()
+
+	_, _, err := store.ExpandLink(context.Background(), "a")
+
+	assert.Equal(t, shrink.ErrDeleted, err)
+
+	_, _, err = store.ExpandLink(context.Background(), "b")
+
+	assert.Nil(t, err)
+}
+
+func TestMemoryStoreTrashExpirationPersistence(t *testing.T) {
+	ops := shrink.MemoryStoreOptions{Dir: t.TempDir(), Expiration: 50 * time.Millisecond}
+
+	store := shrink.Must(shrink.NewMemoryStore(ops))
+
+	shrink.Must(store.AddLink(context.Background(), "a", "url-a"))
+
+	assert.Nil(t, store.TrashLink(context.Background(), "a"))
+	assert.Nil(t, store.Close())
+
+	time.Sleep(100 * time.Millisecond)
+
+	store = shrink.Must(shrink.NewMemoryStore(ops))
+
+	defer store.Close()
+
+	_, _, err := store.ExpandLink(context.Background(), "a")
+
+	assert.Equal(t, shrink.ErrDeleted, err, "links in the trash must outlive their expiration across restarts")
+	assert.Nil(t, store.RestoreLink(context.Background(), "a"))
+
+	_, _, err = store.ExpandLink(context.Background(), "a")
+
+	assert.Nil(t, err)
+}
+
+func TestMemoryStoreRecordVisitPersistence(t *testing.T) {
+	ops := shrink.MemoryStoreOptions{Dir: t.TempDir()}
+
+	store := shrink.Must(shrink.NewMemoryStore(ops))
+
+	shrink.Must(store.AddLink(context.Background(), "a", "url-a"))
+
+	assert.Nil(t, store.RecordVisit(context.Background(), "a", shrink.Visit{Visitor: "x", Browser: "Firefox"}))
+	assert.Nil(t, store.Compact())
+	assert.Nil(t, store.RecordVisit(context.Background(), "a", shrink.Visit{Visitor: "y", Browser: "Firefox"}))
+	assert.Nil(t, store.Close())
+
+	store = shrink.Must(shrink.NewMemoryStore(ops))
+
+	defer store.Close()
+
+	breakdowns, err := store.LinkBreakdowns(context.Background(), "a", 10)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []shrink.BreakdownEntry{{Value: "Firefox", Visits: 2}}, breakdowns[shrink.ByBrowser])
+
+	link, err := store.GetLink(context.Background(), "a")
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), link.UniqueVisitors)
+}
+
+func TestMemoryStoreStatsRetention(t *testing.T) {
+	ops := shrink.MemoryStoreOptions{Dir: t.TempDir(), StatsRetention: 7 * 24 * time.Hour}
+	now := time.Now()
+
+	events := []string{
+		`{"seq":1,"op":"add","id":"a","entry":{"url":"url-a"}}`,
+		fmt.Sprintf(`{"seq":2,"op":"visit","id":"a","at":%q}`, now.AddDate(0, 0, -10).Format(time.RFC3339)),
+		fmt.Sprintf(`{"seq":3,"op":"visit","id":"a","at":%q}`, now.AddDate(0, 0, -1).Format(time.RFC3339)),
+		fmt.Sprintf(`{"seq":4,"op":"visit","id":"a","at":%q}`, now.Format(time.RFC3339)),
+	}
+
+	assert.Nil(t, os.WriteFile(filepath.Join(ops.Dir, "links.aof"), []byte(strings.Join(events, "\n")+"\n"), 0o644))
+
+	store := shrink.Must(shrink.NewMemoryStore(ops))
+
+	defer store.Close()
+
+	link := shrink.Must(store.GetLink(context.Background(), "a"))
+
+	assert.Equal(t, int64(3), link.Visits)
+
+	buckets, err := store.LinkStats(context.Background(), "a", shrink.Daily, now.AddDate(0, 0, -30), now.Add(time.Hour))
+
+	assert.Nil(t, err)
+	assert.Len(t, buckets, 2, "visits older than the retention are dropped")
+	assert.Equal(t, int64(1), buckets[0].Visits)
+	assert.Equal(t, int64(1), buckets[1].Visits)
+}
+
+func TestMemoryStoreTruncatedLog(t *testing.T) {
+	ops := shrink.MemoryStoreOptions{Dir: t.TempDir()}
+
+	store := shrink.Must(shrink.NewMemoryStore(ops))
+
+	shrink.Must(store.AddLink(context.Background(), "a", "url-a"))
+
+	assert.Nil(t, store.Close())
+
+	f := shrink.Must(os.OpenFile(filepath.Join(ops.Dir, "links.aof"), os.O_WRONLY|os.O_APPEND, 0o644))
+	shrink.Must(f.WriteString(`{"seq":99,"op":"add","id":"b"`))
+	f.Close()
+
+	store = shrink.Must(shrink.NewMemoryStore(ops))
+
+	defer store.Close()
+
+	link, _, err := store.ExpandLink(context.Background(), "a")
+
+	assert.Equal(t, "url-a", link)
+	assert.Nil(t, err)
+}
+
+func TestParseSyncPolicy(t *testing.T) {
+	assert.Equal(t, shrink.SyncAlways, shrink.Must(shrink.ParseSyncPolicy("always")))
+	assert.Equal(t, shrink.SyncEverySecond, shrink.Must(shrink.ParseSyncPolicy("everysec")))
+	assert.Equal(t, shrink.SyncNever, shrink.Must(shrink.ParseSyncPolicy("never")))
+
+	_, err := shrink.ParseSyncPolicy("sometimes")
+
+	assert.NotNil(t, err)
+}
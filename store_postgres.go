@@ -0,0 +1,1059 @@
+package shrinkmyurl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+const defaultPostgresJanitorInterval = time.Minute
+
+// Schema migrations for the PostgreSQL store, applied in order at startup.
+var postgresMigrations = []migration{
+	{
+		version: 1,
+		stmts: []string{
+			`CREATE TABLE links (
+				id TEXT PRIMARY KEY,
+				url TEXT NOT NULL,
+				visits BIGINT NOT NULL DEFAULT 0,
+				expires_at TIMESTAMPTZ
+			)`,
+			`CREATE INDEX links_expires_at ON links (expires_at)`,
+		},
+	},
+	{
+		version: 2,
+		stmts: []string{
+			`CREATE SEQUENCE link_sequence`,
+		},
+	},
+	{
+		version: 3,
+		stmts: []string{
+			// A NULL TTL follows the store's expiration, and zero means the expiration is fixed.
+			`ALTER TABLE links ADD COLUMN ttl INTERVAL, ADD COLUMN max_visits BIGINT`,
+		},
+	},
+	{
+		version: 4,
+		stmts: []string{
+			`ALTER TABLE links ADD COLUMN password_hash TEXT`,
+		},
+	},
+	{
+		version: 5,
+		stmts: []string{
+			`ALTER TABLE links ADD COLUMN not_before TIMESTAMPTZ`,
+			`ALTER TABLE links ADD COLUMN not_after TIMESTAMPTZ`,
+		},
+	},
+	{
+		version: 6,
+		stmts: []string{
+			`ALTER TABLE links ADD COLUMN version BIGINT NOT NULL DEFAULT 1`,
+			`CREATE TABLE link_revisions (
+				id TEXT NOT NULL,
+				version BIGINT NOT NULL,
+				url TEXT NOT NULL,
+				changed_by TEXT NOT NULL,
+				changed_at TIMESTAMPTZ NOT NULL,
+				PRIMARY KEY (id, version)
+			)`,
+		},
+	},
+	{
+		version: 7,
+		stmts: []string{
+			`ALTER TABLE links ADD COLUMN token_hash TEXT`,
+		},
+	},
+	{
+		version: 8,
+		stmts: []string{
+			`ALTER TABLE links ADD COLUMN deleted_at TIMESTAMPTZ`,
+			`CREATE INDEX links_deleted_at ON links (deleted_at)`,
+		},
+	},
+	{
+		version: 9,
+		stmts: []string{
+			`CREATE TABLE link_stats (
+				id TEXT NOT NULL,
+				granularity TEXT NOT NULL,
+				start TIMESTAMPTZ NOT NULL,
+				visits BIGINT NOT NULL,
+				PRIMARY KEY (id, granularity, start)
+			)`,
+		},
+	},
+	{
+		version: 10,
+		stmts: []string{
+			`CREATE TABLE link_breakdowns (
+				id TEXT NOT NULL,
+				dimension TEXT NOT NULL,
+				value TEXT NOT NULL,
+				visits BIGINT NOT NULL,
+				PRIMARY KEY (id, dimension, value)
+			)`,
+		},
+	},
+	{
+		version: 11,
+		stmts: []string{
+			`ALTER TABLE links ADD COLUMN visitors BYTEA`,
+			`ALTER TABLE links ADD COLUMN unique_visitors BIGINT NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 12,
+		stmts: []string{
+			// Links in the trash do not expire, and keep the expiration they are restored with here.
+			`ALTER TABLE links ADD COLUMN trashed_expires_at TIMESTAMPTZ`,
+		},
+	},
+}
+
+// Options for the PostgreSQL store.
+type PostgresStoreOptions struct {
+	URL        string
+	Expiration time.Duration
+	// How long visit statistics are kept. Defaults to 90 days.
+	StatsRetention time.Duration
+	// How often expired links are purged. Defaults to one minute.
+	JanitorInterval time.Duration
+}
+
+// A Store implementation that uses PostgreSQL.
+// Expired links are purged by a background janitor.
+type PostgresStore struct {
+	PostgresStoreOptions
+
+	db *sql.DB
+
+	done chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// Create a new PostgreSQL store with the given options, applying any pending migrations, and start its janitor.
+func NewPostgresStore(ops PostgresStoreOptions) (*PostgresStore, error) {
+	db, err := sql.Open("pgx", ops.URL)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrate(context.Background(), db, postgresMigrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if ops.JanitorInterval <= 0 {
+		ops.JanitorInterval = defaultPostgresJanitorInterval
+	}
+
+	s := &PostgresStore{PostgresStoreOptions: ops, db: db, done: make(chan struct{})}
+
+	s.wg.Add(1)
+	go s.janitor()
+
+	return s, nil
+}
+
+// Ping the PostgreSQL store.
+func (s *PostgresStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close the PostgreSQL store, stopping its janitor.
+func (s *PostgresStore) Close() error {
+	var err error
+
+	s.once.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+
+		err = s.db.Close()
+	})
+
+	return err
+}
+
+// Add a link to the store with the configured expiration, replacing an expired link with the same ID.
+// Returns true if the link was successfully added, or false if it was not.
+func (s *PostgresStore) AddLink(ctx context.Context, id, url string) (bool, error) {
+	if err := s.CreateLink(ctx, id, Link{URL: url}); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Add a link to the store with its own settings, replacing an expired link with the same ID.
+// Returns ErrExists if the ID is taken.
+func (s *PostgresStore) CreateLink(ctx context.Context, id string, link Link) error {
+	now := time.Now()
+	ttl, expiresAt := link.expiration(now, s.Expiration)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM links WHERE id = $1 AND expires_at <= $2", id, now)
+
+	if err != nil {
+		return err
+	}
+
+	var customTTL, maxVisits, passwordHash, tokenHash, expires, notBefore, notAfter any
+
+	if link.customExpiration() {
+		customTTL = ttl.Microseconds()
+	}
+
+	if link.MaxVisits > 0 {
+		maxVisits = link.MaxVisits
+	}
+
+	if link.PasswordHash != "" {
+		passwordHash = link.PasswordHash
+	}
+
+	if link.TokenHash != "" {
+		tokenHash = link.TokenHash
+	}
+
+	if !link.NotBefore.IsZero() {
+		notBefore = link.NotBefore
+	}
+
+	if !link.NotAfter.IsZero() {
+		notAfter = link.NotAfter
+	}
+
+	if !expiresAt.IsZero() {
+		expires = expiresAt
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO links (id, url, visits, expires_at, ttl, max_visits, password_hash, not_before, not_after, token_hash)
+		VALUES ($1, $2, 0, $3, $4::BIGINT * INTERVAL '1 microsecond', $5, $6, $7, $8, $9)`,
+		id, link.URL, expires, customTTL, maxVisits, passwordHash, notBefore, notAfter, tokenHash,
+	)
+
+	if err != nil {
+		return NormalizeError(err)
+	}
+
+	// Revisions, stats and breakdowns left by an expired link with the same ID do not belong to the new link.
+	if _, err = tx.ExecContext(ctx, "DELETE FROM link_revisions WHERE id = $1", id); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM link_stats WHERE id = $1", id); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM link_breakdowns WHERE id = $1", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Expand a shortened link from the store with the number of visits, incrementing the visit count.
+// The visit is counted and the expiration refreshed atomically in a single statement, unless the
+// expiration is fixed. Returns ErrDeleted if the link is in the trash, ErrNotActive or ErrExpired if it is
+// outside its activation window, ErrProtected if it is password protected, or ErrGone if it reached its max visits.
+func (s *PostgresStore) ExpandLink(ctx context.Context, id string) (string, int64, error) {
+	return s.ExpandProtectedLink(ctx, id, "")
+}
+
+// Expand a password protected link, provided its password hash is still the given one.
+func (s *PostgresStore) ExpandProtectedLink(ctx context.Context, id, passwordHash string) (string, int64, error) {
+	now := time.Now()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	defer tx.Rollback()
+
+	var link string
+	var visits int64
+
+	err = tx.QueryRowContext(
+		ctx,
+		`UPDATE links SET visits = visits + 1,
+			expires_at = CASE WHEN ttl IS NULL THEN $1::TIMESTAMPTZ WHEN ttl > INTERVAL '0' THEN $3::TIMESTAMPTZ + ttl ELSE expires_at END
+		WHERE id = $2 AND (expires_at IS NULL OR expires_at > $3) AND (max_visits IS NULL OR visits < max_visits)
+			AND COALESCE(password_hash, '') = $4 AND (not_before IS NULL OR not_before <= $3) AND (not_after IS NULL OR not_after > $3)
+			AND deleted_at IS NULL
+		RETURNING url, visits`,
+		s.expiresAt(now), id, now, passwordHash,
+	).Scan(&link, &visits)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		// Release the transaction's connection before looking up why.
+		tx.Rollback()
+		return "", 0, s.missing(ctx, id, passwordHash, now)
+	} else if err != nil {
+		return "", 0, NormalizeError(err)
+	}
+
+	if err := s.countVisit(ctx, tx, id, now); err != nil {
+		return "", 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", 0, err
+	}
+
+	return link, visits, nil
+}
+
+// Count a visit to a link without expanding it, refreshing its expiration unless it is fixed. Visits to links
+// that do not exist are ignored.
+func (s *PostgresStore) CountVisit(ctx context.Context, id string) error {
+	now := time.Now()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(
+		ctx,
+		`UPDATE links SET visits = visits + 1,
+			expires_at = CASE WHEN deleted_at IS NOT NULL THEN expires_at WHEN ttl IS NULL THEN $1::TIMESTAMPTZ WHEN ttl > INTERVAL '0' THEN $3::TIMESTAMPTZ + ttl ELSE expires_at END
+		WHERE id = $2 AND (expires_at IS NULL OR expires_at > $3)`,
+		s.expiresAt(now), id, now,
+	)
+
+	if err != nil {
+		return NormalizeError(err)
+	}
+
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+
+	if err := s.countVisit(ctx, tx, id, now); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Count a visit to a link at the given time in its stats, dropping the buckets that started before the
+// retention whenever a new one starts.
+func (s *PostgresStore) countVisit(ctx context.Context, tx *sql.Tx, id string, now time.Time) error {
+	cutoff := now.Add(-statsRetention(s.StatsRetention))
+
+	for _, g := range granularities {
+		var visits int64
+
+		err := tx.QueryRowContext(
+			ctx,
+			`INSERT INTO link_stats (id, granularity, start, visits) VALUES ($1, $2, $3, 1)
+			ON CONFLICT (id, granularity, start) DO UPDATE SET visits = link_stats.visits + 1
+			RETURNING visits`,
+			id, string(g), g.bucket(now),
+		).Scan(&visits)
+
+		if err != nil {
+			return err
+		}
+
+		if visits > 1 {
+			continue
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			"DELETE FROM link_stats WHERE id = $1 AND granularity = $2 AND start < $3",
+			id, string(g), cutoff,
+		)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get a link from the store with the number of visits, without counting a visit.
+func (s *PostgresStore) GetLink(ctx context.Context, id string) (Link, error) {
+	var link Link
+	var expiresAt, notBefore, notAfter, deletedAt sql.NullTime
+	var ttl, maxVisits sql.NullInt64
+	var passwordHash, tokenHash sql.NullString
+
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT url, visits, expires_at, (EXTRACT(EPOCH FROM ttl) * 1000000)::BIGINT, max_visits, password_hash,
+			not_before, not_after, version, token_hash, deleted_at, unique_visitors
+		FROM links WHERE id = $1 AND (expires_at IS NULL OR expires_at > $2)`,
+		id, time.Now(),
+	).Scan(
+		&link.URL, &link.Visits, &expiresAt, &ttl, &maxVisits, &passwordHash, &notBefore, &notAfter, &link.Version,
+		&tokenHash, &deletedAt, &link.UniqueVisitors,
+	)
+
+	if err != nil {
+		return Link{}, NormalizeError(err)
+	}
+
+	link.TTL = s.Expiration
+	link.ExpiresAt = expiresAt.Time
+	link.MaxVisits = maxVisits.Int64
+	link.PasswordHash = passwordHash.String
+	link.TokenHash = tokenHash.String
+	link.NotBefore = notBefore.Time
+	link.NotAfter = notAfter.Time
+	link.DeletedAt = deletedAt.Time
+
+	if ttl.Valid {
+		link.TTL = time.Duration(ttl.Int64) * time.Microsecond
+		link.Absolute = ttl.Int64 == 0 && expiresAt.Valid
+	}
+
+	return link, nil
+}
+
+// Get the error for a link that could not be expanded with the given password hash: ErrNotActive or
+// ErrExpired if it is outside its activation window, ErrProtected if the hash does not match, ErrGone if
+// it reached its max visits, or ErrNil if it does not exist.
+func (s *PostgresStore) missing(ctx context.Context, id, passwordHash string, now time.Time) error {
+	var protected, gone, deleted bool
+	var notBefore, notAfter sql.NullTime
+
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT COALESCE(password_hash, '') != $1, COALESCE(visits >= max_visits, FALSE), not_before, not_after,
+			deleted_at IS NOT NULL
+		FROM links WHERE id = $2 AND (expires_at IS NULL OR expires_at > $3)`,
+		passwordHash, id, now,
+	).Scan(&protected, &gone, &notBefore, &notAfter, &deleted)
+
+	if err != nil {
+		return NormalizeError(err)
+	}
+
+	if deleted {
+		return ErrDeleted
+	}
+
+	if err := activationError(notBefore.Time, notAfter.Time, now); err != nil {
+		return err
+	}
+
+	return unexpandableError(protected, gone)
+}
+
+// Get a page of at most limit links from the store with their visit counts, ordered by ID, starting after
+// the ID in the cursor, and the cursor of the next page, which is empty after the last page.
+// Returns ErrInvalidLimit if the limit is not positive.
+func (s *PostgresStore) ListLinks(ctx context.Context, cursor string, limit int, filter LinkFilter) ([]ListedLink, string, error) {
+	if limit <= 0 {
+		return nil, "", ErrInvalidLimit
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id FROM links
+		WHERE id > $1 AND (expires_at IS NULL OR expires_at > $2) AND ($3 = '' OR strpos(lower(url), lower($3)) > 0)
+			AND ($4 OR deleted_at IS NULL)
+		ORDER BY id LIMIT $5`,
+		cursor, time.Now(), filter.URL, filter.IncludeDeleted, limit+1,
+	)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	var ids []string
+
+	for rows.Next() {
+		var id string
+
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, "", err
+		}
+
+		ids = append(ids, id)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+
+	if len(ids) > limit {
+		ids = ids[:limit]
+		next = ids[limit-1]
+	}
+
+	links := make([]ListedLink, 0, len(ids))
+
+	for _, id := range ids {
+		link, err := s.GetLink(ctx, id)
+
+		if err == ErrNil {
+			continue
+		} else if err != nil {
+			return nil, "", err
+		}
+
+		links = append(links, ListedLink{Id: id, Link: link})
+	}
+
+	return links, next, nil
+}
+
+// Change the destination of a link, keeping the previous one in its history.
+// Returns ErrVersionMismatch if the link is not at the expected version.
+func (s *PostgresStore) UpdateLink(ctx context.Context, id string, update LinkUpdate) (Link, error) {
+	now := time.Now()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return Link{}, err
+	}
+
+	defer tx.Rollback()
+
+	var url string
+	var version int64
+	var deletedAt sql.NullTime
+
+	err = tx.QueryRowContext(
+		ctx,
+		"SELECT url, version, deleted_at FROM links WHERE id = $1 AND (expires_at IS NULL OR expires_at > $2)",
+		id, now,
+	).Scan(&url, &version, &deletedAt)
+
+	if err != nil {
+		return Link{}, NormalizeError(err)
+	}
+
+	if deletedAt.Valid {
+		return Link{}, ErrDeleted
+	}
+
+	if update.Version != 0 && update.Version != version {
+		return Link{}, ErrVersionMismatch
+	}
+
+	result, err := tx.ExecContext(
+		ctx,
+		"UPDATE links SET url = $1, version = version + 1 WHERE id = $2 AND version = $3",
+		update.URL, id, version,
+	)
+
+	if err != nil {
+		return Link{}, err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return Link{}, err
+	} else if n == 0 {
+		return Link{}, ErrVersionMismatch
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO link_revisions (id, version, url, changed_by, changed_at) VALUES ($1, $2, $3, $4, $5)",
+		id, version, url, update.Author, now,
+	)
+
+	if err != nil {
+		return Link{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Link{}, err
+	}
+
+	return s.GetLink(ctx, id)
+}
+
+// Get the past destinations of a link, oldest first.
+func (s *PostgresStore) LinkHistory(ctx context.Context, id string) ([]Revision, error) {
+	if _, err := s.GetLink(ctx, id); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT version, url, changed_by, changed_at FROM link_revisions WHERE id = $1 ORDER BY version",
+		id,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	revisions := make([]Revision, 0)
+
+	for rows.Next() {
+		var r Revision
+
+		if err := rows.Scan(&r.Version, &r.URL, &r.ChangedBy, &r.ChangedAt); err != nil {
+			return nil, err
+		}
+
+		revisions = append(revisions, r)
+	}
+
+	return revisions, rows.Err()
+}
+
+// Get the visits to a link in the buckets of the given granularity starting within [from, to), ordered by
+// start. Buckets without visits are left out.
+func (s *PostgresStore) LinkStats(ctx context.Context, id string, granularity Granularity, from, to time.Time) ([]StatsBucket, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT start, visits FROM link_stats
+		WHERE id = $1 AND granularity = $2 AND start >= $3 AND start < $4 ORDER BY start`,
+		id, string(granularity), from, to,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	buckets := make([]StatsBucket, 0)
+
+	for rows.Next() {
+		var bucket StatsBucket
+		var start time.Time
+
+		if err := rows.Scan(&start, &bucket.Visits); err != nil {
+			return nil, err
+		}
+
+		bucket.Start = start.UTC()
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets, rows.Err()
+}
+
+// Count the details of a visit in the breakdowns and unique visitors of a link. Visits to links that do not
+// exist are ignored.
+func (s *PostgresStore) RecordVisit(ctx context.Context, id string, visit Visit) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var visitors []byte
+
+	err = tx.QueryRowContext(
+		ctx,
+		"SELECT visitors FROM links WHERE id = $1 AND (expires_at IS NULL OR expires_at > $2)",
+		id, time.Now(),
+	).Scan(&visitors)
+
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, d := range dimensions {
+		value, err := s.breakdownValue(ctx, tx, id, d, visit.value(d))
+
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO link_breakdowns (id, dimension, value, visits) VALUES ($1, $2, $3, 1)
+			ON CONFLICT (id, dimension, value) DO UPDATE SET visits = link_breakdowns.visits + 1`,
+			id, string(d), value,
+		)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if sketch := hyperLogLog(visitors); visit.Visitor != "" && sketch.add(visit.Visitor) {
+		_, err := tx.ExecContext(
+			ctx,
+			"UPDATE links SET visitors = $1, unique_visitors = $2 WHERE id = $3",
+			[]byte(sketch), sketch.count(), id,
+		)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Get the value to count a visit under in a dimension of a link, which is OtherValue for a new value once the
+// dimension holds the most values it can.
+func (s *PostgresStore) breakdownValue(ctx context.Context, tx *sql.Tx, id string, d Dimension, value string) (string, error) {
+	var counted bool
+	var values int
+
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT EXISTS (SELECT 1 FROM link_breakdowns WHERE id = $1 AND dimension = $2 AND value = $3),
+			(SELECT COUNT(*) FROM link_breakdowns WHERE id = $1 AND dimension = $2)`,
+		id, string(d), value,
+	).Scan(&counted, &values)
+
+	if err != nil {
+		return "", err
+	}
+
+	return breakdownValue(value, counted, values), nil
+}
+
+// Get the most common values of every dimension of the visits to a link, up to top of them, ordered by their
+// visits, most first, and then by value.
+func (s *PostgresStore) LinkBreakdowns(ctx context.Context, id string, top int) (map[Dimension][]BreakdownEntry, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT dimension, value, visits FROM (
+			SELECT dimension, value, visits, ROW_NUMBER() OVER (PARTITION BY dimension ORDER BY visits DESC, value) AS n
+			FROM link_breakdowns WHERE id = $1
+		) ranked
+		WHERE n <= $2 ORDER BY dimension, n`,
+		id, top,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	breakdowns := topBreakdowns(nil, top)
+
+	for rows.Next() {
+		var d Dimension
+		var entry BreakdownEntry
+
+		if err := rows.Scan(&d, &entry.Value, &entry.Visits); err != nil {
+			return nil, err
+		}
+
+		if entries, ok := breakdowns[d]; ok {
+			breakdowns[d] = append(entries, entry)
+		}
+	}
+
+	return breakdowns, rows.Err()
+}
+
+// Move a link to the trash, where it no longer redirects until it is restored or purged.
+// Returns ErrDeleted if the link is already in the trash.
+func (s *PostgresStore) TrashLink(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE links SET deleted_at = $1, trashed_expires_at = expires_at, expires_at = NULL
+		WHERE id = $2 AND (expires_at IS NULL OR expires_at > $1) AND deleted_at IS NULL`,
+		time.Now(), id,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return s.untrashable(ctx, id)
+	}
+
+	return nil
+}
+
+// Get the error for a link that could not be moved to the trash: ErrDeleted if it is already in the trash,
+// or ErrNil if it does not exist.
+func (s *PostgresStore) untrashable(ctx context.Context, id string) error {
+	if _, err := s.GetLink(ctx, id); err != nil {
+		return err
+	}
+
+	return ErrDeleted
+}
+
+// Take a link out of the trash, refreshing its expiration unless it is fixed, in which case a link whose
+// expiration passed while in the trash expires right away. Returns ErrNil if the link is not in the trash.
+func (s *PostgresStore) RestoreLink(ctx context.Context, id string) error {
+	now := time.Now()
+
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE links SET deleted_at = NULL, trashed_expires_at = NULL,
+			expires_at = CASE WHEN ttl IS NULL THEN $1::TIMESTAMPTZ WHEN ttl > INTERVAL '0' THEN $3::TIMESTAMPTZ + ttl ELSE trashed_expires_at END
+		WHERE id = $2 AND (expires_at IS NULL OR expires_at > $3) AND deleted_at IS NOT NULL`,
+		s.expiresAt(now), id, now,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNil
+	}
+
+	return nil
+}
+
+// Get the links in the trash, oldest first.
+func (s *PostgresStore) ListTrash(ctx context.Context) ([]TrashedLink, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, url, deleted_at FROM links
+		WHERE deleted_at IS NOT NULL AND (expires_at IS NULL OR expires_at > $1) ORDER BY deleted_at`,
+		time.Now(),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	links := make([]TrashedLink, 0)
+
+	for rows.Next() {
+		var link TrashedLink
+
+		if err := rows.Scan(&link.Id, &link.URL, &link.DeletedAt); err != nil {
+			return nil, err
+		}
+
+		links = append(links, link)
+	}
+
+	return links, rows.Err()
+}
+
+// Permanently delete the links moved to the trash at or before the given time with their history, stats and
+// breakdowns, returning how many were deleted.
+func (s *PostgresStore) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		"DELETE FROM link_revisions WHERE id IN (SELECT id FROM links WHERE deleted_at <= $1)",
+		before,
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"DELETE FROM link_stats WHERE id IN (SELECT id FROM links WHERE deleted_at <= $1)",
+		before,
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"DELETE FROM link_breakdowns WHERE id IN (SELECT id FROM links WHERE deleted_at <= $1)",
+		before,
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM links WHERE deleted_at <= $1", before)
+
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), tx.Commit()
+}
+
+// Permanently delete the links that expired at or before the given time with their history, stats and
+// breakdowns, returning how many were deleted.
+func (s *PostgresStore) PurgeExpired(ctx context.Context, before time.Time) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		"DELETE FROM link_revisions WHERE id IN (SELECT id FROM links WHERE expires_at <= $1)",
+		before,
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"DELETE FROM link_stats WHERE id IN (SELECT id FROM links WHERE expires_at <= $1)",
+		before,
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"DELETE FROM link_breakdowns WHERE id IN (SELECT id FROM links WHERE expires_at <= $1)",
+		before,
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM links WHERE expires_at <= $1", before)
+
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), tx.Commit()
+}
+
+// Purge expired links on every interval until the store is closed.
+func (s *PostgresStore) janitor() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.JanitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			if _, err := s.PurgeExpired(context.Background(), now); err != nil {
+				log.Printf("postgres store: purge failed: %v", err)
+			}
+		}
+	}
+}
+
+// Delete a link, its visit count, history, stats and breakdowns from the store.
+func (s *PostgresStore) DeleteLink(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM links WHERE id = $1", id); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM link_revisions WHERE id = $1", id); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM link_stats WHERE id = $1", id); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM link_breakdowns WHERE id = $1", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Get the next number in the store's sequence.
+func (s *PostgresStore) NextSequence(ctx context.Context) (uint64, error) {
+	var n uint64
+
+	err := s.db.QueryRowContext(ctx, "SELECT nextval('link_sequence')").Scan(&n)
+
+	return n, err
+}
+
+// Get the expiration time for a link written at the given time, or nil if links do not expire.
+func (s *PostgresStore) expiresAt(now time.Time) any {
+	if s.Expiration <= 0 {
+		return nil
+	}
+
+	return now.Add(s.Expiration)
+}
+
+// Report whether the error is a PostgreSQL unique violation.
+func isPostgresUniqueViolation(err error) bool {
+	var perr *pgconn.PgError
+
+	return errors.As(err, &perr) && perr.Code == "23505"
+}
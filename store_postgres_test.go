@@ -0,0 +1,131 @@
+package shrinkmyurl_test
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	shrink "github.com/derek-schaefer/shrink-my-url"
+	"github.com/derek-schaefer/shrink-my-url/storetest"
+	"github.com/stretchr/testify/assert"
+)
+
+// Get the test database URL from the POSTGRES_URL environment variable, skipping the test if it is unset.
+func postgresURL(t *testing.T) string {
+	url := os.Getenv("POSTGRES_URL")
+
+	if url == "" {
+		t.Skip("POSTGRES_URL is not set")
+	}
+
+	return url
+}
+
+func newTestPostgresStore(t *testing.T, expiration time.Duration) *shrink.PostgresStore {
+	return shrink.Must(shrink.NewPostgresStore(shrink.PostgresStoreOptions{
+		URL:        postgresURL(t),
+		Expiration: expiration,
+	}))
+}
+
+func TestPostgresStoreConformance(t *testing.T) {
+	url := postgresURL(t)
+
+	storetest.Run(t, func() shrink.Store {
+		return shrink.Must(shrink.NewPostgresStore(shrink.PostgresStoreOptions{
+			URL:        url,
+			Expiration: time.Minute,
+		}))
+	})
+
+	storetest.RunExpiration(t, func(expiration time.Duration) shrink.Store {
+		return shrink.Must(shrink.NewPostgresStore(shrink.PostgresStoreOptions{
+			URL:        url,
+			Expiration: expiration,
+		}))
+	})
+}
+
+func TestPostgresStoreClose(t *testing.T) {
+	store := newTestPostgresStore(t, time.Minute)
+
+	assert.Nil(t, store.Close())
+}
+
+func TestPostgresStorePing(t *testing.T) {
+	store := newTestPostgresStore(t, time.Minute)
+
+	defer store.Close()
+
+	assert.Nil(t, store.Ping(context.Background()))
+}
+
+func TestPostgresStoreAddLink(t *testing.T) {
+	store := newTestPostgresStore(t, time.Minute)
+
+	defer store.Close()
+	defer store.DeleteLink(context.Background(), "id")
+
+	ok, err := store.AddLink(context.Background(), "id", "url")
+
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	ok, err = store.AddLink(context.Background(), "id", "url")
+
+	assert.False(t, ok)
+	assert.Equal(t, shrink.ErrExists, err)
+}
+
+func TestPostgresStoreExpandLink(t *testing.T) {
+	store := newTestPostgresStore(t, time.Minute)
+
+	defer store.Close()
+	defer store.DeleteLink(context.Background(), "id")
+
+	shrink.Must(store.AddLink(context.Background(), "id", "url"))
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			store.ExpandLink(context.Background(), "id")
+		}()
+	}
+
+	wg.Wait()
+
+	link, visits, err := store.ExpandLink(context.Background(), "id")
+
+	assert.Equal(t, "url", link)
+	assert.Equal(t, int64(11), visits)
+	assert.Nil(t, err)
+
+	_, _, err = store.ExpandLink(context.Background(), "missing")
+
+	assert.Equal(t, shrink.ErrNil, err)
+}
+
+func TestPostgresStoreDeleteLink(t *testing.T) {
+	store := newTestPostgresStore(t, time.Minute)
+
+	defer store.Close()
+	defer store.DeleteLink(context.Background(), "id")
+
+	shrink.Must(store.AddLink(context.Background(), "id", "url"))
+
+	err := store.DeleteLink(context.Background(), "id")
+
+	assert.Nil(t, err)
+
+	link, visits, err := store.ExpandLink(context.Background(), "id")
+
+	assert.Equal(t, "", link)
+	assert.Equal(t, int64(0), visits)
+	assert.Equal(t, shrink.ErrNil, err)
+}
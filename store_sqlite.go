@@ -0,0 +1,1088 @@
+package shrinkmyurl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const defaultSQLiteJanitorInterval = time.Minute
+
+// Schema migrations for the SQLite store, applied in order at startup.
+var sqliteMigrations = []migration{
+	{
+		version: 1,
+		stmts: []string{
+			`CREATE TABLE links (
+				id TEXT PRIMARY KEY,
+				url TEXT NOT NULL,
+				visits INTEGER NOT NULL DEFAULT 0,
+				expires_at INTEGER
+			)`,
+			`CREATE INDEX links_expires_at ON links (expires_at)`,
+		},
+	},
+	{
+		version: 2,
+		stmts: []string{
+			`CREATE TABLE sequences (name TEXT PRIMARY KEY, value INTEGER NOT NULL)`,
+			`INSERT INTO sequences (name, value) VALUES ('links', 0)`,
+		},
+	},
+	{
+		version: 3,
+		stmts: []string{
+			// A NULL TTL follows the store's expiration, and zero means the expiration is fixed.
+			`ALTER TABLE links ADD COLUMN ttl INTEGER`,
+			`ALTER TABLE links ADD COLUMN max_visits INTEGER`,
+		},
+	},
+	{
+		version: 4,
+		stmts: []string{
+			`ALTER TABLE links ADD COLUMN password_hash TEXT`,
+		},
+	},
+	{
+		version: 5,
+		stmts: []string{
+			`ALTER TABLE links ADD COLUMN not_before INTEGER`,
+			`ALTER TABLE links ADD COLUMN not_after INTEGER`,
+		},
+	},
+	{
+		version: 6,
+		stmts: []string{
+			`ALTER TABLE links ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+			`CREATE TABLE link_revisions (
+				id TEXT NOT NULL,
+				version INTEGER NOT NULL,
+				url TEXT NOT NULL,
+				changed_by TEXT NOT NULL,
+				changed_at INTEGER NOT NULL,
+				PRIMARY KEY (id, version)
+			)`,
+		},
+	},
+	{
+		version: 7,
+		stmts: []string{
+			`ALTER TABLE links ADD COLUMN token_hash TEXT`,
+		},
+	},
+	{
+		version: 8,
+		stmts: []string{
+			`ALTER TABLE links ADD COLUMN deleted_at INTEGER`,
+			`CREATE INDEX links_deleted_at ON links (deleted_at)`,
+		},
+	},
+	{
+		version: 9,
+		stmts: []string{
+			`CREATE TABLE link_stats (
+				id TEXT NOT NULL,
+				granularity TEXT NOT NULL,
+				start INTEGER NOT NULL,
+				visits INTEGER NOT NULL,
+				PRIMARY KEY (id, granularity, start)
+			)`,
+		},
+	},
+	{
+		version: 10,
+		stmts: []string{
+			`CREATE TABLE link_breakdowns (
+				id TEXT NOT NULL,
+				dimension TEXT NOT NULL,
+				value TEXT NOT NULL,
+				visits INTEGER NOT NULL,
+				PRIMARY KEY (id, dimension, value)
+			)`,
+		},
+	},
+	{
+		version: 11,
+		stmts: []string{
+			`ALTER TABLE links ADD COLUMN visitors BLOB`,
+			`ALTER TABLE links ADD COLUMN unique_visitors INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 12,
+		stmts: []string{
+			// Links in the trash do not expire, and keep the expiration they are restored with here.
+			`ALTER TABLE links ADD COLUMN trashed_expires_at INTEGER`,
+		},
+	},
+}
+
+// Options for the SQLite store.
+type SQLiteStoreOptions struct {
+	Path       string
+	Expiration time.Duration
+	// How long visit statistics are kept. Defaults to 90 days.
+	StatsRetention time.Duration
+	// How often expired links are purged. Defaults to one minute.
+	JanitorInterval time.Duration
+}
+
+// A Store implementation that uses an embedded SQLite database.
+// Expired links are purged by a background janitor.
+type SQLiteStore struct {
+	SQLiteStoreOptions
+
+	db *sql.DB
+
+	done chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// Create a new SQLite store with the given options, applying any pending migrations, and start its janitor.
+func NewSQLiteStore(ops SQLiteStoreOptions) (*SQLiteStore, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", ops.Path)
+
+	db, err := sql.Open("sqlite", dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer, and in-memory databases are per connection.
+	db.SetMaxOpenConns(1)
+
+	if err := migrate(context.Background(), db, sqliteMigrations); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if ops.JanitorInterval <= 0 {
+		ops.JanitorInterval = defaultSQLiteJanitorInterval
+	}
+
+	s := &SQLiteStore{SQLiteStoreOptions: ops, db: db, done: make(chan struct{})}
+
+	s.wg.Add(1)
+	go s.janitor()
+
+	return s, nil
+}
+
+// Ping the SQLite store.
+func (s *SQLiteStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close the SQLite store, stopping its janitor.
+func (s *SQLiteStore) Close() error {
+	var err error
+
+	s.once.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+
+		err = s.db.Close()
+	})
+
+	return err
+}
+
+// Add a link to the store with the configured expiration, replacing an expired link with the same ID.
+// Returns true if the link was successfully added, or false if it was not.
+func (s *SQLiteStore) AddLink(ctx context.Context, id, url string) (bool, error) {
+	if err := s.CreateLink(ctx, id, Link{URL: url}); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Add a link to the store with its own settings, replacing an expired link with the same ID.
+// Returns ErrExists if the ID is taken.
+func (s *SQLiteStore) CreateLink(ctx context.Context, id string, link Link) error {
+	now := time.Now()
+	ttl, expiresAt := link.expiration(now, s.Expiration)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM links WHERE id = ? AND expires_at <= ?", id, now.UnixNano())
+
+	if err != nil {
+		return err
+	}
+
+	var customTTL, maxVisits, passwordHash, tokenHash, expires, notBefore, notAfter any
+
+	if link.customExpiration() {
+		customTTL = int64(ttl)
+	}
+
+	if link.MaxVisits > 0 {
+		maxVisits = link.MaxVisits
+	}
+
+	if link.PasswordHash != "" {
+		passwordHash = link.PasswordHash
+	}
+
+	if link.TokenHash != "" {
+		tokenHash = link.TokenHash
+	}
+
+	if !link.NotBefore.IsZero() {
+		notBefore = link.NotBefore.UnixNano()
+	}
+
+	if !link.NotAfter.IsZero() {
+		notAfter = link.NotAfter.UnixNano()
+	}
+
+	if !expiresAt.IsZero() {
+		expires = expiresAt.UnixNano()
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO links (id, url, visits, expires_at, ttl, max_visits, password_hash, not_before, not_after, token_hash)
+		VALUES (?, ?, 0, ?, ?, ?, ?, ?, ?, ?)`,
+		id, link.URL, expires, customTTL, maxVisits, passwordHash, notBefore, notAfter, tokenHash,
+	)
+
+	if err != nil {
+		return NormalizeError(err)
+	}
+
+	// Revisions, stats and breakdowns left by an expired link with the same ID do not belong to the new link.
+	if _, err = tx.ExecContext(ctx, "DELETE FROM link_revisions WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM link_stats WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM link_breakdowns WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Expand a shortened link from the store with the number of visits, incrementing the visit count.
+// The expiration is refreshed on every visit, unless it is fixed. Returns ErrDeleted if the link is in the
+// trash, ErrNotActive or ErrExpired if it is outside its activation window, ErrProtected if it is password
+// protected, or ErrGone if it reached its max visits.
+func (s *SQLiteStore) ExpandLink(ctx context.Context, id string) (string, int64, error) {
+	return s.ExpandProtectedLink(ctx, id, "")
+}
+
+// Expand a password protected link, provided its password hash is still the given one.
+func (s *SQLiteStore) ExpandProtectedLink(ctx context.Context, id, passwordHash string) (string, int64, error) {
+	now := time.Now()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	defer tx.Rollback()
+
+	var link string
+	var visits int64
+
+	err = tx.QueryRowContext(
+		ctx,
+		`UPDATE links SET visits = visits + 1,
+			expires_at = CASE WHEN ttl IS NULL THEN ? WHEN ttl > 0 THEN ? + ttl ELSE expires_at END
+		WHERE id = ? AND (expires_at IS NULL OR expires_at > ?) AND (max_visits IS NULL OR visits < max_visits)
+			AND COALESCE(password_hash, '') = ? AND (not_before IS NULL OR not_before <= ?) AND (not_after IS NULL OR not_after > ?)
+			AND deleted_at IS NULL
+		RETURNING url, visits`,
+		s.expiresAt(now), now.UnixNano(), id, now.UnixNano(), passwordHash, now.UnixNano(), now.UnixNano(),
+	).Scan(&link, &visits)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		// Release the transaction's connection first, since the store may only have one.
+		tx.Rollback()
+		return "", 0, s.missing(ctx, id, passwordHash, now)
+	} else if err != nil {
+		return "", 0, NormalizeError(err)
+	}
+
+	if err := s.countVisit(ctx, tx, id, now); err != nil {
+		return "", 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", 0, err
+	}
+
+	return link, visits, nil
+}
+
+// Count a visit to a link without expanding it, refreshing its expiration unless it is fixed. Visits to links
+// that do not exist are ignored.
+func (s *SQLiteStore) CountVisit(ctx context.Context, id string) error {
+	now := time.Now()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(
+		ctx,
+		`UPDATE links SET visits = visits + 1,
+			expires_at = CASE WHEN deleted_at IS NOT NULL THEN expires_at WHEN ttl IS NULL THEN ? WHEN ttl > 0 THEN ? + ttl ELSE expires_at END
+		WHERE id = ? AND (expires_at IS NULL OR expires_at > ?)`,
+		s.expiresAt(now), now.UnixNano(), id, now.UnixNano(),
+	)
+
+	if err != nil {
+		return NormalizeError(err)
+	}
+
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+
+	if err := s.countVisit(ctx, tx, id, now); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Count a visit to a link at the given time in its stats, dropping the buckets that started before the
+// retention whenever a new one starts.
+func (s *SQLiteStore) countVisit(ctx context.Context, tx *sql.Tx, id string, now time.Time) error {
+	cutoff := now.Add(-statsRetention(s.StatsRetention))
+
+	for _, g := range granularities {
+		var visits int64
+
+		err := tx.QueryRowContext(
+			ctx,
+			`INSERT INTO link_stats (id, granularity, start, visits) VALUES (?, ?, ?, 1)
+			ON CONFLICT (id, granularity, start) DO UPDATE SET visits = link_stats.visits + 1
+			RETURNING visits`,
+			id, string(g), g.bucket(now).UnixNano(),
+		).Scan(&visits)
+
+		if err != nil {
+			return err
+		}
+
+		if visits > 1 {
+			continue
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			"DELETE FROM link_stats WHERE id = ? AND granularity = ? AND start < ?",
+			id, string(g), cutoff.UnixNano(),
+		)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get a link from the store with the number of visits, without counting a visit.
+func (s *SQLiteStore) GetLink(ctx context.Context, id string) (Link, error) {
+	var link Link
+	var expiresAt, ttl, maxVisits, notBefore, notAfter, deletedAt sql.NullInt64
+	var passwordHash, tokenHash sql.NullString
+
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT url, visits, expires_at, ttl, max_visits, password_hash, not_before, not_after, version, token_hash,
+			deleted_at, unique_visitors
+		FROM links WHERE id = ? AND (expires_at IS NULL OR expires_at > ?)`,
+		id, time.Now().UnixNano(),
+	).Scan(
+		&link.URL, &link.Visits, &expiresAt, &ttl, &maxVisits, &passwordHash, &notBefore, &notAfter, &link.Version,
+		&tokenHash, &deletedAt, &link.UniqueVisitors,
+	)
+
+	if err != nil {
+		return Link{}, NormalizeError(err)
+	}
+
+	link.TTL = s.Expiration
+	link.MaxVisits = maxVisits.Int64
+	link.PasswordHash = passwordHash.String
+	link.TokenHash = tokenHash.String
+	link.NotBefore = nullUnixNano(notBefore)
+	link.NotAfter = nullUnixNano(notAfter)
+	link.DeletedAt = nullUnixNano(deletedAt)
+
+	if expiresAt.Valid {
+		link.ExpiresAt = time.Unix(0, expiresAt.Int64)
+	}
+
+	if ttl.Valid {
+		link.TTL = time.Duration(ttl.Int64)
+		link.Absolute = ttl.Int64 == 0 && expiresAt.Valid
+	}
+
+	return link, nil
+}
+
+// Get the error for a link that could not be expanded with the given password hash: ErrDeleted if it is in
+// the trash, ErrNotActive or ErrExpired if it is outside its activation window, ErrProtected if the hash does not match, ErrGone if
+// it reached its max visits, or ErrNil if it does not exist.
+func (s *SQLiteStore) missing(ctx context.Context, id, passwordHash string, now time.Time) error {
+	var protected, gone, deleted bool
+	var notBefore, notAfter sql.NullInt64
+
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT COALESCE(password_hash, '') != ?, COALESCE(visits >= max_visits, FALSE), not_before, not_after,
+			deleted_at IS NOT NULL
+		FROM links WHERE id = ? AND (expires_at IS NULL OR expires_at > ?)`,
+		passwordHash, id, now.UnixNano(),
+	).Scan(&protected, &gone, &notBefore, &notAfter, &deleted)
+
+	if err != nil {
+		return NormalizeError(err)
+	}
+
+	if deleted {
+		return ErrDeleted
+	}
+
+	if err := activationError(nullUnixNano(notBefore), nullUnixNano(notAfter), now); err != nil {
+		return err
+	}
+
+	return unexpandableError(protected, gone)
+}
+
+// Get a page of at most limit links from the store with their visit counts, ordered by ID, starting after
+// the ID in the cursor, and the cursor of the next page, which is empty after the last page.
+// Returns ErrInvalidLimit if the limit is not positive.
+func (s *SQLiteStore) ListLinks(ctx context.Context, cursor string, limit int, filter LinkFilter) ([]ListedLink, string, error) {
+	if limit <= 0 {
+		return nil, "", ErrInvalidLimit
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id FROM links
+		WHERE id > ? AND (expires_at IS NULL OR expires_at > ?) AND (? = '' OR instr(lower(url), lower(?)) > 0)
+			AND (? OR deleted_at IS NULL)
+		ORDER BY id LIMIT ?`,
+		cursor, time.Now().UnixNano(), filter.URL, filter.URL, filter.IncludeDeleted, limit+1,
+	)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	var ids []string
+
+	for rows.Next() {
+		var id string
+
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, "", err
+		}
+
+		ids = append(ids, id)
+	}
+
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+
+	if len(ids) > limit {
+		ids = ids[:limit]
+		next = ids[limit-1]
+	}
+
+	links := make([]ListedLink, 0, len(ids))
+
+	for _, id := range ids {
+		link, err := s.GetLink(ctx, id)
+
+		if err == ErrNil {
+			continue
+		} else if err != nil {
+			return nil, "", err
+		}
+
+		links = append(links, ListedLink{Id: id, Link: link})
+	}
+
+	return links, next, nil
+}
+
+// Change the destination of a link, keeping the previous one in its history.
+// Returns ErrVersionMismatch if the link is not at the expected version.
+func (s *SQLiteStore) UpdateLink(ctx context.Context, id string, update LinkUpdate) (Link, error) {
+	now := time.Now()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return Link{}, err
+	}
+
+	defer tx.Rollback()
+
+	var url string
+	var version int64
+	var deletedAt sql.NullInt64
+
+	err = tx.QueryRowContext(
+		ctx,
+		"SELECT url, version, deleted_at FROM links WHERE id = ? AND (expires_at IS NULL OR expires_at > ?)",
+		id, now.UnixNano(),
+	).Scan(&url, &version, &deletedAt)
+
+	if err != nil {
+		return Link{}, NormalizeError(err)
+	}
+
+	if deletedAt.Valid {
+		return Link{}, ErrDeleted
+	}
+
+	if update.Version != 0 && update.Version != version {
+		return Link{}, ErrVersionMismatch
+	}
+
+	result, err := tx.ExecContext(
+		ctx,
+		"UPDATE links SET url = ?, version = version + 1 WHERE id = ? AND version = ?",
+		update.URL, id, version,
+	)
+
+	if err != nil {
+		return Link{}, err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return Link{}, err
+	} else if n == 0 {
+		return Link{}, ErrVersionMismatch
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO link_revisions (id, version, url, changed_by, changed_at) VALUES (?, ?, ?, ?, ?)",
+		id, version, url, update.Author, now.UnixNano(),
+	)
+
+	if err != nil {
+		return Link{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return Link{}, err
+	}
+
+	return s.GetLink(ctx, id)
+}
+
+// Get the past destinations of a link, oldest first.
+func (s *SQLiteStore) LinkHistory(ctx context.Context, id string) ([]Revision, error) {
+	if _, err := s.GetLink(ctx, id); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		"SELECT version, url, changed_by, changed_at FROM link_revisions WHERE id = ? ORDER BY version",
+		id,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	revisions := make([]Revision, 0)
+
+	for rows.Next() {
+		var r Revision
+		var changedAt int64
+
+		if err := rows.Scan(&r.Version, &r.URL, &r.ChangedBy, &changedAt); err != nil {
+			return nil, err
+		}
+
+		r.ChangedAt = time.Unix(0, changedAt)
+		revisions = append(revisions, r)
+	}
+
+	return revisions, rows.Err()
+}
+
+// Get the visits to a link in the buckets of the given granularity starting within [from, to), ordered by
+// start. Buckets without visits are left out.
+func (s *SQLiteStore) LinkStats(ctx context.Context, id string, granularity Granularity, from, to time.Time) ([]StatsBucket, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT start, visits FROM link_stats
+		WHERE id = ? AND granularity = ? AND start >= ? AND start < ? ORDER BY start`,
+		id, string(granularity), from.UnixNano(), to.UnixNano(),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	buckets := make([]StatsBucket, 0)
+
+	for rows.Next() {
+		var bucket StatsBucket
+		var start int64
+
+		if err := rows.Scan(&start, &bucket.Visits); err != nil {
+			return nil, err
+		}
+
+		bucket.Start = time.Unix(0, start).UTC()
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets, rows.Err()
+}
+
+// Count the details of a visit in the breakdowns and unique visitors of a link. Visits to links that do not
+// exist are ignored.
+func (s *SQLiteStore) RecordVisit(ctx context.Context, id string, visit Visit) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var visitors []byte
+
+	err = tx.QueryRowContext(
+		ctx,
+		"SELECT visitors FROM links WHERE id = ? AND (expires_at IS NULL OR expires_at > ?)",
+		id, time.Now().UnixNano(),
+	).Scan(&visitors)
+
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, d := range dimensions {
+		value, err := s.breakdownValue(ctx, tx, id, d, visit.value(d))
+
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT INTO link_breakdowns (id, dimension, value, visits) VALUES (?, ?, ?, 1)
+			ON CONFLICT (id, dimension, value) DO UPDATE SET visits = link_breakdowns.visits + 1`,
+			id, string(d), value,
+		)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if sketch := hyperLogLog(visitors); visit.Visitor != "" && sketch.add(visit.Visitor) {
+		_, err := tx.ExecContext(
+			ctx,
+			"UPDATE links SET visitors = ?, unique_visitors = ? WHERE id = ?",
+			[]byte(sketch), sketch.count(), id,
+		)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Get the value to count a visit under in a dimension of a link, which is OtherValue for a new value once the
+// dimension holds the most values it can.
+func (s *SQLiteStore) breakdownValue(ctx context.Context, tx *sql.Tx, id string, d Dimension, value string) (string, error) {
+	var counted bool
+	var values int
+
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT EXISTS (SELECT 1 FROM link_breakdowns WHERE id = ? AND dimension = ? AND value = ?),
+			(SELECT COUNT(*) FROM link_breakdowns WHERE id = ? AND dimension = ?)`,
+		id, string(d), value, id, string(d),
+	).Scan(&counted, &values)
+
+	if err != nil {
+		return "", err
+	}
+
+	return breakdownValue(value, counted, values), nil
+}
+
+// Get the most common values of every dimension of the visits to a link, up to top of them, ordered by their
+// visits, most first, and then by value.
+func (s *SQLiteStore) LinkBreakdowns(ctx context.Context, id string, top int) (map[Dimension][]BreakdownEntry, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT dimension, value, visits FROM (
+			SELECT dimension, value, visits, ROW_NUMBER() OVER (PARTITION BY dimension ORDER BY visits DESC, value) AS n
+			FROM link_breakdowns WHERE id = ?
+		) ranked
+		WHERE n <= ? ORDER BY dimension, n`,
+		id, top,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	breakdowns := topBreakdowns(nil, top)
+
+	for rows.Next() {
+		var d Dimension
+		var entry BreakdownEntry
+
+		if err := rows.Scan(&d, &entry.Value, &entry.Visits); err != nil {
+			return nil, err
+		}
+
+		if entries, ok := breakdowns[d]; ok {
+			breakdowns[d] = append(entries, entry)
+		}
+	}
+
+	return breakdowns, rows.Err()
+}
+
+// Move a link to the trash, where it no longer redirects until it is restored or purged.
+// Returns ErrDeleted if the link is already in the trash.
+func (s *SQLiteStore) TrashLink(ctx context.Context, id string) error {
+	now := time.Now()
+
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE links SET deleted_at = ?, trashed_expires_at = expires_at, expires_at = NULL
+		WHERE id = ? AND (expires_at IS NULL OR expires_at > ?) AND deleted_at IS NULL`,
+		now.UnixNano(), id, now.UnixNano(),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return s.untrashable(ctx, id)
+	}
+
+	return nil
+}
+
+// Get the error for a link that could not be moved to the trash: ErrDeleted if it is already in the trash,
+// or ErrNil if it does not exist.
+func (s *SQLiteStore) untrashable(ctx context.Context, id string) error {
+	if _, err := s.GetLink(ctx, id); err != nil {
+		return err
+	}
+
+	return ErrDeleted
+}
+
+// Take a link out of the trash, refreshing its expiration unless it is fixed, in which case a link whose
+// expiration passed while in the trash expires right away. Returns ErrNil if the link is not in the trash.
+func (s *SQLiteStore) RestoreLink(ctx context.Context, id string) error {
+	now := time.Now()
+
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE links SET deleted_at = NULL, trashed_expires_at = NULL,
+			expires_at = CASE WHEN ttl IS NULL THEN ? WHEN ttl > 0 THEN ? + ttl ELSE trashed_expires_at END
+		WHERE id = ? AND (expires_at IS NULL OR expires_at > ?) AND deleted_at IS NOT NULL`,
+		s.expiresAt(now), now.UnixNano(), id, now.UnixNano(),
+	)
+
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNil
+	}
+
+	return nil
+}
+
+// Get the links in the trash, oldest first.
+func (s *SQLiteStore) ListTrash(ctx context.Context) ([]TrashedLink, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, url, deleted_at FROM links
+		WHERE deleted_at IS NOT NULL AND (expires_at IS NULL OR expires_at > ?) ORDER BY deleted_at`,
+		time.Now().UnixNano(),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	links := make([]TrashedLink, 0)
+
+	for rows.Next() {
+		var link TrashedLink
+		var deletedAt int64
+
+		if err := rows.Scan(&link.Id, &link.URL, &deletedAt); err != nil {
+			return nil, err
+		}
+
+		link.DeletedAt = time.Unix(0, deletedAt)
+		links = append(links, link)
+	}
+
+	return links, rows.Err()
+}
+
+// Permanently delete the links moved to the trash at or before the given time with their history, stats and
+// breakdowns, returning how many were deleted.
+func (s *SQLiteStore) PurgeTrash(ctx context.Context, before time.Time) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		"DELETE FROM link_revisions WHERE id IN (SELECT id FROM links WHERE deleted_at <= ?)",
+		before.UnixNano(),
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"DELETE FROM link_stats WHERE id IN (SELECT id FROM links WHERE deleted_at <= ?)",
+		before.UnixNano(),
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"DELETE FROM link_breakdowns WHERE id IN (SELECT id FROM links WHERE deleted_at <= ?)",
+		before.UnixNano(),
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM links WHERE deleted_at <= ?", before.UnixNano())
+
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), tx.Commit()
+}
+
+// Permanently delete the links that expired at or before the given time with their history, stats and
+// breakdowns, returning how many were deleted.
+func (s *SQLiteStore) PurgeExpired(ctx context.Context, before time.Time) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
+		ctx,
+		"DELETE FROM link_revisions WHERE id IN (SELECT id FROM links WHERE expires_at <= ?)",
+		before.UnixNano(),
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"DELETE FROM link_stats WHERE id IN (SELECT id FROM links WHERE expires_at <= ?)",
+		before.UnixNano(),
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		"DELETE FROM link_breakdowns WHERE id IN (SELECT id FROM links WHERE expires_at <= ?)",
+		before.UnixNano(),
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM links WHERE expires_at <= ?", before.UnixNano())
+
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), tx.Commit()
+}
+
+// Purge expired links on every interval until the store is closed.
+func (s *SQLiteStore) janitor() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.JanitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			if _, err := s.PurgeExpired(context.Background(), now); err != nil {
+				log.Printf("sqlite store: purge failed: %v", err)
+			}
+		}
+	}
+}
+
+// Delete a link, its visit count, history, stats and breakdowns from the store.
+func (s *SQLiteStore) DeleteLink(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM links WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM link_revisions WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM link_stats WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM link_breakdowns WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Get the next number in the store's sequence.
+func (s *SQLiteStore) NextSequence(ctx context.Context) (uint64, error) {
+	var n uint64
+
+	err := s.db.QueryRowContext(ctx, "UPDATE sequences SET value = value + 1 WHERE name = 'links' RETURNING value").Scan(&n)
+
+	return n, err
+}
+
+// Get the expiration timestamp for a link written at the given time, or nil if links do not expire.
+func (s *SQLiteStore) expiresAt(now time.Time) any {
+	if s.Expiration <= 0 {
+		return nil
+	}
+
+	return now.Add(s.Expiration).UnixNano()
+}
+
+// Get the time from a nullable Unix nanosecond timestamp, where NULL is the zero time.
+func nullUnixNano(n sql.NullInt64) time.Time {
+	if !n.Valid {
+		return time.Time{}
+	}
+
+	return time.Unix(0, n.Int64)
+}
+
+// Report whether the error is a SQLite uniqueness violation.
+func isSQLiteConstraint(err error) bool {
+	var serr *sqlite.Error
+
+	if !errors.As(err, &serr) {
+		return false
+	}
+
+	return serr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY || serr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
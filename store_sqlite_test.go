@@ -0,0 +1,193 @@
+package shrinkmyurl_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	shrink "github.com/derek-schaefer/shrink-my-url"
+	"github.com/derek-schaefer/shrink-my-url/storetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSQLiteStore(t *testing.T, expiration time.Duration) *shrink.SQLiteStore {
+	return shrink.Must(shrink.NewSQLiteStore(shrink.SQLiteStoreOptions{
+		Path:       filepath.Join(t.TempDir(), "links.db"),
+		Expiration: expiration,
+	}))
+}
+
+func TestSQLiteStoreConformance(t *testing.T) {
+	storetest.Run(t, func() shrink.Store {
+		return newTestSQLiteStore(t, time.Minute)
+	})
+
+	storetest.RunExpiration(t, func(expiration time.Duration) shrink.Store {
+		return newTestSQLiteStore(t, expiration)
+	})
+}
+
+func TestSQLiteStoreClose(t *testing.T) {
+	store := newTestSQLiteStore(t, time.Minute)
+
+	assert.Nil(t, store.Close())
+}
+
+func TestSQLiteStorePing(t *testing.T) {
+	store := newTestSQLiteStore(t, time.Minute)
+
+	defer store.Close()
+
+	assert.Nil(t, store.Ping(context.Background()))
+}
+
+func TestSQLiteStoreMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.db")
+
+	store := shrink.Must(shrink.NewSQLiteStore(shrink.SQLiteStoreOptions{Path: path}))
+
+	shrink.Must(store.AddLink(context.Background(), "id", "url"))
+
+	assert.Nil(t, store.Close())
+
+	store = shrink.Must(shrink.NewSQLiteStore(shrink.SQLiteStoreOptions{Path: path}))
+
+	defer store.Close()
+
+	link, visits, err := store.ExpandLink(context.Background(), "id")
+
+	assert.Equal(t, "url", link)
+	assert.Equal(t, int64(1), visits)
+	assert.Nil(t, err)
+}
+
+func TestSQLiteStoreAddLink(t *testing.T) {
+	store := newTestSQLiteStore(t, time.Minute)
+
+	defer store.Close()
+
+	ok, err := store.AddLink(context.Background(), "id", "url")
+
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	ok, err = store.AddLink(context.Background(), "id", "url")
+
+	assert.False(t, ok)
+	assert.Equal(t, shrink.ErrExists, err)
+}
+
+func TestSQLiteStoreExpandLink(t *testing.T) {
+	store := newTestSQLiteStore(t, time.Minute)
+
+	defer store.Close()
+
+	ok, err := store.AddLink(context.Background(), "id", "url")
+
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	link, visits, err := store.ExpandLink(context.Background(), "id")
+
+	assert.Equal(t, "url", link)
+	assert.Equal(t, int64(1), visits)
+	assert.Nil(t, err)
+
+	link, visits, err = store.ExpandLink(context.Background(), "id")
+
+	assert.Equal(t, "url", link)
+	assert.Equal(t, int64(2), visits)
+	assert.Nil(t, err)
+
+	_, _, err = store.ExpandLink(context.Background(), "missing")
+
+	assert.Equal(t, shrink.ErrNil, err)
+}
+
+func TestSQLiteStoreExpiration(t *testing.T) {
+	store := newTestSQLiteStore(t, 50*time.Millisecond)
+
+	defer store.Close()
+
+	shrink.Must(store.AddLink(context.Background(), "id", "url"))
+
+	time.Sleep(100 * time.Millisecond)
+
+	_, _, err := store.ExpandLink(context.Background(), "id")
+
+	assert.Equal(t, shrink.ErrNil, err)
+
+	ok, err := store.AddLink(context.Background(), "id", "other")
+
+	assert.True(t, ok)
+	assert.Nil(t, err)
+}
+
+func TestSQLiteStoreDeleteLink(t *testing.T) {
+	store := newTestSQLiteStore(t, time.Minute)
+
+	defer store.Close()
+
+	ok, err := store.AddLink(context.Background(), "id", "url")
+
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	err = store.DeleteLink(context.Background(), "id")
+
+	assert.Nil(t, err)
+
+	link, visits, err := store.ExpandLink(context.Background(), "id")
+
+	assert.Equal(t, "", link)
+	assert.Equal(t, int64(0), visits)
+	assert.Equal(t, shrink.ErrNil, err)
+}
+
+func TestSQLiteStoreJanitor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.db")
+	store := shrink.Must(shrink.NewSQLiteStore(shrink.SQLiteStoreOptions{
+		Path:            path,
+		Expiration:      100 * time.Millisecond,
+		JanitorInterval: 50 * time.Millisecond,
+	}))
+
+	defer store.Close()
+
+	assert.True(t, shrink.Must(store.AddLink(context.Background(), "expiring", "http://example.com")))
+
+	_, err := store.UpdateLink(context.Background(), "expiring", shrink.LinkUpdate{URL: "http://example.org"})
+
+	assert.Nil(t, err)
+	assert.Nil(t, store.RecordVisit(context.Background(), "expiring", shrink.Visit{Browser: "Firefox"}))
+
+	_, _, err = store.ExpandLink(context.Background(), "expiring")
+
+	assert.Nil(t, err)
+
+	db := shrink.Must(sql.Open("sqlite", path))
+
+	defer db.Close()
+
+	count := func(table string) int {
+		var n int
+
+		assert.Nil(t, db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE id = 'expiring'").Scan(&n))
+
+		return n
+	}
+
+	for _, table := range []string{"links", "link_revisions", "link_stats", "link_breakdowns"} {
+		assert.Positive(t, count(table), table)
+	}
+
+	assert.Eventually(t, func() bool {
+		return count("links") == 0
+	}, time.Second, 50*time.Millisecond, "expired links are purged")
+
+	for _, table := range []string{"link_revisions", "link_stats", "link_breakdowns"} {
+		assert.Zero(t, count(table), "the history, stats and breakdowns of expired links are purged with them")
+	}
+}
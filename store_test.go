@@ -2,10 +2,13 @@ package shrinkmyurl_test
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
 	"testing"
 	"time"
 
 	shrink "github.com/derek-schaefer/shrink-my-url"
+	"github.com/derek-schaefer/shrink-my-url/storetest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -23,22 +26,16 @@ func newTestRedisStore() *testRedisStore {
 	}
 }
 
-func TestMemoryStore(t *testing.T) {
-	store := shrink.Store(shrink.NewMemoryStore())
+func TestRedisStoreConformance(t *testing.T) {
+	storetest.Run(t, func() shrink.Store {
+		return newTestRedisStore()
+	})
 
-	assert.Nil(t, store.Close())
-	assert.Nil(t, store.Ping(context.Background()))
-
-	ok, err := store.AddLink(context.Background(), "id", "url")
-
-	assert.True(t, ok)
-	assert.Nil(t, err)
-
-	link, visits, err := store.ExpandLink(context.Background(), "id")
-
-	assert.Equal(t, "url", link)
-	assert.Equal(t, int64(1), visits)
-	assert.Nil(t, err)
+	storetest.RunExpiration(t, func(expiration time.Duration) shrink.Store {
+		return shrink.Must(shrink.NewRedisStore(shrink.RedisStoreOptions{
+			Expiration: expiration,
+		}))
+	})
 }
 
 func TestRedisStoreClose(t *testing.T) {
@@ -117,3 +114,216 @@ func TestRedisStoreDeleteLink(t *testing.T) {
 	assert.Equal(t, int64(0), visits)
 	assert.NotNil(t, err)
 }
+
+func TestRedisStoreListLinksSparseFilter(t *testing.T) {
+	store := newTestRedisStore()
+
+	defer store.Close()
+
+	ids := []string{"sparse:needle"}
+
+	for i := 0; i < 1200; i++ {
+		ids = append(ids, fmt.Sprintf("sparse:%d", i))
+	}
+
+	for _, id := range ids {
+		assert.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.com/"+id)))
+	}
+
+	defer func() {
+		for _, id := range ids {
+			store.DeleteLink(context.Background(), id)
+		}
+	}()
+
+	filter := shrink.LinkFilter{URL: "needle"}
+	cursor := ""
+	pages := 0
+
+	var found []string
+
+	for {
+		links, next, err := store.ListLinks(context.Background(), cursor, 1, filter)
+
+		assert.Nil(t, err)
+		assert.LessOrEqual(t, len(links), 1)
+
+		for _, link := range links {
+			found = append(found, link.Id)
+		}
+
+		pages++
+
+		if next == "" {
+			break
+		}
+
+		cursor = next
+	}
+
+	assert.Equal(t, []string{"sparse:needle"}, found)
+	assert.Greater(t, pages, 1, "a page must not examine the whole keyspace")
+
+	// Keys SCAN returned beyond a page are carried in the cursor, and a SCAN cursor of zero ends the listing.
+	carried := "0-" + base64.RawURLEncoding.EncodeToString([]byte(`["sparse:1","sparse:needle"]`))
+
+	links, next, err := store.ListLinks(context.Background(), carried, 1, filter)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "sparse:needle", links[0].Id)
+	assert.Equal(t, "", next)
+
+	links, next, err = store.ListLinks(context.Background(), carried, 1, shrink.LinkFilter{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "sparse:1", links[0].Id)
+
+	links, next, err = store.ListLinks(context.Background(), next, 1, shrink.LinkFilter{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "sparse:needle", links[0].Id)
+	assert.Equal(t, "", next)
+
+	for _, cursor := range []string{"x", "1-!", "1-e30"} {
+		_, _, err := store.ListLinks(context.Background(), cursor, 1, filter)
+
+		assert.Equal(t, shrink.ErrInvalidCursor, err, cursor)
+	}
+}
+
+func TestRedisStoreAsyncVisits(t *testing.T) {
+	store := shrink.Must(shrink.NewRedisStore(shrink.RedisStoreOptions{
+		Expiration:         time.Minute,
+		AsyncVisits:        true,
+		VisitFlushInterval: time.Hour,
+	}))
+
+	reader := newTestRedisStore()
+
+	defer reader.Close()
+	defer reader.DeleteLink(context.Background(), "async")
+	defer reader.DeleteLink(context.Background(), "async-max")
+
+	assert.True(t, shrink.Must(store.AddLink(context.Background(), "async", "url")))
+	assert.Nil(t, store.CreateLink(context.Background(), "async-max", shrink.Link{URL: "url", MaxVisits: 2}))
+
+	for i := 0; i < 3; i++ {
+		link, visits, err := store.ExpandLink(context.Background(), "async")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "url", link)
+		assert.Equal(t, int64(1), visits, "visits are not counted until they are flushed")
+	}
+
+	for i := int64(1); i <= 2; i++ {
+		_, visits, err := store.ExpandLink(context.Background(), "async-max")
+
+		assert.Nil(t, err)
+		assert.Equal(t, i, visits, "visits to links with max visits are counted while expanding")
+	}
+
+	_, _, err := store.ExpandLink(context.Background(), "async-max")
+
+	assert.Equal(t, shrink.ErrGone, err)
+
+	link, err := reader.GetLink(context.Background(), "async")
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), link.Visits)
+
+	assert.Nil(t, store.Close(), "closing flushes the buffered visits")
+	assert.Equal(t, shrink.VisitQueueStats{Queued: 3, Flushed: 3}, store.VisitQueueStats())
+
+	link, err = reader.GetLink(context.Background(), "async")
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), link.Visits)
+
+	now := time.Now()
+	buckets, err := reader.LinkStats(context.Background(), "async", shrink.Hourly, now.Add(-time.Hour), now.Add(time.Hour))
+
+	assert.Nil(t, err)
+	assert.Equal(t, []shrink.StatsBucket{{Start: now.UTC().Truncate(time.Hour), Visits: 3}}, buckets)
+}
+
+func TestRedisStoreAsyncRecordVisit(t *testing.T) {
+	store := shrink.Must(shrink.NewRedisStore(shrink.RedisStoreOptions{
+		Expiration:         time.Minute,
+		AsyncVisits:        true,
+		VisitFlushInterval: time.Hour,
+	}))
+
+	reader := newTestRedisStore()
+
+	defer reader.Close()
+	defer reader.DeleteLink(context.Background(), "async-record")
+
+	assert.True(t, shrink.Must(store.AddLink(context.Background(), "async-record", "url")))
+
+	visits := []shrink.Visit{
+		{Visitor: "a", Referrer: "example.net", Browser: "Firefox"},
+		{Visitor: "a", Referrer: "example.net", Browser: "Chrome"},
+		{Visitor: "b", Referrer: shrink.DirectVisit, Browser: "Firefox"},
+	}
+
+	for _, visit := range visits {
+		assert.Nil(t, store.RecordVisit(context.Background(), "async-record", visit))
+	}
+
+	breakdowns := shrink.Must(reader.LinkBreakdowns(context.Background(), "async-record", 10))
+	link := shrink.Must(reader.GetLink(context.Background(), "async-record"))
+
+	assert.Empty(t, breakdowns[shrink.ByBrowser], "details are not counted until they are flushed")
+	assert.Equal(t, int64(0), link.UniqueVisitors)
+
+	assert.Nil(t, store.Close(), "closing flushes the buffered details")
+	assert.Equal(t, shrink.VisitQueueStats{Queued: 3, Flushed: 3}, store.VisitQueueStats())
+
+	breakdowns = shrink.Must(reader.LinkBreakdowns(context.Background(), "async-record", 10))
+	link = shrink.Must(reader.GetLink(context.Background(), "async-record"))
+
+	assert.Equal(t, []shrink.BreakdownEntry{{Value: "Firefox", Visits: 2}, {Value: "Chrome", Visits: 1}}, breakdowns[shrink.ByBrowser])
+	assert.Equal(t, []shrink.BreakdownEntry{{Value: "example.net", Visits: 2}, {Value: shrink.DirectVisit, Visits: 1}}, breakdowns[shrink.ByReferrer])
+	assert.Equal(t, int64(2), link.UniqueVisitors)
+}
+
+func TestRedisStoreAsyncVisitsClosed(t *testing.T) {
+	store := shrink.Must(shrink.NewRedisStore(shrink.RedisStoreOptions{
+		Expiration:  time.Minute,
+		AsyncVisits: true,
+	}))
+
+	assert.Nil(t, store.Close())
+	assert.Nil(t, store.CountVisit(context.Background(), "async-closed"))
+	assert.Nil(t, store.RecordVisit(context.Background(), "async-closed", shrink.Visit{}))
+	assert.Equal(t, shrink.VisitQueueStats{Dropped: 2}, store.VisitQueueStats(), "visits after closing are never flushed")
+}
+
+func TestRedisStoreAsyncVisitsDropped(t *testing.T) {
+	store := shrink.Must(shrink.NewRedisStore(shrink.RedisStoreOptions{
+		Expiration:         time.Minute,
+		AsyncVisits:        true,
+		VisitBufferSize:    1,
+		VisitFlushInterval: time.Hour,
+	}))
+
+	defer store.Close()
+	defer store.DeleteLink(context.Background(), "async-drop")
+
+	assert.True(t, shrink.Must(store.AddLink(context.Background(), "async-drop", "url")))
+
+	for i := 0; i < 100; i++ {
+		_, _, err := store.ExpandLink(context.Background(), "async-drop")
+
+		assert.Nil(t, err, "visits are dropped rather than failing the expand")
+	}
+
+	stats := store.VisitQueueStats()
+
+	assert.Equal(t, int64(100), stats.Queued+stats.Dropped)
+
+	link, err := store.GetLink(context.Background(), "async-drop")
+
+	assert.Nil(t, err)
+	assert.LessOrEqual(t, link.Visits, stats.Queued)
+}
@@ -0,0 +1,1265 @@
+// Package storetest provides a conformance suite that every Store implementation must pass.
+package storetest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	shrink "github.com/derek-schaefer/shrink-my-url"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The number of goroutines used by the concurrency tests.
+const concurrency = 20
+
+// Run the conformance suite against stores created by the given function.
+// A new store is created for each test and closed when it completes.
+func Run(t *testing.T, newStore func() shrink.Store) {
+	t.Run("Ping", func(t *testing.T) {
+		store := open(t, newStore)
+
+		assert.Nil(t, store.Ping(context.Background()))
+	})
+
+	t.Run("AddLink", func(t *testing.T) {
+		store := open(t, newStore)
+		id := newId(t, store)
+
+		ok, err := store.AddLink(context.Background(), id, "http://example.com")
+
+		assert.True(t, ok)
+		assert.Nil(t, err)
+	})
+
+	t.Run("AddLinkExists", func(t *testing.T) {
+		store := open(t, newStore)
+		id := newId(t, store)
+
+		require.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.com")))
+
+		ok, err := store.AddLink(context.Background(), id, "http://example.org")
+
+		assert.False(t, ok)
+		assert.Equal(t, shrink.ErrExists, err)
+
+		link, _, err := store.ExpandLink(context.Background(), id)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "http://example.com", link, "existing link must not be overwritten")
+	})
+
+	t.Run("ExpandLink", func(t *testing.T) {
+		store := open(t, newStore)
+		id := newId(t, store)
+
+		require.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.com")))
+
+		for i := int64(1); i <= 3; i++ {
+			link, visits, err := store.ExpandLink(context.Background(), id)
+
+			assert.Nil(t, err)
+			assert.Equal(t, "http://example.com", link)
+			assert.Equal(t, i, visits)
+		}
+	})
+
+	t.Run("ExpandLinkMissing", func(t *testing.T) {
+		store := open(t, newStore)
+		id := newId(t, store)
+
+		link, visits, err := store.ExpandLink(context.Background(), id)
+
+		assert.Equal(t, shrink.ErrNil, err)
+		assert.Equal(t, "", link)
+		assert.Equal(t, int64(0), visits)
+
+		// Expanding a missing link must not leave any state behind.
+		ok, err := store.AddLink(context.Background(), id, "http://example.com")
+
+		assert.True(t, ok)
+		assert.Nil(t, err)
+
+		_, visits, err = store.ExpandLink(context.Background(), id)
+
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), visits)
+	})
+
+	t.Run("GetLink", func(t *testing.T) {
+		store := open(t, newStore)
+		id := newId(t, store)
+
+		_, err := store.GetLink(context.Background(), id)
+
+		assert.Equal(t, shrink.ErrNil, err)
+
+		require.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.com")))
+		store.ExpandLink(context.Background(), id)
+
+		for i := 0; i < 2; i++ {
+			link, err := store.GetLink(context.Background(), id)
+
+			assert.Nil(t, err)
+			assert.Equal(t, "http://example.com", link.URL)
+			assert.Equal(t, int64(1), link.Visits, "getting a link must not count a visit")
+		}
+	})
+
+	t.Run("CreateLink", func(t *testing.T) {
+		store := open(t, newStore)
+		id := newId(t, store)
+
+		require.Nil(t, store.CreateLink(context.Background(), id, shrink.Link{URL: "http://example.com", MaxVisits: 2}))
+
+		assert.Equal(t, shrink.ErrExists, store.CreateLink(context.Background(), id, shrink.Link{URL: "http://example.org"}))
+
+		link, err := store.GetLink(context.Background(), id)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "http://example.com", link.URL)
+		assert.Equal(t, int64(2), link.MaxVisits)
+	})
+
+	t.Run("CreateLinkToken", func(t *testing.T) {
+		store := open(t, newStore)
+		id := newId(t, store)
+
+		require.Nil(t, store.CreateLink(context.Background(), id, shrink.Link{URL: "http://example.com", TokenHash: "hash"}))
+
+		url, visits, err := store.ExpandLink(context.Background(), id)
+
+		assert.Nil(t, err, "a management token must not protect the link")
+		assert.Equal(t, "http://example.com", url)
+		assert.Equal(t, int64(1), visits)
+
+		link, err := store.GetLink(context.Background(), id)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "hash", link.TokenHash)
+	})
+
+	t.Run("ExpandLinkMaxVisits", func(t *testing.T) {
+		store := open(t, newStore)
+		id := newId(t, store)
+
+		require.Nil(t, store.CreateLink(context.Background(), id, shrink.Link{URL: "http://example.com", MaxVisits: 2}))
+
+		for i := int64(1); i <= 2; i++ {
+			_, visits, err := store.ExpandLink(context.Background(), id)
+
+			assert.Nil(t, err)
+			assert.Equal(t, i, visits)
+		}
+
+		_, _, err := store.ExpandLink(context.Background(), id)
+
+		assert.Equal(t, shrink.ErrGone, err)
+
+		link, err := store.GetLink(context.Background(), id)
+
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2), link.Visits, "visits beyond the limit must not be counted")
+	})
+
+	t.Run("ExpandProtectedLink", func(t *testing.T) {
+		store := open(t, newStore)
+		id := newId(t, store)
+
+		require.Nil(t, store.CreateLink(context.Background(), id, shrink.Link{URL: "http://example.com", PasswordHash: "hash"}))
+
+		_, _, err := store.ExpandLink(context.Background(), id)
+
+		assert.Equal(t, shrink.ErrProtected, err)
+
+		_, _, err = store.ExpandProtectedLink(context.Background(), id, "other")
+
+		assert.Equal(t, shrink.ErrProtected, err)
+
+		link, visits, err := store.ExpandProtectedLink(context.Background(), id, "hash")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "http://example.com", link)
+		assert.Equal(t, int64(1), visits)
+
+		stored, err := store.GetLink(context.Background(), id)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "hash", stored.PasswordHash)
+		assert.Equal(t, int64(1), stored.Visits, "rejected expands must not be counted")
+
+		_, _, err = store.ExpandProtectedLink(context.Background(), newId(t, store), "hash")
+
+		assert.Equal(t, shrink.ErrNil, err)
+	})
+
+	t.Run("ExpandLinkActivation", func(t *testing.T) {
+		store := open(t, newStore)
+		scheduled, ended, active := newId(t, store)+":scheduled", newId(t, store)+":ended", newId(t, store)+":active"
+		now := time.Now()
+
+		t.Cleanup(func() {
+			for _, id := range []string{scheduled, ended, active} {
+				store.DeleteLink(context.Background(), id)
+			}
+		})
+
+		require.Nil(t, store.CreateLink(context.Background(), scheduled, shrink.Link{URL: "http://example.com", NotBefore: now.Add(time.Hour)}))
+		require.Nil(t, store.CreateLink(context.Background(), ended, shrink.Link{URL: "http://example.com", NotAfter: now.Add(-time.Second)}))
+		require.Nil(t, store.CreateLink(context.Background(), active, shrink.Link{
+			URL:       "http://example.com",
+			NotBefore: now.Add(-time.Second),
+			NotAfter:  now.Add(time.Hour),
+		}))
+
+		_, _, err := store.ExpandLink(context.Background(), scheduled)
+
+		assert.Equal(t, shrink.ErrNotActive, err)
+
+		_, _, err = store.ExpandLink(context.Background(), ended)
+
+		assert.Equal(t, shrink.ErrExpired, err)
+
+		_, visits, err := store.ExpandLink(context.Background(), active)
+
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), visits)
+
+		link, err := store.GetLink(context.Background(), scheduled)
+
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), link.Visits, "visits before the link is active must not be counted")
+		assert.WithinDuration(t, now.Add(time.Hour), link.NotBefore, time.Millisecond)
+		assert.True(t, link.NotAfter.IsZero())
+	})
+
+	t.Run("ConcurrentExpandLinkMaxVisits", func(t *testing.T) {
+		store := open(t, newStore)
+		id := newId(t, store)
+
+		require.Nil(t, store.CreateLink(context.Background(), id, shrink.Link{URL: "http://example.com", MaxVisits: concurrency / 2}))
+
+		var expanded atomic.Int64
+
+		parallel(func() {
+			_, _, err := store.ExpandLink(context.Background(), id)
+
+			if err == nil {
+				expanded.Add(1)
+			} else {
+				assert.Equal(t, shrink.ErrGone, err)
+			}
+		})
+
+		assert.Equal(t, int64(concurrency/2), expanded.Load(), "exactly max visits concurrent expands must succeed")
+	})
+
+	t.Run("UpdateLink", func(t *testing.T) {
+		store := open(t, newStore)
+		id := newId(t, store)
+
+		require.Nil(t, store.CreateLink(context.Background(), id, shrink.Link{URL: "http://example.com"}))
+
+		_, _, err := store.ExpandLink(context.Background(), id)
+
+		require.Nil(t, err)
+
+		link, err := store.UpdateLink(context.Background(), id, shrink.LinkUpdate{URL: "http://example.org", Author: "alice"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "http://example.org", link.URL)
+		assert.Equal(t, int64(2), link.Version)
+		assert.Equal(t, int64(1), link.Visits, "changing the destination must keep the visits")
+
+		_, err = store.UpdateLink(context.Background(), id, shrink.LinkUpdate{URL: "http://example.net", Version: 1, Author: "bob"})
+
+		assert.Equal(t, shrink.ErrVersionMismatch, err)
+
+		link, err = store.UpdateLink(context.Background(), id, shrink.LinkUpdate{URL: "http://example.net", Version: 2, Author: "bob"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, int64(3), link.Version)
+
+		url, _, err := store.ExpandLink(context.Background(), id)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "http://example.net", url)
+
+		history, err := store.LinkHistory(context.Background(), id)
+
+		assert.Nil(t, err)
+		require.Len(t, history, 2)
+		assert.Equal(t, int64(1), history[0].Version)
+		assert.Equal(t, "http://example.com", history[0].URL)
+		assert.Equal(t, "alice", history[0].ChangedBy)
+		assert.WithinDuration(t, time.Now(), history[0].ChangedAt, time.Minute)
+		assert.Equal(t, int64(2), history[1].Version)
+		assert.Equal(t, "http://example.org", history[1].URL)
+		assert.Equal(t, "bob", history[1].ChangedBy)
+
+		require.Nil(t, store.DeleteLink(context.Background(), id))
+		require.Nil(t, store.CreateLink(context.Background(), id, shrink.Link{URL: "http://example.com"}))
+
+		history, err = store.LinkHistory(context.Background(), id)
+
+		assert.Nil(t, err)
+		assert.Empty(t, history, "a new link must not inherit the history of a deleted one")
+	})
+
+	t.Run("UpdateLinkMissing", func(t *testing.T) {
+		store := open(t, newStore)
+		id := newId(t, store)
+
+		_, err := store.UpdateLink(context.Background(), id, shrink.LinkUpdate{URL: "http://example.org"})
+
+		assert.Equal(t, shrink.ErrNil, err)
+
+		_, err = store.LinkHistory(context.Background(), id)
+
+		assert.Equal(t, shrink.ErrNil, err)
+	})
+
+	t.Run("ConcurrentUpdateLink", func(t *testing.T) {
+		store := open(t, newStore)
+		id := newId(t, store)
+
+		require.Nil(t, store.CreateLink(context.Background(), id, shrink.Link{URL: "http://example.com"}))
+
+		var updated atomic.Int64
+
+		parallel(func() {
+			_, err := store.UpdateLink(context.Background(), id, shrink.LinkUpdate{URL: "http://example.org", Version: 1})
+
+			if err == nil {
+				updated.Add(1)
+			} else {
+				assert.Equal(t, shrink.ErrVersionMismatch, err)
+			}
+		})
+
+		assert.Equal(t, int64(1), updated.Load(), "exactly one update of a version must succeed")
+	})
+
+	t.Run("DeleteLink", func(t *testing.T) {
+		store := open(t, newStore)
+		id := newId(t, store)
+
+		require.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.com")))
+		store.ExpandLink(context.Background(), id)
+
+		assert.Nil(t, store.DeleteLink(context.Background(), id))
+
+		_, _, err := store.ExpandLink(context.Background(), id)
+
+		assert.Equal(t, shrink.ErrNil, err)
+
+		// The ID is reusable and its visit count starts over.
+		ok, err := store.AddLink(context.Background(), id, "http://example.org")
+
+		assert.True(t, ok)
+		assert.Nil(t, err)
+
+		link, visits, err := store.ExpandLink(context.Background(), id)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "http://example.org", link)
+		assert.Equal(t, int64(1), visits)
+	})
+
+	t.Run("DeleteLinkMissing", func(t *testing.T) {
+		store := open(t, newStore)
+		id := newId(t, store)
+
+		assert.Nil(t, store.DeleteLink(context.Background(), id))
+	})
+
+	t.Run("TrashLink", func(t *testing.T) {
+		store := open(t, newStore)
+		trash := trasher(t, store)
+		id := newId(t, store)
+
+		assert.Equal(t, shrink.ErrNil, trash.TrashLink(context.Background(), id))
+
+		require.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.com")))
+		store.ExpandLink(context.Background(), id)
+
+		require.Nil(t, trash.TrashLink(context.Background(), id))
+
+		assert.Equal(t, shrink.ErrDeleted, trash.TrashLink(context.Background(), id))
+
+		_, _, err := store.ExpandLink(context.Background(), id)
+
+		assert.Equal(t, shrink.ErrDeleted, err)
+
+		_, err = store.AddLink(context.Background(), id, "http://example.org")
+
+		assert.Equal(t, shrink.ErrExists, err, "a link in the trash keeps its ID")
+
+		link, err := store.GetLink(context.Background(), id)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "http://example.com", link.URL)
+		assert.False(t, link.DeletedAt.IsZero())
+		assert.Contains(t, trashedIds(t, trash), id)
+
+		require.Nil(t, trash.RestoreLink(context.Background(), id))
+
+		assert.Equal(t, shrink.ErrNil, trash.RestoreLink(context.Background(), id))
+		assert.NotContains(t, trashedIds(t, trash), id)
+
+		url, visits, err := store.ExpandLink(context.Background(), id)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "http://example.com", url)
+		assert.Equal(t, int64(2), visits, "a restored link keeps its visits")
+	})
+
+	t.Run("UpdateTrashedLink", func(t *testing.T) {
+		store := open(t, newStore)
+		trash := trasher(t, store)
+		id := newId(t, store)
+
+		require.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.com")))
+		require.Nil(t, trash.TrashLink(context.Background(), id))
+
+		_, err := store.UpdateLink(context.Background(), id, shrink.LinkUpdate{URL: "http://example.org", Author: "alice"})
+
+		assert.Equal(t, shrink.ErrDeleted, err)
+
+		link, err := store.GetLink(context.Background(), id)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "http://example.com", link.URL, "links in the trash cannot be changed")
+		assert.Equal(t, int64(1), link.Version)
+		assert.Empty(t, shrink.Must(store.LinkHistory(context.Background(), id)))
+	})
+
+	t.Run("RestoreLinkMissing", func(t *testing.T) {
+		store := open(t, newStore)
+		trash := trasher(t, store)
+		id := newId(t, store)
+
+		assert.Equal(t, shrink.ErrNil, trash.RestoreLink(context.Background(), id))
+	})
+
+	t.Run("PurgeTrash", func(t *testing.T) {
+		store := open(t, newStore)
+		trash := trasher(t, store)
+		id := newId(t, store)
+		live := id + ":live"
+
+		t.Cleanup(func() {
+			store.DeleteLink(context.Background(), live)
+		})
+
+		require.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.com")))
+		require.True(t, shrink.Must(store.AddLink(context.Background(), live, "http://example.org")))
+		require.Nil(t, trash.TrashLink(context.Background(), id))
+
+		link := shrink.Must(store.GetLink(context.Background(), id))
+
+		_, err := trash.PurgeTrash(context.Background(), link.DeletedAt.Add(-time.Second))
+
+		assert.Nil(t, err)
+		assert.Contains(t, trashedIds(t, trash), id, "links deleted after the given time must be kept")
+
+		n, err := trash.PurgeTrash(context.Background(), time.Now())
+
+		assert.Nil(t, err)
+		assert.GreaterOrEqual(t, n, 1)
+		assert.NotContains(t, trashedIds(t, trash), id)
+
+		_, err = store.GetLink(context.Background(), id)
+
+		assert.Equal(t, shrink.ErrNil, err)
+
+		_, err = store.GetLink(context.Background(), live)
+
+		assert.Nil(t, err, "links that are not in the trash must not be purged")
+
+		// The ID is reusable once the link is purged.
+		assert.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.net")))
+	})
+
+	t.Run("ListLinks", func(t *testing.T) {
+		store := open(t, newStore)
+		ids := addLinks(t, store, 3)
+
+		store.ExpandLink(context.Background(), ids[0])
+
+		// The filter limits the listing to the test's own links, since shared databases may have others.
+		filter := shrink.LinkFilter{URL: strings.ToUpper(newId(t, store))}
+		links := listedLinks(t, store, 1, filter)
+
+		assert.ElementsMatch(t, ids, linkIds(links))
+		assert.Equal(t, "http://example.com/"+ids[0], links[ids[0]].URL)
+		assert.Equal(t, int64(1), links[ids[0]].Visits)
+
+		links = listedLinks(t, store, 2, shrink.LinkFilter{URL: ids[1]})
+
+		assert.ElementsMatch(t, ids[1:2], linkIds(links))
+	})
+
+	t.Run("ListLinksDeleted", func(t *testing.T) {
+		store := open(t, newStore)
+		trash := trasher(t, store)
+		ids := addLinks(t, store, 4)
+
+		require.Nil(t, trash.TrashLink(context.Background(), ids[3]))
+
+		filter := shrink.LinkFilter{URL: strings.ToUpper(newId(t, store))}
+		links := listedLinks(t, store, 1, filter)
+
+		assert.ElementsMatch(t, ids[:3], linkIds(links), "links in the trash must be excluded")
+
+		filter.IncludeDeleted = true
+		links = listedLinks(t, store, 2, filter)
+
+		assert.ElementsMatch(t, ids, linkIds(links))
+		assert.False(t, links[ids[3]].DeletedAt.IsZero())
+	})
+
+	t.Run("ListLinksInvalidLimit", func(t *testing.T) {
+		store := open(t, newStore)
+
+		for _, limit := range []int{0, -1} {
+			_, _, err := store.ListLinks(context.Background(), "", limit, shrink.LinkFilter{})
+
+			assert.Equal(t, shrink.ErrInvalidLimit, err)
+		}
+	})
+
+	t.Run("LinkStats", func(t *testing.T) {
+		store := open(t, newStore)
+		stats := statsRecorder(t, store)
+		id := newId(t, store)
+		now := time.Now()
+
+		assert.Empty(t, shrink.Must(stats.LinkStats(context.Background(), id, shrink.Hourly, now.Add(-time.Hour), now.Add(time.Hour))))
+
+		require.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.com")))
+
+		for i := 0; i < 3; i++ {
+			_, _, err := store.ExpandLink(context.Background(), id)
+
+			require.Nil(t, err)
+		}
+
+		for _, g := range []shrink.Granularity{shrink.Hourly, shrink.Daily} {
+			buckets, err := stats.LinkStats(context.Background(), id, g, now.Add(-48*time.Hour), now.Add(48*time.Hour))
+
+			assert.Nil(t, err)
+			assert.Equal(t, int64(3), bucketVisits(buckets), "every visit is counted at every granularity")
+
+			for _, bucket := range buckets {
+				assert.False(t, bucket.Start.After(time.Now()))
+			}
+		}
+
+		buckets, err := stats.LinkStats(context.Background(), id, shrink.Hourly, now.Add(-48*time.Hour), now.Add(-24*time.Hour))
+
+		assert.Nil(t, err)
+		assert.Empty(t, buckets, "buckets outside the range are left out")
+
+		require.Nil(t, store.DeleteLink(context.Background(), id))
+		require.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.com")))
+
+		buckets, err = stats.LinkStats(context.Background(), id, shrink.Daily, now.Add(-48*time.Hour), now.Add(48*time.Hour))
+
+		assert.Nil(t, err)
+		assert.Empty(t, buckets, "stats are deleted with the link")
+	})
+
+	t.Run("RecordVisit", func(t *testing.T) {
+		store := open(t, newStore)
+		stats := statsRecorder(t, store)
+		id := newId(t, store)
+
+		firefox := shrink.Visit{Referrer: "example.org", Browser: "Firefox", OS: "Linux", Device: "desktop", Language: "en"}
+		safari := shrink.Visit{Referrer: "example.net", Browser: "Safari", OS: "iOS", Device: "mobile", Language: "de"}
+
+		assert.Nil(t, stats.RecordVisit(context.Background(), id, firefox), "visits to missing links are ignored")
+
+		require.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.com")))
+
+		for _, visit := range []shrink.Visit{firefox, safari, firefox, {}} {
+			require.Nil(t, stats.RecordVisit(context.Background(), id, visit))
+		}
+
+		breakdowns, err := stats.LinkBreakdowns(context.Background(), id, 2)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []shrink.BreakdownEntry{{Value: "Firefox", Visits: 2}, {Value: "Safari", Visits: 1}}, breakdowns[shrink.ByBrowser])
+		assert.Equal(t, []shrink.BreakdownEntry{{Value: "en", Visits: 2}, {Value: "de", Visits: 1}}, breakdowns[shrink.ByLanguage])
+		assert.Len(t, breakdowns[shrink.ByReferrer], 2, "only the top values are returned")
+		assert.Equal(t, shrink.BreakdownEntry{Value: "desktop", Visits: 2}, breakdowns[shrink.ByDevice][0])
+		assert.Equal(t, shrink.BreakdownEntry{Value: "Linux", Visits: 2}, breakdowns[shrink.ByOS][0])
+
+		breakdowns, err = stats.LinkBreakdowns(context.Background(), id, 10)
+
+		assert.Nil(t, err)
+		assert.Contains(t, breakdowns[shrink.ByOS], shrink.BreakdownEntry{Value: shrink.UnknownValue, Visits: 1})
+
+		require.Nil(t, store.DeleteLink(context.Background(), id))
+		require.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.com")))
+
+		breakdowns, err = stats.LinkBreakdowns(context.Background(), id, 10)
+
+		assert.Nil(t, err)
+		assert.Empty(t, breakdowns[shrink.ByBrowser], "breakdowns are deleted with the link")
+	})
+
+	t.Run("RecordVisitCapsValues", func(t *testing.T) {
+		store := open(t, newStore)
+		stats := statsRecorder(t, store)
+		id := newId(t, store)
+
+		require.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.com")))
+
+		for i := range shrink.MaxBreakdownValues {
+			require.Nil(t, stats.RecordVisit(context.Background(), id, shrink.Visit{Referrer: fmt.Sprintf("%d.example", i)}))
+		}
+
+		for _, referrer := range []string{"new.example", "0.example", "other.example", "new.example"} {
+			require.Nil(t, stats.RecordVisit(context.Background(), id, shrink.Visit{Referrer: referrer}))
+		}
+
+		breakdowns, err := stats.LinkBreakdowns(context.Background(), id, 3)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []shrink.BreakdownEntry{
+			{Value: shrink.OtherValue, Visits: 3},
+			{Value: "0.example", Visits: 2},
+			{Value: "1.example", Visits: 1},
+		}, breakdowns[shrink.ByReferrer], "new values are counted as other once a dimension is full")
+		assert.Equal(t, []shrink.BreakdownEntry{{Value: shrink.UnknownValue, Visits: shrink.MaxBreakdownValues + 4}}, breakdowns[shrink.ByBrowser])
+	})
+
+	t.Run("UniqueVisitors", func(t *testing.T) {
+		store := open(t, newStore)
+		stats := statsRecorder(t, store)
+		id := newId(t, store)
+
+		require.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.com")))
+
+		for _, visitor := range []string{"a", "b", "a", "", "c", "b"} {
+			require.Nil(t, stats.RecordVisit(context.Background(), id, shrink.Visit{Visitor: visitor}))
+		}
+
+		link, err := store.GetLink(context.Background(), id)
+
+		assert.Nil(t, err)
+		assert.Equal(t, int64(3), link.UniqueVisitors, "visits without a visitor are not counted")
+
+		for i := range 1000 {
+			require.Nil(t, stats.RecordVisit(context.Background(), id, shrink.Visit{Visitor: fmt.Sprint(i)}))
+		}
+
+		link, err = store.GetLink(context.Background(), id)
+
+		assert.Nil(t, err)
+		assert.InDelta(t, 1003, link.UniqueVisitors, 50, "many visitors are estimated")
+
+		require.Nil(t, store.DeleteLink(context.Background(), id))
+		require.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.com")))
+
+		link, err = store.GetLink(context.Background(), id)
+
+		assert.Nil(t, err)
+		assert.Zero(t, link.UniqueVisitors, "visitors are deleted with the link")
+	})
+
+	t.Run("ConcurrentAddLink", func(t *testing.T) {
+		store := open(t, newStore)
+		id := newId(t, store)
+
+		var added atomic.Int64
+
+		parallel(func() {
+			ok, err := store.AddLink(context.Background(), id, "http://example.com")
+
+			if ok {
+				added.Add(1)
+			} else {
+				assert.Equal(t, shrink.ErrExists, err)
+			}
+		})
+
+		assert.Equal(t, int64(1), added.Load(), "exactly one concurrent add must succeed")
+	})
+
+	t.Run("ConcurrentExpandLink", func(t *testing.T) {
+		store := open(t, newStore)
+		id := newId(t, store)
+
+		require.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.com")))
+
+		parallel(func() {
+			_, _, err := store.ExpandLink(context.Background(), id)
+
+			assert.Nil(t, err)
+		})
+
+		_, visits, err := store.ExpandLink(context.Background(), id)
+
+		assert.Nil(t, err)
+		assert.Equal(t, int64(concurrency+1), visits, "no visits may be lost")
+	})
+
+	t.Run("AddOrGetLink", func(t *testing.T) {
+		store := open(t, newStore)
+		dedup, ok := store.(shrink.Deduplicator)
+
+		if !ok {
+			t.Skip("store does not implement Deduplicator")
+		}
+
+		id := newId(t, store)
+		other := newId(t, store) + ":other"
+		url := "http://example.com/" + id
+
+		t.Cleanup(func() {
+			store.DeleteLink(context.Background(), other)
+		})
+
+		existing, visits, err := dedup.AddOrGetLink(context.Background(), id, url)
+
+		assert.Nil(t, err)
+		assert.Equal(t, id, existing)
+		assert.Equal(t, int64(0), visits)
+
+		store.ExpandLink(context.Background(), id)
+
+		existing, visits, err = dedup.AddOrGetLink(context.Background(), other, url)
+
+		assert.Nil(t, err)
+		assert.Equal(t, id, existing, "the URL must resolve to its existing link")
+		assert.Equal(t, int64(1), visits)
+
+		_, _, err = dedup.AddOrGetLink(context.Background(), id, url+"/different")
+
+		assert.Equal(t, shrink.ErrExists, err)
+
+		// Once deleted, the URL is free to be shortened again.
+		assert.Nil(t, store.DeleteLink(context.Background(), id))
+
+		existing, _, err = dedup.AddOrGetLink(context.Background(), other, url)
+
+		assert.Nil(t, err)
+		assert.Equal(t, other, existing)
+	})
+
+	t.Run("ConcurrentAddOrGetLink", func(t *testing.T) {
+		store := open(t, newStore)
+		dedup, ok := store.(shrink.Deduplicator)
+
+		if !ok {
+			t.Skip("store does not implement Deduplicator")
+		}
+
+		prefix := newId(t, store)
+		url := "http://example.com/" + prefix
+
+		var mu sync.Mutex
+		var next int
+		ids := make(map[string]bool)
+
+		parallel(func() {
+			mu.Lock()
+			next++
+			id := fmt.Sprintf("%s:%d", prefix, next)
+			mu.Unlock()
+
+			t.Cleanup(func() {
+				store.DeleteLink(context.Background(), id)
+			})
+
+			existing, _, err := dedup.AddOrGetLink(context.Background(), id, url)
+
+			assert.Nil(t, err)
+
+			mu.Lock()
+			ids[existing] = true
+			mu.Unlock()
+		})
+
+		assert.Len(t, ids, 1, "concurrent shortens of the same URL must share one link")
+	})
+
+	t.Run("NextSequence", func(t *testing.T) {
+		store := open(t, newStore)
+		counter, ok := store.(shrink.Counter)
+
+		if !ok {
+			t.Skip("store does not implement Counter")
+		}
+
+		first := shrink.Must(counter.NextSequence(context.Background()))
+
+		var mu sync.Mutex
+		seen := make(map[uint64]bool)
+
+		parallel(func() {
+			n, err := counter.NextSequence(context.Background())
+
+			assert.Nil(t, err)
+			assert.Greater(t, n, first)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			assert.False(t, seen[n], "sequence numbers must be unique")
+			seen[n] = true
+		})
+
+		assert.Greater(t, shrink.Must(counter.NextSequence(context.Background())), first+concurrency-1)
+	})
+
+	t.Run("CountVisit", func(t *testing.T) {
+		store := open(t, newStore)
+		counter, ok := store.(shrink.VisitCounter)
+
+		if !ok {
+			t.Skip("store does not implement VisitCounter")
+		}
+
+		id := newId(t, store)
+
+		require.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.com")))
+
+		parallel(func() {
+			assert.Nil(t, counter.CountVisit(context.Background(), id))
+		})
+
+		link, err := store.GetLink(context.Background(), id)
+
+		assert.Nil(t, err)
+		assert.Equal(t, int64(concurrency), link.Visits, "no visits may be lost")
+
+		assert.Nil(t, counter.CountVisit(context.Background(), newId(t, store)), "visits to missing links are ignored")
+	})
+}
+
+// Run the expiration tests against stores created by the given function.
+// The function must return a store that expires links after the given duration, refreshed on each visit.
+func RunExpiration(t *testing.T, newStore func(expiration time.Duration) shrink.Store) {
+	const expiration = 200 * time.Millisecond
+
+	factory := func() shrink.Store {
+		return newStore(expiration)
+	}
+
+	t.Run("Expires", func(t *testing.T) {
+		store := open(t, factory)
+		id := newId(t, store)
+
+		require.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.com")))
+
+		time.Sleep(2 * expiration)
+
+		_, _, err := store.ExpandLink(context.Background(), id)
+
+		assert.Equal(t, shrink.ErrNil, err)
+
+		// An expired ID is free to be reused.
+		ok, err := store.AddLink(context.Background(), id, "http://example.org")
+
+		assert.True(t, ok)
+		assert.Nil(t, err)
+
+		_, visits, err := store.ExpandLink(context.Background(), id)
+
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1), visits)
+	})
+
+	t.Run("ExpandRefreshes", func(t *testing.T) {
+		store := open(t, factory)
+		id := newId(t, store)
+
+		require.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.com")))
+
+		for i := 0; i < 4; i++ {
+			time.Sleep(expiration / 2)
+
+			_, _, err := store.ExpandLink(context.Background(), id)
+
+			require.Nil(t, err, "visits must refresh the expiration")
+		}
+	})
+
+	t.Run("PurgeExpired", func(t *testing.T) {
+		store := open(t, factory)
+		purger, ok := store.(shrink.ExpiredPurger)
+
+		if !ok {
+			t.Skip("store does not implement ExpiredPurger")
+		}
+
+		trash, trashes := store.(shrink.Trasher)
+
+		expired := newId(t, store)
+		trashed := expired + ":trashed"
+		live := expired + ":live"
+
+		t.Cleanup(func() {
+			store.DeleteLink(context.Background(), trashed)
+			store.DeleteLink(context.Background(), live)
+		})
+
+		require.True(t, shrink.Must(store.AddLink(context.Background(), expired, "http://example.com")))
+
+		if trashes {
+			require.True(t, shrink.Must(store.AddLink(context.Background(), trashed, "http://example.com")))
+			require.Nil(t, trash.TrashLink(context.Background(), trashed))
+		}
+
+		store.ExpandLink(context.Background(), expired)
+
+		time.Sleep(2 * expiration)
+
+		require.True(t, shrink.Must(store.AddLink(context.Background(), live, "http://example.com")))
+
+		n, err := purger.PurgeExpired(context.Background(), time.Now())
+
+		assert.Nil(t, err)
+		assert.GreaterOrEqual(t, n, 1)
+
+		_, err = store.GetLink(context.Background(), expired)
+
+		assert.Equal(t, shrink.ErrNil, err)
+
+		_, err = store.GetLink(context.Background(), live)
+
+		assert.Nil(t, err, "links that have not expired are kept")
+
+		if trashes {
+			assert.Contains(t, trashedIds(t, trash), trashed, "links in the trash are kept until the trash is purged")
+		}
+	})
+
+	t.Run("CountVisitRefreshes", func(t *testing.T) {
+		store := open(t, factory)
+		counter, ok := store.(shrink.VisitCounter)
+
+		if !ok {
+			t.Skip("store does not implement VisitCounter")
+		}
+
+		id := newId(t, store)
+
+		require.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.com")))
+
+		for i := 0; i < 4; i++ {
+			time.Sleep(expiration / 2)
+
+			require.Nil(t, counter.CountVisit(context.Background(), id))
+		}
+
+		_, err := store.GetLink(context.Background(), id)
+
+		assert.Nil(t, err, "counted visits must refresh the expiration")
+	})
+
+	t.Run("ExpandRefreshesOwnedLink", func(t *testing.T) {
+		store := open(t, factory)
+		id := newId(t, store)
+
+		require.Nil(t, store.CreateLink(context.Background(), id, shrink.Link{URL: "http://example.com", TokenHash: "hash"}))
+
+		for i := 0; i < 4; i++ {
+			time.Sleep(expiration / 2)
+
+			_, _, err := store.ExpandLink(context.Background(), id)
+
+			require.Nil(t, err, "a management token must not change the expiration refreshed by visits")
+		}
+
+		link, err := store.GetLink(context.Background(), id)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "hash", link.TokenHash, "the settings must expire along with the link")
+	})
+
+	t.Run("TrashedLinkDoesNotExpire", func(t *testing.T) {
+		store := open(t, factory)
+		trash := trasher(t, store)
+		id := newId(t, store)
+
+		require.True(t, shrink.Must(store.AddLink(context.Background(), id, "http://example.com")))
+		require.Nil(t, trash.TrashLink(context.Background(), id))
+
+		time.Sleep(2 * expiration)
+
+		assert.Contains(t, trashedIds(t, trash), id, "links in the trash must be kept until they are purged")
+		require.Nil(t, trash.RestoreLink(context.Background(), id))
+
+		_, _, err := store.ExpandLink(context.Background(), id)
+
+		require.Nil(t, err, "a restored link must get its expiration back from when it was restored")
+
+		time.Sleep(2 * expiration)
+
+		_, _, err = store.ExpandLink(context.Background(), id)
+
+		assert.Equal(t, shrink.ErrNil, err, "a restored link must expire again")
+	})
+
+	t.Run("TrashedLinkFixedExpiration", func(t *testing.T) {
+		store := open(t, factory)
+		trash := trasher(t, store)
+		id := newId(t, store)
+
+		expiresAt := time.Now().Add(expiration)
+
+		require.Nil(t, store.CreateLink(context.Background(), id, shrink.Link{URL: "http://example.com", ExpiresAt: expiresAt}))
+		require.Nil(t, trash.TrashLink(context.Background(), id))
+
+		time.Sleep(2 * expiration)
+
+		assert.Contains(t, trashedIds(t, trash), id)
+		require.Nil(t, trash.RestoreLink(context.Background(), id))
+
+		_, _, err := store.ExpandLink(context.Background(), id)
+
+		assert.Equal(t, shrink.ErrNil, err, "a fixed expiration that passed in the trash must apply once restored")
+	})
+
+	t.Run("CreateLinkTTL", func(t *testing.T) {
+		store := open(t, factory)
+		id := newId(t, store)
+
+		require.Nil(t, store.CreateLink(context.Background(), id, shrink.Link{URL: "http://example.com", TTL: 3 * expiration}))
+
+		time.Sleep(2 * expiration)
+
+		_, _, err := store.ExpandLink(context.Background(), id)
+
+		require.Nil(t, err, "the link's own TTL must replace the store's expiration")
+
+		time.Sleep(2 * expiration)
+
+		_, _, err = store.ExpandLink(context.Background(), id)
+
+		assert.Nil(t, err, "visits must refresh the link's own TTL")
+
+		link, err := store.GetLink(context.Background(), id)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 3*expiration, link.TTL)
+		assert.False(t, link.Absolute)
+	})
+
+	t.Run("CreateLinkAbsolute", func(t *testing.T) {
+		store := open(t, factory)
+		id := newId(t, store)
+
+		require.Nil(t, store.CreateLink(context.Background(), id, shrink.Link{URL: "http://example.com", TTL: expiration, Absolute: true}))
+
+		link, err := store.GetLink(context.Background(), id)
+
+		assert.Nil(t, err)
+		assert.True(t, link.Absolute)
+		assert.WithinDuration(t, time.Now().Add(expiration), link.ExpiresAt, expiration/2)
+
+		time.Sleep(expiration / 2)
+
+		_, _, err = store.ExpandLink(context.Background(), id)
+
+		require.Nil(t, err)
+
+		time.Sleep(expiration)
+
+		_, _, err = store.ExpandLink(context.Background(), id)
+
+		assert.Equal(t, shrink.ErrNil, err, "visits must not refresh an absolute expiration")
+	})
+
+	t.Run("CreateLinkExpiresAt", func(t *testing.T) {
+		store := open(t, factory)
+		id := newId(t, store)
+
+		expiresAt := time.Now().Add(3 * expiration)
+
+		require.Nil(t, store.CreateLink(context.Background(), id, shrink.Link{URL: "http://example.com", ExpiresAt: expiresAt}))
+
+		time.Sleep(2 * expiration)
+
+		_, _, err := store.ExpandLink(context.Background(), id)
+
+		require.Nil(t, err, "the link must outlive the store's expiration")
+
+		time.Sleep(2 * expiration)
+
+		_, _, err = store.ExpandLink(context.Background(), id)
+
+		assert.Equal(t, shrink.ErrNil, err)
+	})
+
+	t.Run("CreateLinkNotBefore", func(t *testing.T) {
+		store := open(t, factory)
+		id := newId(t, store)
+
+		notBefore := time.Now().Add(2 * expiration)
+
+		require.Nil(t, store.CreateLink(context.Background(), id, shrink.Link{URL: "http://example.com", NotBefore: notBefore}))
+
+		time.Sleep(2*expiration + expiration/2)
+
+		_, _, err := store.ExpandLink(context.Background(), id)
+
+		assert.Nil(t, err, "the expiration must be counted from when the link becomes active")
+	})
+}
+
+// Open a store for the test, closing it when the test completes.
+func open(t *testing.T, newStore func() shrink.Store) shrink.Store {
+	store := newStore()
+
+	t.Cleanup(func() {
+		store.Close()
+	})
+
+	return store
+}
+
+// Return a link ID unique to the test, removing it from the store before and after the test.
+// Stores backed by shared databases may retain links from previous runs.
+func newId(t *testing.T, store shrink.Store) string {
+	id := "storetest:" + strings.ReplaceAll(t.Name(), "/", ":")
+
+	require.Nil(t, store.DeleteLink(context.Background(), id))
+
+	t.Cleanup(func() {
+		store.DeleteLink(context.Background(), id)
+	})
+
+	return id
+}
+
+// Add the given number of links with IDs and URLs derived from the test's ID, returning their IDs.
+func addLinks(t *testing.T, store shrink.Store, n int) []string {
+	id := newId(t, store)
+	ids := make([]string, 0, n)
+
+	for i := range n {
+		other := fmt.Sprintf("%s:%c", id, 'a'+i)
+
+		require.Nil(t, store.DeleteLink(context.Background(), other))
+
+		t.Cleanup(func() {
+			store.DeleteLink(context.Background(), other)
+		})
+
+		require.True(t, shrink.Must(store.AddLink(context.Background(), other, "http://example.com/"+other)))
+
+		ids = append(ids, other)
+	}
+
+	return ids
+}
+
+// Return the store as a Trasher, skipping the test if the store has no trash.
+func trasher(t *testing.T, store shrink.Store) shrink.Trasher {
+	trash, ok := store.(shrink.Trasher)
+
+	if !ok {
+		t.Skip("store does not implement Trasher")
+	}
+
+	return trash
+}
+
+// Return the store as a StatsRecorder, skipping the test if the store keeps no stats.
+func statsRecorder(t *testing.T, store shrink.Store) shrink.StatsRecorder {
+	stats, ok := store.(shrink.StatsRecorder)
+
+	if !ok {
+		t.Skip("store does not implement StatsRecorder")
+	}
+
+	return stats
+}
+
+// Return the IDs of the links in the store's trash.
+func trashedIds(t *testing.T, trash shrink.Trasher) []string {
+	links, err := trash.ListTrash(context.Background())
+
+	require.Nil(t, err)
+
+	ids := make([]string, 0, len(links))
+
+	for _, link := range links {
+		ids = append(ids, link.Id)
+	}
+
+	return ids
+}
+
+// Return the links in the store matching the filter by ID, following the cursors of pages of the given size.
+func listedLinks(t *testing.T, store shrink.Store, limit int, filter shrink.LinkFilter) map[string]shrink.Link {
+	links := make(map[string]shrink.Link)
+	cursor := ""
+
+	for {
+		page, next, err := store.ListLinks(context.Background(), cursor, limit, filter)
+
+		require.Nil(t, err)
+		require.LessOrEqual(t, len(page), limit)
+
+		for _, link := range page {
+			require.NotContains(t, links, link.Id, "a link must only be listed once")
+
+			links[link.Id] = link.Link
+		}
+
+		if next == "" {
+			return links
+		}
+
+		cursor = next
+	}
+}
+
+// Return the IDs of the listed links.
+func linkIds(links map[string]shrink.Link) []string {
+	ids := make([]string, 0, len(links))
+
+	for id := range links {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// Return the total visits in the buckets.
+func bucketVisits(buckets []shrink.StatsBucket) int64 {
+	var n int64
+
+	for _, bucket := range buckets {
+		n += bucket.Visits
+	}
+
+	return n
+}
+
+// Call the function from many goroutines at once and wait for them to finish.
+func parallel(fn func()) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,41 @@
+package shrinkmyurl
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// Number of random bytes in a management token.
+const tokenSize = 32
+
+// Generate a new management token, returning the token to give to the owner and the hash to store.
+func newToken() (string, string, error) {
+	b := make([]byte, tokenSize)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	return token, hashToken(token), nil
+}
+
+// Hash a management token to be stored with a link. Tokens are random, so a fast hash is enough.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Report whether the token matches the stored hash. Links without a hash match no token.
+func checkToken(hash, token string) bool {
+	if hash == "" || token == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(hashToken(token))) == 1
+}
@@ -0,0 +1,234 @@
+package shrinkmyurl
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTrashRetention     = 30 * 24 * time.Hour
+	defaultTrashPurgeInterval = time.Hour
+)
+
+// Represents a shortened URL in the trash.
+type TrashedRecord struct {
+	Id           string    `json:"id"`
+	ExpandedUrl  string    `json:"expanded_url"`
+	ShortenedUrl string    `json:"shortened_url"`
+	DeletedAt    time.Time `json:"deleted_at"`
+	PurgeAt      time.Time `json:"purge_at"`
+}
+
+// Restore the shortened URL by ID from the trash with its management token.
+// Returns ErrInvalidToken if the token does not match, or ErrNil if the link is not in the trash.
+func (s *Shortener) Restore(ctx context.Context, host url.URL, id, token string) (Record, error) {
+	link, err := s.Store.GetLink(ctx, id)
+
+	if err != nil {
+		return Record{}, err
+	}
+
+	if !checkToken(link.TokenHash, token) {
+		return Record{}, ErrInvalidToken
+	}
+
+	return s.restore(ctx, host, id, link)
+}
+
+// Restore the shortened URL by ID from the trash regardless of its management token, such as on behalf of
+// an admin. Returns ErrNil if the link is not in the trash.
+func (s *Shortener) ForceRestore(ctx context.Context, host url.URL, id string) (Record, error) {
+	link, err := s.Store.GetLink(ctx, id)
+
+	if err != nil {
+		return Record{}, err
+	}
+
+	return s.restore(ctx, host, id, link)
+}
+
+// Take the link out of the trash, unless it was deleted longer ago than the retention and awaits purging.
+func (s *Shortener) restore(ctx context.Context, host url.URL, id string, link Link) (Record, error) {
+	trash, err := s.trash()
+
+	if err != nil {
+		return Record{}, err
+	}
+
+	if link.DeletedAt.IsZero() || !time.Now().Before(s.purgeAt(link.DeletedAt)) {
+		return Record{}, ErrNil
+	}
+
+	if err := trash.RestoreLink(ctx, id); err != nil {
+		return Record{}, err
+	}
+
+	link.DeletedAt = time.Time{}
+
+	return linkRecord(host, id, link), nil
+}
+
+// Get the shortened URLs in the trash that can still be restored, oldest first.
+func (s *Shortener) Trash(ctx context.Context, host url.URL) ([]TrashedRecord, error) {
+	trash, err := s.trash()
+
+	if err != nil {
+		return nil, err
+	}
+
+	links, err := trash.ListTrash(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	records := make([]TrashedRecord, 0, len(links))
+
+	for _, link := range links {
+		purgeAt := s.purgeAt(link.DeletedAt)
+
+		if !now.Before(purgeAt) {
+			continue
+		}
+
+		records = append(records, TrashedRecord{
+			Id:           link.Id,
+			ExpandedUrl:  link.URL,
+			ShortenedUrl: shortenedUrl(host, link.Id),
+			DeletedAt:    link.DeletedAt,
+			PurgeAt:      purgeAt,
+		})
+	}
+
+	return records, nil
+}
+
+// Permanently delete the shortened URLs that have been in the trash for longer than the retention,
+// returning how many were deleted. Stores without a trash have nothing to purge.
+func (s *Shortener) PurgeTrash(ctx context.Context) (int, error) {
+	trash, err := s.trash()
+
+	if err != nil {
+		return 0, nil
+	}
+
+	n, err := trash.PurgeTrash(ctx, time.Now().Add(-s.trashRetention()))
+
+	if errors.Is(err, ErrTrashUnsupported) {
+		return 0, nil
+	}
+
+	return n, err
+}
+
+// Move the link to the trash, or delete it permanently if the store has no trash.
+func (s *Shortener) trashLink(ctx context.Context, id string) error {
+	trash, err := s.trash()
+
+	if err == nil {
+		err = trash.TrashLink(ctx, id)
+	}
+
+	if errors.Is(err, ErrTrashUnsupported) {
+		return s.Store.DeleteLink(ctx, id)
+	}
+
+	return err
+}
+
+// Get the store's trash. Returns ErrTrashUnsupported if it has none.
+func (s *Shortener) trash() (Trasher, error) {
+	trash, ok := s.Store.(Trasher)
+
+	if !ok {
+		return nil, ErrTrashUnsupported
+	}
+
+	return trash, nil
+}
+
+// Get the time at which a link deleted at the given time is purged.
+func (s *Shortener) purgeAt(deletedAt time.Time) time.Time {
+	return deletedAt.Add(s.trashRetention())
+}
+
+// Get how long deleted links are kept in the trash.
+func (s *Shortener) trashRetention() time.Duration {
+	if s.TrashRetention <= 0 {
+		return defaultTrashRetention
+	}
+
+	return s.TrashRetention
+}
+
+// Options for the trash purger.
+type TrashPurgerOptions struct {
+	Shortener *Shortener
+	// How often the trash is purged. Defaults to one hour.
+	Interval time.Duration
+}
+
+// Periodically purges the links that have been in the trash for longer than the shortener's retention.
+type TrashPurger struct {
+	TrashPurgerOptions
+
+	done chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// Create a new trash purger with the given options and start it.
+func NewTrashPurger(ops TrashPurgerOptions) *TrashPurger {
+	if ops.Shortener == nil {
+		panic(ErrShortenerRequired)
+	}
+
+	if ops.Interval <= 0 {
+		ops.Interval = defaultTrashPurgeInterval
+	}
+
+	p := &TrashPurger{TrashPurgerOptions: ops, done: make(chan struct{})}
+
+	p.wg.Add(1)
+	go p.run()
+
+	return p
+}
+
+// Close the trash purger, stopping it.
+func (p *TrashPurger) Close() error {
+	p.once.Do(func() {
+		close(p.done)
+		p.wg.Wait()
+	})
+
+	return nil
+}
+
+// Purge the trash on every interval until the purger is closed.
+func (p *TrashPurger) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			n, err := p.Shortener.PurgeTrash(context.Background())
+
+			if err != nil {
+				log.Printf("trash purger: purge failed: %v", err)
+			} else if n > 0 {
+				log.Printf("trash purger: purged %d links", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package shrinkmyurl
+
+import "strings"
+
+// Device classes of visitors.
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceBot     = "bot"
+)
+
+// The browser, operating system and device class told from a User-Agent header.
+type userAgent struct {
+	browser string
+	os      string
+	device  string
+}
+
+// Markers of the browsers, checked in order, since most browsers also claim to be the ones they are based on.
+var browserMarkers = []struct{ marker, name string }{
+	{"edg", "Edge"},
+	{"opr/", "Opera"},
+	{"opera", "Opera"},
+	{"samsungbrowser", "Samsung Internet"},
+	{"firefox/", "Firefox"},
+	{"fxios", "Firefox"},
+	{"crios", "Chrome"},
+	{"chrome/", "Chrome"},
+	{"chromium/", "Chrome"},
+	{"msie", "Internet Explorer"},
+	{"trident/", "Internet Explorer"},
+	{"safari/", "Safari"},
+}
+
+// Markers of the operating systems, checked in order.
+var osMarkers = []struct{ marker, name string }{
+	{"windows", "Windows"},
+	{"iphone", "iOS"},
+	{"ipad", "iOS"},
+	{"ipod", "iOS"},
+	{"android", "Android"},
+	{"cros", "ChromeOS"},
+	{"mac os x", "macOS"},
+	{"macintosh", "macOS"},
+	{"linux", "Linux"},
+}
+
+// Markers of crawlers, scripts and other automated clients.
+var botMarkers = []string{
+	"bot", "crawl", "spider", "slurp", "preview", "facebookexternalhit", "curl/", "wget/", "python", "go-http-client", "java/",
+}
+
+// Tell the browser, operating system and device class from a User-Agent header, using UnknownValue for what
+// could not be told. Only well-known clients are recognized, so that arbitrary headers are never counted.
+func parseUserAgent(header string) userAgent {
+	agent := userAgent{browser: UnknownValue, os: UnknownValue, device: UnknownValue}
+	ua := strings.ToLower(header)
+
+	if ua == "" {
+		return agent
+	}
+
+	for _, m := range browserMarkers {
+		if strings.Contains(ua, m.marker) {
+			agent.browser = m.name
+			break
+		}
+	}
+
+	for _, m := range osMarkers {
+		if strings.Contains(ua, m.marker) {
+			agent.os = m.name
+			break
+		}
+	}
+
+	switch {
+	case containsAny(ua, botMarkers):
+		agent.device = DeviceBot
+	case containsAny(ua, []string{"ipad", "tablet"}), strings.Contains(ua, "android") && !strings.Contains(ua, "mobile"):
+		agent.device = DeviceTablet
+	case containsAny(ua, []string{"mobi", "iphone", "ipod"}):
+		agent.device = DeviceMobile
+	case agent.os != UnknownValue:
+		agent.device = DeviceDesktop
+	}
+
+	return agent
+}
+
+// Report whether the string contains any of the substrings.
+func containsAny(s string, substrs []string) bool {
+	for _, substr := range substrs {
+		if strings.Contains(s, substr) {
+			return true
+		}
+	}
+
+	return false
+}
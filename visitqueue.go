@@ -0,0 +1,252 @@
+package shrinkmyurl
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultVisitBufferSize    = 10000
+	defaultVisitFlushInterval = time.Second
+
+	// Upper bound on the number of links in a batch, which is flushed early when it is reached, so that the
+	// memory held between flushes is bounded.
+	maxVisitBatch = 1000
+
+	// Upper bound on how long flushing a batch may take, so that an unresponsive store cannot hold up closing.
+	visitFlushTimeout = 10 * time.Second
+)
+
+// The number of visits, and details of visits, counted in the background by a store.
+type VisitQueueStats struct {
+	// Visits and details accepted into the buffer.
+	Queued int64 `json:"queued"`
+	// Visits and details counted by the store.
+	Flushed int64 `json:"flushed"`
+	// Visits and details lost because the buffer was full, the queue was closed or their batch could not be
+	// flushed.
+	Dropped int64 `json:"dropped"`
+}
+
+// A visit to a link waiting in the buffer, either to be counted or, if it has details, to have its details
+// recorded.
+type queuedVisit struct {
+	id    string
+	at    time.Time
+	visit *Visit
+}
+
+// The visits to a link in a batch, with the visits in each of its stats buckets, and the details recorded for
+// it, with the visits of each breakdown field and the unique visitors.
+type visitDelta struct {
+	visits   int64
+	stats    map[string]int64
+	details  int64
+	fields   map[string]int64
+	visitors map[string]struct{}
+}
+
+// The visits to every link in a batch, by link ID.
+type visitBatch map[string]*visitDelta
+
+// Add a visit to the batch, in its hourly and daily buckets, or its details in their breakdown fields and
+// visitors.
+func (b visitBatch) add(v queuedVisit) {
+	delta, ok := b[v.id]
+
+	if !ok {
+		delta = &visitDelta{}
+		b[v.id] = delta
+	}
+
+	if v.visit == nil {
+		if delta.stats == nil {
+			delta.stats = make(map[string]int64, 2)
+		}
+
+		delta.visits++
+		delta.stats[statsField(Hourly, Hourly.bucket(v.at))]++
+		delta.stats[statsField(Daily, Daily.bucket(v.at))]++
+
+		return
+	}
+
+	if delta.fields == nil {
+		delta.fields = make(map[string]int64, len(dimensions))
+		delta.visitors = make(map[string]struct{})
+	}
+
+	delta.details++
+
+	for _, field := range v.visit.fields() {
+		delta.fields[field]++
+	}
+
+	if v.visit.Visitor != "" {
+		delta.visitors[v.visit.Visitor] = struct{}{}
+	}
+}
+
+// Get the number of visits and details in the batch.
+func (b visitBatch) visits() int64 {
+	var n int64
+
+	for _, delta := range b {
+		n += delta.visits + delta.details
+	}
+
+	return n
+}
+
+// Buffers visits in a channel of bounded size, from which a background worker aggregates them into batches
+// of visits per link and flushes them on every interval, when a batch is full, and when the queue is closed.
+type visitQueue struct {
+	flush    func(context.Context, visitBatch) error
+	interval time.Duration
+
+	visits  chan queuedVisit
+	done    chan struct{}
+	wg      sync.WaitGroup
+	once    sync.Once
+	mu      sync.RWMutex
+	closed  bool
+	queued  atomic.Int64
+	flushed atomic.Int64
+	dropped atomic.Int64
+}
+
+// Create a new visit queue buffering up to size visits and flushing them with the given function on every
+// interval, and start its worker.
+func newVisitQueue(size int, interval time.Duration, flush func(context.Context, visitBatch) error) *visitQueue {
+	if size <= 0 {
+		size = defaultVisitBufferSize
+	}
+
+	if interval <= 0 {
+		interval = defaultVisitFlushInterval
+	}
+
+	q := &visitQueue{
+		flush:    flush,
+		interval: interval,
+		visits:   make(chan queuedVisit, size),
+		done:     make(chan struct{}),
+	}
+
+	q.wg.Add(1)
+	go q.run()
+
+	return q
+}
+
+// Queue a visit to a link. The visit is dropped if the buffer is full, so that visits never wait for the store,
+// or if the queue is closed, since it would never be flushed.
+func (q *visitQueue) add(v queuedVisit) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		q.dropped.Add(1)
+		return
+	}
+
+	select {
+	case q.visits <- v:
+		q.queued.Add(1)
+	default:
+		q.dropped.Add(1)
+	}
+}
+
+// Get the number of visits queued, flushed and dropped so far.
+func (q *visitQueue) stats() VisitQueueStats {
+	return VisitQueueStats{Queued: q.queued.Load(), Flushed: q.flushed.Load(), Dropped: q.dropped.Load()}
+}
+
+// Close the queue, stopping its worker once the buffered visits are flushed.
+func (q *visitQueue) Close() error {
+	q.once.Do(func() {
+		// Visits are only sent while holding the read lock, so none are sent once the worker stops.
+		q.mu.Lock()
+		q.closed = true
+		q.mu.Unlock()
+
+		close(q.done)
+		q.wg.Wait()
+	})
+
+	return nil
+}
+
+// Aggregate the buffered visits into batches and flush them until the queue is closed, then flush the visits
+// still buffered.
+func (q *visitQueue) run() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(q.interval)
+	defer ticker.Stop()
+
+	batch := make(visitBatch)
+	var reported int64
+
+	for {
+		select {
+		case v := <-q.visits:
+			batch = q.collect(batch, v)
+		case <-ticker.C:
+			q.send(batch)
+			batch = make(visitBatch)
+
+			if dropped := q.dropped.Load(); dropped > reported {
+				log.Printf("visit queue: dropped %d visits", dropped-reported)
+				reported = dropped
+			}
+		case <-q.done:
+			for {
+				select {
+				case v := <-q.visits:
+					batch = q.collect(batch, v)
+				default:
+					q.send(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// Add a visit to the batch, flushing the batch if it is full. Returns the batch to add the next visit to.
+func (q *visitQueue) collect(batch visitBatch, v queuedVisit) visitBatch {
+	batch.add(v)
+
+	if len(batch) < maxVisitBatch {
+		return batch
+	}
+
+	q.send(batch)
+
+	return make(visitBatch)
+}
+
+// Flush a batch of visits, counting them as dropped if it fails.
+func (q *visitQueue) send(batch visitBatch) {
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), visitFlushTimeout)
+	defer cancel()
+
+	n := batch.visits()
+
+	if err := q.flush(ctx, batch); err != nil {
+		log.Printf("visit queue: flush failed: %v", err)
+		q.dropped.Add(n)
+		return
+	}
+
+	q.flushed.Add(n)
+}
@@ -0,0 +1,268 @@
+package shrinkmyurl
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultBreakdownTop = 10
+	maxBreakdownTop     = 100
+
+	// Upper bound on the length of a counted value, so that visitors cannot make the store hold long strings.
+	maxVisitValueLength = 100
+)
+
+// Primary language subtags, as in BCP 47.
+var languagePattern = regexp.MustCompile(`^[A-Za-z]{2,8}$`)
+
+// Labels for visits without a referrer, for details of a visit that could not be told, and for values first seen
+// once a dimension of a link holds the most values it can.
+const (
+	DirectVisit  = "direct"
+	UnknownValue = "unknown"
+	OtherValue   = "other"
+)
+
+// Upper bound on the number of values counted per dimension of a link, beyond which new values are counted as
+// OtherValue, so that visitors cannot make the store hold unbounded breakdowns.
+const MaxBreakdownValues = 1000
+
+// The details of a visit to a link that are counted per link. A visit holds nothing that identifies the visitor,
+// such as their IP address, full user agent or the full URL they came from.
+type Visit struct {
+	// Salted hash of the visitor's IP address and user agent, which changes every day, used to estimate the
+	// number of unique visitors. Empty if unique visitors are not counted.
+	Visitor string `json:"visitor,omitempty"`
+	// Host of the page the visitor came from, or DirectVisit.
+	Referrer string `json:"referrer"`
+	Browser  string `json:"browser"`
+	OS       string `json:"os"`
+	// Class of the visitor's device: desktop, mobile, tablet or bot.
+	Device string `json:"device"`
+	// Primary subtag of the visitor's preferred language, such as "en".
+	Language string `json:"language"`
+}
+
+// Get the details of the visit made by the request, from its Referer, User-Agent and Accept-Language headers.
+func NewVisit(r *http.Request) Visit {
+	agent := parseUserAgent(r.UserAgent())
+
+	return Visit{
+		Referrer: referrerHost(r.Referer()),
+		Browser:  agent.browser,
+		OS:       agent.os,
+		Device:   agent.device,
+		Language: preferredLanguage(r.Header.Get("Accept-Language")),
+	}
+}
+
+// What the visits to a link are broken down by.
+type Dimension string
+
+const (
+	ByReferrer Dimension = "referrer"
+	ByBrowser  Dimension = "browser"
+	ByOS       Dimension = "os"
+	ByDevice   Dimension = "device"
+	ByLanguage Dimension = "language"
+)
+
+// The dimensions that every visit is counted in.
+var dimensions = []Dimension{ByReferrer, ByBrowser, ByOS, ByDevice, ByLanguage}
+
+// The number of visits to a link with a value of a dimension.
+type BreakdownEntry struct {
+	Value  string `json:"value"`
+	Visits int64  `json:"visits"`
+}
+
+// Get the value of the dimension for the visit, or UnknownValue if it is empty.
+func (v Visit) value(d Dimension) string {
+	var value string
+
+	switch d {
+	case ByReferrer:
+		value = v.Referrer
+	case ByBrowser:
+		value = v.Browser
+	case ByOS:
+		value = v.OS
+	case ByDevice:
+		value = v.Device
+	case ByLanguage:
+		value = v.Language
+	}
+
+	if value == "" {
+		return UnknownValue
+	}
+
+	if len(value) > maxVisitValueLength {
+		n := maxVisitValueLength
+
+		// Cut at the start of a rune, so that the value stays valid UTF-8.
+		for n > 0 && !utf8.RuneStart(value[n]) {
+			n--
+		}
+
+		value = value[:n]
+	}
+
+	return value
+}
+
+// Get the fields counting the visit in every dimension, as stored by the Redis and memory stores, such as
+// "browser:Firefox".
+func (v Visit) fields() []string {
+	fields := make([]string, 0, len(dimensions))
+
+	for _, d := range dimensions {
+		fields = append(fields, string(d)+":"+v.value(d))
+	}
+
+	return fields
+}
+
+// Get the value to count a visit under, given whether the value is already counted and how many values the
+// dimension holds: the value itself, or OtherValue if it is new and the dimension holds the most values it can.
+func breakdownValue(value string, counted bool, values int) string {
+	if counted || values < MaxBreakdownValues {
+		return value
+	}
+
+	return OtherValue
+}
+
+// Get the most common values of every dimension, up to top of them, from the fields of the Redis and memory
+// stores. Values are ordered by their visits, most first, and then by value.
+func topBreakdowns(fields map[string]int64, top int) map[Dimension][]BreakdownEntry {
+	breakdowns := make(map[Dimension][]BreakdownEntry, len(dimensions))
+
+	for _, d := range dimensions {
+		breakdowns[d] = []BreakdownEntry{}
+	}
+
+	for field, visits := range fields {
+		name, value, ok := strings.Cut(field, ":")
+
+		if entries, known := breakdowns[Dimension(name)]; ok && known {
+			breakdowns[Dimension(name)] = append(entries, BreakdownEntry{Value: value, Visits: visits})
+		}
+	}
+
+	for d, entries := range breakdowns {
+		breakdowns[d] = topEntries(entries, top)
+	}
+
+	return breakdowns
+}
+
+// Get up to top of the entries with the most visits, ordered by their visits, most first, and then by value.
+func topEntries(entries []BreakdownEntry, top int) []BreakdownEntry {
+	slices.SortFunc(entries, func(a, b BreakdownEntry) int {
+		if n := cmp.Compare(b.Visits, a.Visits); n != 0 {
+			return n
+		}
+
+		return strings.Compare(a.Value, b.Value)
+	})
+
+	if len(entries) > top {
+		entries = entries[:top]
+	}
+
+	return entries
+}
+
+// Get the host of the referring URL, or DirectVisit if there is none.
+func referrerHost(referrer string) string {
+	if referrer == "" {
+		return DirectVisit
+	}
+
+	u, err := url.Parse(referrer)
+
+	if err != nil || u.Hostname() == "" {
+		return UnknownValue
+	}
+
+	return strings.ToLower(u.Hostname())
+}
+
+// Get the primary subtag of the first language in an Accept-Language header, such as "en" for "en-US,en;q=0.9".
+func preferredLanguage(header string) string {
+	for _, item := range strings.Split(header, ",") {
+		tag, _, _ := strings.Cut(strings.TrimSpace(item), ";")
+		primary, _, _ := strings.Cut(strings.TrimSpace(tag), "-")
+
+		if languagePattern.MatchString(primary) {
+			return strings.ToLower(primary)
+		}
+	}
+
+	return UnknownValue
+}
+
+// Get the ID of a visitor on the day of the given time, a hash of their IP address and user agent keyed by
+// the salt. The day is part of the hash, so that visitors cannot be followed from one day to the next, and
+// the salt is secret, so that the IP address cannot be recovered by hashing every address.
+func hashVisitor(salt []byte, ip, userAgent string, at time.Time) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(at.UTC().Format(time.DateOnly) + "\x00" + ip + "\x00" + userAgent))
+
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Get the ID identifying the visitor with the IP address and user agent on the day of the given time, used
+// as Visit.Visitor. The ID changes every day, and is only the same on another instance with the same
+// VisitorSalt.
+func (s *Shortener) VisitorId(ip, userAgent string, at time.Time) string {
+	return hashVisitor(s.visitorSalt, ip, userAgent, at)
+}
+
+// Record the details of a visit to the shortened URL by ID, which are aggregated into breakdowns of its visits
+// and, if the visit has a visitor, its unique visitors. Visits are not recorded if the store keeps no stats.
+func (s *Shortener) RecordVisit(ctx context.Context, id string, visit Visit) error {
+	stats, err := s.stats()
+
+	if err == nil {
+		err = stats.RecordVisit(ctx, id, visit)
+	}
+
+	if errors.Is(err, ErrStatsUnsupported) {
+		return nil
+	}
+
+	return err
+}
+
+// Get the most common referrers, browsers, operating systems, devices and languages of the visits to the
+// shortened URL by ID over its lifetime, up to top of each. A top of zero gets the default of 10, and
+// ErrInvalidLimit is returned for one out of range.
+func (s *Shortener) Breakdowns(ctx context.Context, id string, top int) (map[Dimension][]BreakdownEntry, error) {
+	if top == 0 {
+		top = defaultBreakdownTop
+	} else if top < 0 || top > maxBreakdownTop {
+		return nil, ErrInvalidLimit
+	}
+
+	stats, err := s.stats()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return stats.LinkBreakdowns(ctx, id, top)
+}
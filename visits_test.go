@@ -0,0 +1,92 @@
+package shrinkmyurl_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	shrink "github.com/derek-schaefer/shrink-my-url"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewVisit(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/abc", nil)
+	request.Header.Set("Referer", "https://News.Example.com:8443/story?id=1")
+	request.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:126.0) Gecko/20100101 Firefox/126.0")
+	request.Header.Set("Accept-Language", "fr-CA,fr;q=0.9,en;q=0.8")
+	request.RemoteAddr = "203.0.113.7:1234"
+
+	visit := shrink.NewVisit(request)
+
+	assert.Equal(t, shrink.Visit{Referrer: "news.example.com", Browser: "Firefox", OS: "Linux", Device: "desktop", Language: "fr"}, visit)
+
+	visit = shrink.NewVisit(httptest.NewRequest(http.MethodGet, "/abc", nil))
+
+	assert.Equal(t, shrink.DirectVisit, visit.Referrer)
+	assert.Equal(t, shrink.UnknownValue, visit.Browser)
+	assert.Equal(t, shrink.UnknownValue, visit.OS)
+	assert.Equal(t, shrink.UnknownValue, visit.Device)
+	assert.Equal(t, shrink.UnknownValue, visit.Language)
+}
+
+func TestNewVisitUserAgent(t *testing.T) {
+	tests := map[string][3]string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/125.0.0.0 Safari/537.36":                         {"Chrome", "Windows", "desktop"},
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/125.0.0.0 Safari/537.36 Edg/125.0.0.0":           {"Edge", "Windows", "desktop"},
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15":                   {"Safari", "macOS", "desktop"},
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1": {"Safari", "iOS", "mobile"},
+		"Mozilla/5.0 (iPad; CPU OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/125.0 Mobile/15E148 Safari/604.1":           {"Chrome", "iOS", "tablet"},
+		"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/125.0.0.0 Mobile Safari/537.36":                   {"Chrome", "Android", "mobile"},
+		"Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/24.0 Chrome/117.0 Safari/537.36":          {"Samsung Internet", "Android", "tablet"},
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":                                                                {"unknown", "unknown", "bot"},
+		"curl/8.6.0": {"unknown", "unknown", "bot"},
+		"something":  {"unknown", "unknown", "unknown"},
+	}
+
+	for agent, expected := range tests {
+		request := httptest.NewRequest(http.MethodGet, "/abc", nil)
+		request.Header.Set("User-Agent", agent)
+
+		visit := shrink.NewVisit(request)
+
+		assert.Equal(t, expected, [3]string{visit.Browser, visit.OS, visit.Device}, agent)
+	}
+}
+
+func TestNewVisitLanguage(t *testing.T) {
+	tests := map[string]string{
+		"en-US,en;q=0.9": "en",
+		"*, de;q=0.5":    "de",
+		"ZH-Hant":        "zh",
+		"<script>":       shrink.UnknownValue,
+	}
+
+	for header, expected := range tests {
+		request := httptest.NewRequest(http.MethodGet, "/abc", nil)
+		request.Header.Set("Accept-Language", header)
+
+		assert.Equal(t, expected, shrink.NewVisit(request).Language, header)
+	}
+}
+
+func TestShortenerRecordVisitLongValues(t *testing.T) {
+	shortener := newTestShortener()
+
+	record := shrink.Must(shortener.Shorten(context.Background(), localURL, "http://example.org", shrink.LinkOptions{}))
+
+	visit := shrink.Visit{
+		Referrer: strings.Repeat("a", 99) + "ü.example.com",
+		Browser:  strings.Repeat("ü", 60),
+	}
+
+	assert.Nil(t, shortener.RecordVisit(context.Background(), record.Id, visit))
+
+	breakdowns := shrink.Must(shortener.Breakdowns(context.Background(), record.Id, 0))
+
+	assert.Equal(t, strings.Repeat("a", 99), breakdowns[shrink.ByReferrer][0].Value, "values are cut before a rune that does not fit")
+	assert.Equal(t, strings.Repeat("ü", 50), breakdowns[shrink.ByBrowser][0].Value)
+	assert.True(t, utf8.ValidString(breakdowns[shrink.ByReferrer][0].Value))
+}